@@ -0,0 +1,75 @@
+package chart
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.aporeto.io/simulator-test-harness/common"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// FieldManager is the field manager of the objects applied by this package.
+const FieldManager = "simulator-test-harness"
+
+// WriteManifests writes m to dir/<deployment name>.yaml, e.g. for a GitOps repository, and returns
+// the path of the file.
+func WriteManifests(dir string, m *Manifests) (string, error) {
+
+	data, err := m.Marshal()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("create %s: %v", dir, err)
+	}
+
+	manifestFile := filepath.Join(dir, m.Deployment.Name+".yaml")
+	if err := os.WriteFile(manifestFile, data, 0644); err != nil {
+		return "", fmt.Errorf("write manifests to %q: %v", manifestFile, err)
+	}
+
+	return manifestFile, nil
+}
+
+// Apply creates the objects of m, or updates them if they already exist, like kubectl apply.
+func Apply(ctx context.Context, cs kubernetes.Interface, m *Manifests) error {
+
+	opts := metav1.CreateOptions{FieldManager: FieldManager}
+	updateOpts := metav1.UpdateOptions{FieldManager: FieldManager}
+
+	configMaps := cs.CoreV1().ConfigMaps(m.ConfigMap.Namespace)
+	cm, err := configMaps.Get(ctx, m.ConfigMap.Name, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err):
+		_, err = configMaps.Create(ctx, m.ConfigMap, opts)
+	case err == nil:
+		update := m.ConfigMap.DeepCopy()
+		update.ResourceVersion = cm.ResourceVersion
+		_, err = configMaps.Update(ctx, update, updateOpts)
+	}
+	if err != nil {
+		return fmt.Errorf("apply configmap %s/%s: %v", m.ConfigMap.Namespace, m.ConfigMap.Name, err)
+	}
+
+	deployments := cs.AppsV1().Deployments(m.Deployment.Namespace)
+	dep, err := deployments.Get(ctx, m.Deployment.Name, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err):
+		_, err = deployments.Create(ctx, m.Deployment, opts)
+	case err == nil:
+		update := m.Deployment.DeepCopy()
+		update.ResourceVersion = dep.ResourceVersion
+		_, err = deployments.Update(ctx, update, updateOpts)
+	}
+	if err != nil {
+		return fmt.Errorf("apply deployment %s/%s: %v", m.Deployment.Namespace, m.Deployment.Name, err)
+	}
+
+	common.Log.Infof("Applied deployment %s/%s", m.Deployment.Namespace, m.Deployment.Name)
+	return nil
+}
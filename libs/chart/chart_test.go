@@ -0,0 +1,207 @@
+package chart
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.aporeto.io/simulator-test-harness/libs/faultproxy"
+	"go.aporeto.io/simulator-test-harness/libs/plan"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var update = flag.Bool("update", false, "Update the golden files")
+
+func testValues() *Values {
+
+	v := DefaultValues()
+	v.DepName = "sim-pods-abc123"
+	v.K8sNS = "sim-ns"
+	v.K8sSecret = "enforcerd"
+	v.Image = Image{Name: "docker.io/aporeto/enforcerd", Tag: "release-5.0.14"}
+	v.SimulatorImage = Image{Name: "docker.io/aporeto/plan-gen", Tag: "v1"}
+
+	return v
+}
+
+func TestDefaultValues(t *testing.T) {
+
+	v, err := LoadValues("../../utils/simulator/charts/enforcer-sim/values.yaml")
+	if err != nil {
+		t.Fatalf("LoadValues() error = %v", err)
+	}
+	if !reflect.DeepEqual(v, DefaultValues()) {
+		t.Errorf("DefaultValues() does not match the chart values.yaml:\n%+v\n%+v", DefaultValues(), v)
+	}
+}
+
+func TestRender(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		values  func(v *Values)
+		wantErr bool
+	}{
+		{
+			name:   "default",
+			values: func(v *Values) {},
+		},
+		{
+			name: "custom",
+			values: func(v *Values) {
+				v.Pods = 3
+				v.SimulatorsPerPod = 2
+				v.PUType = "Docker"
+				v.PUMeta = []string{"@simulated=true"}
+				v.HostServices = []*plan.HostService{{Name: "ssh", Services: []string{"tcp/22"}}}
+				v.CompactPlans = true
+				v.PULife.PUIter = "infinite"
+				v.PULife.Scenarios = []*plan.Scenario{
+					{Type: plan.ScenarioCrash, Ratio: 0.1, Rate: 0.2},
+				}
+				v.Log.Level = "debug"
+				v.EnforcerOpts.HandleOfflineAPI = true
+				v.EnforcerTagPrefix = "ab12c0"
+				v.EnforcerTag = "simbase=ab12c-1"
+			},
+		},
+		{
+			name: "fault-proxy",
+			values: func(v *Values) {
+				v.FaultProxy.Enabled = true
+				v.FaultProxy.Upstream = "https://api.example.com"
+				v.FaultProxy.TLSSecret = "fault-proxy-tls"
+				v.FaultProxy.Schedule = faultproxy.Schedule{
+					Repeat: 30 * time.Minute,
+					Faults: []*faultproxy.Fault{
+						{Type: faultproxy.FaultLatency, Start: time.Minute, Latency: time.Second},
+						{Type: faultproxy.FaultPartition, Start: 10 * time.Minute,
+							Duration: 2 * time.Minute},
+					},
+				}
+			},
+		},
+		{
+			name: "per-pod",
+			values: func(v *Values) {
+				v.Pods = 2
+				v.SimulatorsPerPod = 2
+				v.EnforcerTagPrefix = "ab12c-1"
+				v.EnforcerTag = "simbase=ab12c-1"
+				v.PodTagPrefix = "ab12c-1"
+			},
+		},
+		{
+			name:    "missing images",
+			values:  func(v *Values) { v.Image = Image{} },
+			wantErr: true,
+		},
+		{
+			name:    "invalid deployment name",
+			values:  func(v *Values) { v.DepName = `"quoted"` },
+			wantErr: true,
+		},
+		{
+			name:    "invalid enforcer tag",
+			values:  func(v *Values) { v.EnforcerTag = "simbase" },
+			wantErr: true,
+		},
+		{
+			name:    "invalid pod tag prefix",
+			values:  func(v *Values) { v.PodTagPrefix = "ab12c 1" },
+			wantErr: true,
+		},
+		{
+			name:    "invalid PU iterations",
+			values:  func(v *Values) { v.PULife.PUIter = "forever" },
+			wantErr: true,
+		},
+		{
+			name: "invalid fault proxy upstream",
+			values: func(v *Values) {
+				v.FaultProxy.Enabled = true
+				v.FaultProxy.Upstream = "api.example.com"
+			},
+			wantErr: true,
+		},
+		{
+			name:    "invalid PU metadata",
+			values:  func(v *Values) { v.PUMeta = []string{"simulated=true"} },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := testValues()
+			tt.values(v)
+			m, err := Render(v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got, err := m.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+
+			golden := filepath.Join("testdata", tt.name+".golden.yaml")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("manifests differ from %s (run with -update to update):\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+
+	ctx := context.Background()
+	cs := fake.NewSimpleClientset()
+
+	v := testValues()
+	m, err := Render(v)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if err := Apply(ctx, cs, m); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	v.Pods = 5
+	if m, err = Render(v); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if err := Apply(ctx, cs, m); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	dep, err := cs.AppsV1().Deployments(v.K8sNS).Get(ctx, v.DepName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get deployment: %v", err)
+	}
+	if *dep.Spec.Replicas != 5 {
+		t.Errorf("got %d replicas, want 5", *dep.Spec.Replicas)
+	}
+	_, err = cs.CoreV1().ConfigMaps(v.K8sNS).Get(ctx, PlanConfigName, metav1.GetOptions{})
+	if err != nil {
+		t.Errorf("get configmap: %v", err)
+	}
+}
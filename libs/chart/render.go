@@ -0,0 +1,360 @@
+package chart
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// PlanConfigName is the name of the ConfigMap holding the plan-gen configuration.
+const PlanConfigName = "plan-gen-config"
+
+// FaultProxyScheduleKey is the key of the fault proxy schedule in the plan-gen ConfigMap.
+const FaultProxyScheduleKey = "faultproxy.yaml"
+
+// FaultProxyContainer is the name of the fault proxy sidecar container, the only container of the
+// pods which is not a simulator.
+const FaultProxyContainer = "fault-proxy"
+
+const (
+	// PodTagLabel is the label of the pods holding the value of their npod tag, once their slot
+	// is assigned.
+	PodTagLabel = "npod"
+	// PodSlotUnassigned is the suffix of the podTagPrefix of the value of PodTagLabel until the
+	// slot of the pod is assigned.
+	PodSlotUnassigned = "unassigned"
+	// PodSlotWait is the time the pods wait for their slot before starting the simulators
+	// anyway, tagged as unassigned.
+	PodSlotWait = 5 * time.Minute
+)
+
+// A Manifests is the kubernetes objects of the enforcer-sim chart.
+type Manifests struct {
+	ConfigMap  *corev1.ConfigMap
+	Deployment *appsv1.Deployment
+}
+
+// Render validates v and renders the manifests of the chart.
+func Render(v *Values) (*Manifests, error) {
+
+	if err := v.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid values: %v", err)
+	}
+
+	config, err := yaml.Marshal(v.PlanConfig())
+	if err != nil {
+		return nil, fmt.Errorf("marshal plan configuration: %v", err)
+	}
+
+	data := map[string]string{
+		"config.yaml": string(config),
+	}
+	if v.FaultProxy.Enabled {
+		schedule, err := yaml.Marshal(&v.FaultProxy.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("marshal fault proxy schedule: %v", err)
+		}
+		data[FaultProxyScheduleKey] = string(schedule)
+	}
+
+	return &Manifests{
+		ConfigMap: &corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      PlanConfigName,
+				Namespace: v.K8sNS,
+			},
+			Data: data,
+		},
+		Deployment: deployment(v),
+	}, nil
+}
+
+// Marshal serializes m into a multi-document yaml, which can be given to kubectl apply.
+func (m *Manifests) Marshal() ([]byte, error) {
+
+	var buf bytes.Buffer
+	for _, obj := range []interface{}{m.ConfigMap, m.Deployment} {
+		data, err := sigsyaml.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("marshal manifest: %v", err)
+		}
+		buf.WriteString("---\n")
+		buf.Write(data)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Selector returns the label selector of the pods of the deployment of v.
+func (v *Values) Selector() string {
+
+	return fmt.Sprintf("nsim=simulator-%s,app=simulator", v.EnforcerTagPrefix)
+}
+
+// deployment returns the enforcer-sim deployment: an init container generating a plan per
+// simulator, the simulator containers and the fault proxy sidecar, if enabled.
+func deployment(v *Values) *appsv1.Deployment {
+
+	labels := map[string]string{
+		"nsim": "simulator-" + v.EnforcerTagPrefix,
+		"app":  "simulator",
+	}
+	podLabels := map[string]string{}
+	for k, l := range labels {
+		podLabels[k] = l
+	}
+	replicas := int32(v.Pods)
+
+	compact := ""
+	if v.CompactPlans {
+		compact = " -compact"
+	}
+	planGen := fmt.Sprintf(`for i in $(seq 0 %d); do
+  mkdir -p /plans/plan-${i}
+  plan-gen -config /config/config.yaml -output /plans/plan-${i}/plan.yaml%s
+ done
+`, v.SimulatorsPerPod-1, compact)
+	initMounts := []corev1.VolumeMount{
+		{MountPath: "/plans", Name: "plans"},
+		{MountPath: "/config", Name: "plan-config"},
+	}
+
+	volumes := []corev1.Volume{
+		{
+			Name: "creds",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: v.K8sSecret},
+			},
+		},
+		{
+			Name:         "plans",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+	}
+
+	// The pods wait for the run to label them with their slot, which the simulators read through
+	// the downward API once started.
+	if v.PodTagPrefix != "" {
+		unassigned := v.PodTagPrefix + "-" + PodSlotUnassigned
+		podLabels[PodTagLabel] = unassigned
+		planGen = fmt.Sprintf(`n=0
+while [ "$(cat /podinfo/%s)" = "%s" ] && [ $n -lt %d ]; do
+  sleep 1
+  n=$((n+1))
+done
+`, PodTagLabel, unassigned, int(PodSlotWait.Seconds())) + planGen
+		initMounts = append(initMounts, corev1.VolumeMount{MountPath: "/podinfo", Name: "podinfo"})
+		volumes = append(volumes, corev1.Volume{
+			Name: "podinfo",
+			VolumeSource: corev1.VolumeSource{
+				DownwardAPI: &corev1.DownwardAPIVolumeSource{
+					Items: []corev1.DownwardAPIVolumeFile{{Path: PodTagLabel,
+						FieldRef: podTagField()}},
+				},
+			},
+		})
+	}
+
+	var containers []corev1.Container
+	for sim := 0; sim < v.SimulatorsPerPod; sim++ {
+		workingDir := fmt.Sprintf("working-dir-%d", sim)
+		containers = append(containers, simulator(v, sim, workingDir))
+		volumes = append(volumes, corev1.Volume{
+			Name:         workingDir,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+
+	volumes = append(volumes, corev1.Volume{
+		Name: "plan-config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: PlanConfigName},
+			},
+		},
+	})
+
+	if v.FaultProxy.Enabled {
+		containers = append(containers, faultProxy(v))
+		if v.FaultProxy.TLSSecret != "" {
+			volumes = append(volumes, corev1.Volume{
+				Name: "fault-proxy-tls",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{SecretName: v.FaultProxy.TLSSecret},
+				},
+			})
+		}
+	}
+
+	spread := func(topologyKey string) corev1.TopologySpreadConstraint {
+		return corev1.TopologySpreadConstraint{
+			MaxSkew:           1,
+			TopologyKey:       topologyKey,
+			WhenUnsatisfiable: corev1.DoNotSchedule,
+			LabelSelector:     &metav1.LabelSelector{MatchLabels: labels},
+		}
+	}
+
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      v.DepName,
+			Namespace: v.K8sNS,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Replicas: &replicas,
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: podLabels,
+					Annotations: map[string]string{
+						"cluster-autoscaler.kubernetes.io/safe-to-evict": "true",
+					},
+				},
+				Spec: corev1.PodSpec{
+					TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+						spread("topology.kubernetes.io/zone"),
+						spread("kubernetes.io/hostname"),
+					},
+					RestartPolicy: corev1.RestartPolicyAlways,
+					InitContainers: []corev1.Container{
+						{
+							Name:            "plan-gen",
+							Image:           v.SimulatorImage.String(),
+							ImagePullPolicy: corev1.PullIfNotPresent,
+							Command:         []string{"sh", "-c", planGen},
+							VolumeMounts:    initMounts,
+						},
+					},
+					Containers:   containers,
+					Volumes:      volumes,
+					NodeSelector: map[string]string{"pods": "workload"},
+				},
+			},
+		},
+	}
+}
+
+// simulator returns the container of the simulator sim, using the workingDir volume.
+func simulator(v *Values, sim int, workingDir string) corev1.Container {
+
+	tags := fmt.Sprintf("nsim=%s-%d %s", v.EnforcerTagPrefix, sim, v.EnforcerTag)
+	if v.PodTagPrefix != "" {
+		tags += " " + PodTagLabel + "=$(POD_TAG)"
+	}
+	env := []struct{ name, value string }{
+		{"ENFORCERD_TAG", tags},
+		{"ENFORCERD_APPCREDS", "/creds/aporeto.creds"},
+		{"ENFORCERD_LOG_LEVEL", v.Log.Level},
+		{"ENFORCERD_LOG_FORMAT", v.Log.Format},
+		{"ENFORCERD_SIMULATED_DATA_PLANE_PLAN", "/plan/plan.yaml"},
+		{"ENFORCERD_LOG_TO_CONSOLE", strconv.Itoa(v.Log.ToConsole)},
+		{"ENFORCERD_DISABLE_LOG_WRITE", strconv.FormatBool(v.Log.DisableWrite)},
+		{"ENFORCERD_ENABLE_CONTAINERS", "true"},
+		{"ENFORCERD_HANDLE_API_OFFLINE", strconv.FormatBool(v.EnforcerOpts.HandleOfflineAPI)},
+		{"ENFORCERD_POLICIES_SYNC_INTERVAL", v.EnforcerOpts.PoliciesSync},
+		{"ENFORCERD_DYNAMIC_TAGS_SYNC_INTERVAL", v.EnforcerOpts.TagSync},
+		{"ENFORCERD_CERTIFICATE_RENEWAL_INTERVAL", v.EnforcerOpts.CertRenewal},
+		{"ENFORCERD_PUS_SYNC_INTERVAL_JITTER", v.EnforcerJitters.PUSync},
+		{"ENFORCERD_PUS_FAILURE_RETRY_INTERVAL_JITTER", v.EnforcerJitters.PUFailureRetry},
+		{"ENFORCERD_PUS_STATUS_UPDATE_RETRY_INTERVAL_JITTER", v.EnforcerJitters.PUStatusUpdateRetry},
+		{"ENFORCERD_POLICIES_SYNC_INTERVAL_JITTER", v.EnforcerJitters.PoliciesSync},
+		{"ENFORCERD_API_RECONNECT_INTERVAL_JITTER", v.EnforcerJitters.APIReconnect},
+		{"ENFORCERD_FLOW_REPORTING_DISPATCH_INTERVAL_JITTER", v.EnforcerJitters.FlowReportDispatch},
+		{"ENFORCERD_CERTIFICATE_RENEWAL_INTERVAL_JITTER", v.EnforcerJitters.CertRenewal},
+		{"ENFORCERD_DYNAMIC_TAGS_SYNC_INTERVAL_JITTER", v.EnforcerJitters.TagSync},
+	}
+
+	c := corev1.Container{
+		Name:            fmt.Sprintf("simulator-%d", sim),
+		Image:           v.Image.String(),
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceMemory:           resource.MustParse("60Mi"),
+				corev1.ResourceCPU:              resource.MustParse("10m"),
+				corev1.ResourceEphemeralStorage: resource.MustParse("2Gi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceEphemeralStorage: resource.MustParse("4Gi"),
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{MountPath: "/creds", Name: "creds", ReadOnly: true},
+			{MountPath: "/var/lib/prisma-enforcer", Name: workingDir},
+			{MountPath: "/plan", Name: "plans", ReadOnly: true, SubPath: fmt.Sprintf("plan-%d", sim)},
+		},
+	}
+	// POD_TAG is defined first for ENFORCERD_TAG to expand it.
+	if v.PodTagPrefix != "" {
+		c.Env = append(c.Env, corev1.EnvVar{Name: "POD_TAG",
+			ValueFrom: &corev1.EnvVarSource{FieldRef: podTagField()}})
+	}
+	for _, e := range env {
+		c.Env = append(c.Env, corev1.EnvVar{Name: e.name, Value: e.value})
+	}
+	if api := v.FaultProxy.APIURL(); api != "" {
+		c.Env = append(c.Env, corev1.EnvVar{Name: "ENFORCERD_API", Value: api})
+	}
+
+	return c
+}
+
+// podTagField returns the selector of the pod label holding the value of the npod tag.
+func podTagField() *corev1.ObjectFieldSelector {
+
+	return &corev1.ObjectFieldSelector{
+		FieldPath: fmt.Sprintf("metadata.labels['%s']", PodTagLabel),
+	}
+}
+
+// faultProxy returns the fault proxy sidecar, forwarding the requests of the simulators to the API
+// and injecting the faults of its schedule.
+func faultProxy(v *Values) corev1.Container {
+
+	p := v.FaultProxy
+	command := []string{
+		"faultproxy",
+		"-listen", fmt.Sprintf(":%d", p.Port),
+		"-upstream", p.Upstream,
+		"-schedule", "/config/" + FaultProxyScheduleKey,
+	}
+	mounts := []corev1.VolumeMount{
+		{MountPath: "/config", Name: "plan-config", ReadOnly: true},
+	}
+	if p.InsecureUpstream {
+		command = append(command, "-insecure-upstream")
+	}
+	if p.TLSSecret != "" {
+		command = append(command, "-cert", "/tls/tls.crt", "-key", "/tls/tls.key")
+		mounts = append(mounts, corev1.VolumeMount{
+			MountPath: "/tls",
+			Name:      "fault-proxy-tls",
+			ReadOnly:  true,
+		})
+	}
+
+	return corev1.Container{
+		Name:            FaultProxyContainer,
+		Image:           v.SimulatorImage.String(),
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         command,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("30Mi"),
+				corev1.ResourceCPU:    resource.MustParse("10m"),
+			},
+		},
+		VolumeMounts: mounts,
+	}
+}
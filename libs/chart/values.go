@@ -0,0 +1,361 @@
+// Package chart renders the manifests of the enforcer-sim chart (see utils/simulator/charts) from
+// typed values, so that they can be written to disk or applied without shelling out to helm.
+package chart
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.aporeto.io/simulator-test-harness/libs/faultproxy"
+	"go.aporeto.io/simulator-test-harness/libs/plan"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// A Values is the values of the enforcer-sim chart. It mirrors the chart values.yaml.
+type Values struct {
+	// Pods is the number of pods (i.e. deployment replicas).
+	Pods int `yaml:"pods"`
+	// SimulatorsPerPod is the number of simulator containers per pod.
+	SimulatorsPerPod int `yaml:"simulatorsPerPod"`
+	// PUsPerSimulator is the number of PUs per simulator.
+	PUsPerSimulator int `yaml:"pusPerSimulator"`
+	// PUType is the type of the PUs, either a gaia.ProcessingUnitTypeValue or "random".
+	PUType string `yaml:"puType"`
+	// PUMeta are the external tags for each PU, which must all start with "@". If nil, plan-gen
+	// defaults are used.
+	PUMeta []string `yaml:"puMeta"`
+	// FlowsPerPU is the number of flows per PU.
+	FlowsPerPU int `yaml:"flowsPerPU"`
+	// HostServices are the host services of the simulators, each generated as an extra PU (see
+	// plan.Config).
+	HostServices []*plan.HostService `yaml:"hostServices"`
+	// CompactPlans selects the compact plans, where the zero valued fields are omitted.
+	CompactPlans bool `yaml:"compactPlans"`
+
+	PULife          PULife          `yaml:"puLife"`
+	Jitter          Jitter          `yaml:"jitter"`
+	Image           Image           `yaml:"image"`
+	SimulatorImage  Image           `yaml:"simulatorImage"`
+	Log             Log             `yaml:"log"`
+	EnforcerOpts    EnforcerOpts    `yaml:"enforcerOpts"`
+	EnforcerJitters EnforcerJitters `yaml:"enforcerJitters"`
+	FaultProxy      FaultProxy      `yaml:"faultProxy"`
+
+	// EnforcerTagPrefix is the prefix of the nsim=<prefix>-$i tag of each simulator.
+	EnforcerTagPrefix string `yaml:"enforcerTagPrefix"`
+	// EnforcerTag is the key=value tag shared by all the simulators.
+	EnforcerTag string `yaml:"enforcerTag"`
+	// PodTagPrefix, if set, tags the simulators of every pod with npod=<prefix>-<slot>, the slot
+	// being the PodTagLabel value set on the pod by the run (see tagging.StrategyPerPod).
+	PodTagPrefix string `yaml:"podTagPrefix"`
+
+	// DepName is the name of the deployment.
+	DepName string `yaml:"depName"`
+	// K8sNS is the kubernetes namespace of the deployment.
+	K8sNS string `yaml:"k8sNS"`
+	// K8sSecret is the name of the secret holding the enforcer application credentials.
+	K8sSecret string `yaml:"k8sSecret"`
+}
+
+// A PULife is the PU lifecycle of the simulators.
+type PULife struct {
+	// PUIter is the number of PU lifecycle iterations, which can be "infinite".
+	PUIter string `yaml:"puIter"`
+	// PUInterval is the interval between PU lifecycle iterations, in seconds.
+	PUInterval int `yaml:"puInterval"`
+	// PUCleanup is the delay of the PU cleanup of each lifecycle, in seconds.
+	PUCleanup int `yaml:"puCleanup"`
+	// FlowIter is the number of flow iterations of each PU lifecycle, which can be "infinite".
+	FlowIter string `yaml:"flowIter"`
+	// FlowInterval is the interval between flow batches, in seconds.
+	FlowInterval int `yaml:"flowInterval"`
+	// DNSReportRate is the number of DNS reports per PU per minute.
+	DNSReportRate string `yaml:"dnsReportRate"`
+	// Scenarios are the lifecycle scenarios run on a ratio of the PUs.
+	Scenarios []*plan.Scenario `yaml:"scenarios"`
+}
+
+// A Jitter is the jitter of the PU lifecycle operations.
+type Jitter struct {
+	// Variance is the jitter variance of each value, in percents.
+	Variance int `yaml:"variance"`
+	// PUStart is the jitter of the PU starts, in seconds.
+	PUStart int `yaml:"puStart"`
+	// PUReport is the jitter of the PU reports, in seconds.
+	PUReport int `yaml:"puReport"`
+	// FlowReport is the jitter of the flow reports, in milliseconds.
+	FlowReport int `yaml:"flowReport"`
+}
+
+// An Image is a container image.
+type Image struct {
+	// Name is the full name of the image, including the registry and organization.
+	Name string `yaml:"name"`
+	Tag  string `yaml:"tag"`
+}
+
+// String returns the reference of the image.
+func (i Image) String() string {
+
+	return i.Name + ":" + i.Tag
+}
+
+// A Log is the logging configuration of the simulators.
+type Log struct {
+	Level        string `yaml:"level"`
+	Format       string `yaml:"format"`
+	ToConsole    int    `yaml:"toConsole"`
+	DisableWrite bool   `yaml:"disableWrite"`
+}
+
+// An EnforcerOpts is the enforcer configuration of the simulators.
+type EnforcerOpts struct {
+	PoliciesSync     string `yaml:"policiesSync"`
+	CertRenewal      string `yaml:"certRenewal"`
+	TagSync          string `yaml:"tagSync"`
+	HandleOfflineAPI bool   `yaml:"handleOfflineAPI"`
+}
+
+// An EnforcerJitters is the jitter of the enforcer operations, in percents (e.g. "20%").
+type EnforcerJitters struct {
+	PUSync              string `yaml:"puSync"`
+	PUFailureRetry      string `yaml:"puFailureRetry"`
+	PUStatusUpdateRetry string `yaml:"puStatusUpdateRetry"`
+	PoliciesSync        string `yaml:"policiesSync"`
+	APIReconnect        string `yaml:"apiReconnect"`
+	FlowReportDispatch  string `yaml:"flowReportDispatch"`
+	CertRenewal         string `yaml:"certRenewal"`
+	TagSync             string `yaml:"tagSync"`
+}
+
+// A FaultProxy is the fault injection proxy between the simulators and the API (see
+// libs/faultproxy).
+type FaultProxy struct {
+	// Enabled runs the proxy as a sidecar of every pod, the simulators reaching the API through it.
+	Enabled bool `yaml:"enabled"`
+	// API is the URL of the API reached by the simulators, e.g. a shared proxy Service, overriding
+	// the sidecar and the API of the app credentials if set.
+	API string `yaml:"api"`
+	// Upstream is the URL of the API to which the sidecar forwards the requests.
+	Upstream string `yaml:"upstream"`
+	// InsecureUpstream skips the verification of the upstream API certificate.
+	InsecureUpstream bool `yaml:"insecureUpstream"`
+	// Port is the port of the sidecar.
+	Port int `yaml:"port"`
+	// TLSSecret is the name of the kubernetes.io/tls secret of the certificate served by the
+	// sidecar, which serves plain HTTP if empty.
+	TLSSecret string `yaml:"tlsSecret"`
+	// Schedule is the faults injected by the sidecar.
+	Schedule faultproxy.Schedule `yaml:"schedule"`
+}
+
+// DefaultValues returns the default values of the chart, as in its values.yaml.
+func DefaultValues() *Values {
+
+	return &Values{
+		Pods:             1,
+		SimulatorsPerPod: 10,
+		PUsPerSimulator:  20,
+		PUType:           "random",
+		FlowsPerPU:       50,
+		HostServices:     []*plan.HostService{},
+		PULife: PULife{
+			PUIter:        "1",
+			PUInterval:    30,
+			PUCleanup:     1,
+			FlowIter:      "12",
+			FlowInterval:  60,
+			DNSReportRate: "1",
+			Scenarios:     []*plan.Scenario{},
+		},
+		Jitter: Jitter{
+			Variance:   20,
+			PUStart:    10,
+			PUReport:   1,
+			FlowReport: 500,
+		},
+		Log: Log{
+			Level:     "info",
+			Format:    "console",
+			ToConsole: 1,
+		},
+		EnforcerOpts: EnforcerOpts{
+			PoliciesSync: "20m",
+			CertRenewal:  "96h",
+			TagSync:      "1m",
+		},
+		EnforcerJitters: EnforcerJitters{
+			PUSync:              "20%",
+			PUFailureRetry:      "20%",
+			PUStatusUpdateRetry: "20%",
+			PoliciesSync:        "20%",
+			APIReconnect:        "20%",
+			FlowReportDispatch:  "20%",
+			CertRenewal:         "20%",
+			TagSync:             "20%",
+		},
+		FaultProxy: FaultProxy{
+			Port:     8443,
+			Schedule: faultproxy.Schedule{Faults: []*faultproxy.Fault{}},
+		},
+		EnforcerTagPrefix: "simulator",
+		EnforcerTag:       "simbase=simulator",
+	}
+}
+
+// LoadValues parses the values stored in valuesFile on top of the default values, as helm does
+// with -f.
+func LoadValues(valuesFile string) (*Values, error) {
+
+	data, err := os.ReadFile(valuesFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", valuesFile, err)
+	}
+
+	v := DefaultValues()
+	if err := yaml.Unmarshal(data, v); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %v", valuesFile, err)
+	}
+
+	return v, nil
+}
+
+// Validate checks that v can be rendered into valid manifests.
+func (v *Values) Validate() error {
+
+	if errs := validation.IsDNS1123Subdomain(v.DepName); len(errs) > 0 {
+		return fmt.Errorf("invalid depName %q: %s", v.DepName, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Label(v.K8sNS); len(errs) > 0 {
+		return fmt.Errorf("invalid k8sNS %q: %s", v.K8sNS, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Subdomain(v.K8sSecret); len(errs) > 0 {
+		return fmt.Errorf("invalid k8sSecret %q: %s", v.K8sSecret, strings.Join(errs, ", "))
+	}
+	if v.Pods < 0 {
+		return fmt.Errorf("invalid number of pods %d", v.Pods)
+	}
+	if v.SimulatorsPerPod < 1 {
+		return fmt.Errorf("invalid number of simulators per pod %d", v.SimulatorsPerPod)
+	}
+	images := map[string]Image{"image": v.Image, "simulatorImage": v.SimulatorImage}
+	for name, image := range images {
+		if image.Name == "" || image.Tag == "" {
+			return fmt.Errorf("%s name and tag must be set", name)
+		}
+	}
+	if v.EnforcerTagPrefix == "" {
+		return fmt.Errorf("enforcerTagPrefix must be set")
+	}
+	k, _, ok := strings.Cut(v.EnforcerTag, "=")
+	if !ok || k == "" || strings.Contains(v.EnforcerTag, " ") {
+		return fmt.Errorf("invalid enforcerTag %q, expected key=value", v.EnforcerTag)
+	}
+	if v.PodTagPrefix != "" {
+		unassigned := v.PodTagPrefix + "-" + PodSlotUnassigned
+		if errs := validation.IsValidLabelValue(unassigned); len(errs) > 0 {
+			return fmt.Errorf("invalid podTagPrefix %q: %s", v.PodTagPrefix,
+				strings.Join(errs, ", "))
+		}
+	}
+	iters := map[string]string{"puIter": v.PULife.PUIter, "flowIter": v.PULife.FlowIter}
+	for name, iter := range iters {
+		if _, err := strconv.Atoi(iter); err != nil && iter != "infinite" {
+			return fmt.Errorf("invalid puLife.%s %q, expected a number or infinite", name, iter)
+		}
+	}
+	if v.PULife.PUInterval < 0 || v.PULife.PUCleanup < 0 || v.PULife.FlowInterval < 0 {
+		return fmt.Errorf("negative puLife interval or cleanup")
+	}
+	j := v.Jitter
+	if j.Variance < 0 || j.PUStart < 0 || j.PUReport < 0 || j.FlowReport < 0 {
+		return fmt.Errorf("negative jitter")
+	}
+	if err := v.PlanConfig().Validate(); err != nil {
+		return fmt.Errorf("invalid plan configuration: %v", err)
+	}
+	if err := v.FaultProxy.validate(); err != nil {
+		return fmt.Errorf("invalid faultProxy: %v", err)
+	}
+
+	return nil
+}
+
+// validate checks that the sidecar of p, if enabled, can be rendered.
+func (p *FaultProxy) validate() error {
+
+	if p.API != "" {
+		if u, err := url.Parse(p.API); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid api %q, expected an absolute URL", p.API)
+		}
+	}
+	if !p.Enabled {
+		return nil
+	}
+
+	if u, err := url.Parse(p.Upstream); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid upstream %q, expected an absolute URL", p.Upstream)
+	}
+	if p.Port < 1 || p.Port > 65535 {
+		return fmt.Errorf("invalid port %d", p.Port)
+	}
+	if p.TLSSecret != "" {
+		if errs := validation.IsDNS1123Subdomain(p.TLSSecret); len(errs) > 0 {
+			return fmt.Errorf("invalid tlsSecret %q: %s", p.TLSSecret, strings.Join(errs, ", "))
+		}
+	}
+	if err := p.Schedule.Validate(); err != nil {
+		return fmt.Errorf("invalid schedule: %v", err)
+	}
+
+	return nil
+}
+
+// APIURL returns the URL of the API reached by the simulators through p, or an empty string if
+// they use the API of their app credentials.
+func (p *FaultProxy) APIURL() string {
+
+	switch {
+	case p.API != "":
+		return p.API
+	case !p.Enabled:
+		return ""
+	case p.TLSSecret != "":
+		return fmt.Sprintf("https://localhost:%d", p.Port)
+	default:
+		return fmt.Sprintf("http://localhost:%d", p.Port)
+	}
+}
+
+// PlanConfig returns the plan-gen configuration of the simulators.
+func (v *Values) PlanConfig() *plan.Config {
+
+	return &plan.Config{
+		Name:         v.DepName,
+		PUs:          v.PUsPerSimulator,
+		PUType:       v.PUType,
+		PUMeta:       v.PUMeta,
+		Flows:        v.FlowsPerPU,
+		HostServices: v.HostServices,
+		Lifecycle: plan.Lifecycle{
+			PUIterations:   v.PULife.PUIter,
+			PUInterval:     time.Duration(v.PULife.PUInterval) * time.Second,
+			PUCleanup:      time.Duration(v.PULife.PUCleanup) * time.Second,
+			FlowIterations: v.PULife.FlowIter,
+			FlowInterval:   time.Duration(v.PULife.FlowInterval) * time.Second,
+			DNSReportRate:  v.PULife.DNSReportRate,
+			Scenarios:      v.PULife.Scenarios,
+		},
+		Jitter: plan.Jitter{
+			Variance:   fmt.Sprintf("%d%%", v.Jitter.Variance),
+			PUStart:    time.Duration(v.Jitter.PUStart) * time.Second,
+			PUReport:   time.Duration(v.Jitter.PUReport) * time.Second,
+			FlowReport: time.Duration(v.Jitter.FlowReport) * time.Millisecond,
+		},
+	}
+}
@@ -0,0 +1,439 @@
+package churn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/simulator-test-harness/common"
+	"go.aporeto.io/simulator-test-harness/libs/testsetup"
+)
+
+const (
+	// OpIssue issues tokens from the application credentials.
+	OpIssue Op = "issue"
+	// OpRotate replaces an application credential by a new one with the same roles, and revokes
+	// it.
+	OpRotate Op = "rotate"
+	// OpRevoke revokes an application credential.
+	OpRevoke Op = "revoke"
+)
+
+// tokenValidity is the validity of the tokens issued by an auth churn.
+const tokenValidity = 10 * time.Minute
+
+// DefaultAuthRoles are the roles given to the application credentials of an auth churn by
+// default.
+var DefaultAuthRoles = []string{
+	testsetup.AuthorizedIdentityNamespaceViewer,
+	testsetup.AuthorizedIdentityNamespaceContributor,
+	testsetup.AuthorizedIdentityAppsViewer,
+	testsetup.AuthorizedIdentityNetworkViewer,
+	testsetup.AuthorizedIdentityAutomationViewer,
+	testsetup.AuthorizedIdentityEnforcer,
+}
+
+// An AuthConfig is the parameters of an auth churn, which keeps a pool of application credentials
+// issuing tokens concurrently while some of them are rotated and revoked every interval.
+type AuthConfig struct {
+	// Interval is the interval between two rounds.
+	Interval time.Duration `yaml:"interval"`
+	// Duration is the duration of the churn.
+	Duration time.Duration `yaml:"duration"`
+	// AppCreds is the number of application credentials of the pool, refilled every round.
+	AppCreds int `yaml:"appCreds"`
+	// Roles are the roles of the application credentials, each given one at random. Defaults to
+	// DefaultAuthRoles.
+	Roles []string `yaml:"roles"`
+	// Tokens is the number of tokens issued every round, from credentials picked at random.
+	Tokens int `yaml:"tokens"`
+	// Concurrency is the number of concurrent token issuers.
+	Concurrency int `yaml:"concurrency"`
+	// Rotations is the number of application credentials rotated every round.
+	Rotations int `yaml:"rotations"`
+	// Revocations is the number of application credentials revoked every round.
+	Revocations int `yaml:"revocations"`
+	// RevocationTimeout is the maximum propagation time of a revocation: a revoked credential
+	// still issuing tokens after it is an error.
+	RevocationTimeout time.Duration `yaml:"revocationTimeout"`
+	// Policies creates an API authorization policy granting its roles to every credential.
+	Policies bool `yaml:"policies"`
+	// Seed is the seed of the churn. A random seed is used if 0.
+	Seed int64 `yaml:"seed,omitempty"`
+}
+
+// Validate checks that the parameters of c are valid.
+func (c *AuthConfig) Validate() error {
+
+	if c.Interval <= 0 || c.Duration <= 0 || c.RevocationTimeout <= 0 {
+		return fmt.Errorf("interval, duration and revocation timeout must be positive")
+	}
+	if c.AppCreds < 1 {
+		return fmt.Errorf("invalid number of appcreds %d", c.AppCreds)
+	}
+	if c.Tokens < 0 || c.Rotations < 0 || c.Revocations < 0 {
+		return fmt.Errorf("tokens, rotations and revocations must not be negative")
+	}
+	if c.Tokens > 0 && c.Concurrency < 1 {
+		return fmt.Errorf("cannot issue %d tokens with %d issuers", c.Tokens, c.Concurrency)
+	}
+	if c.Rotations+c.Revocations > c.AppCreds {
+		return fmt.Errorf("cannot rotate %d and revoke %d of %d appcreds every round",
+			c.Rotations, c.Revocations, c.AppCreds)
+	}
+
+	return nil
+}
+
+// An AuthClient manages application credentials and issues their tokens, e.g. a
+// *testsetup.Client.
+type AuthClient interface {
+	CreateAppCredential(name, ns string, roles []string) (*gaia.AppCredential, error)
+	DeleteAppCredential(appcred *gaia.AppCredential, ns string) error
+	IssueToken(appcred *gaia.AppCredential, validity time.Duration) (string, error)
+	CreateAPIAuthPolicy(name, ns string, tags, roles []string, subjectRealm gaia.IssueRealmValue,
+		subjectAuthTags []string) (*gaia.APIAuthorizationPolicy, error)
+	DeleteAPIAuthPolicy(aap *gaia.APIAuthorizationPolicy, ns string) error
+}
+
+// An AuthEvent is an operation of an auth churn, recorded to correlate the backend metrics with
+// the churn.
+type AuthEvent struct {
+	Time      time.Time `json:"time"`
+	Op        Op        `json:"op"`
+	Namespace string    `json:"namespace,omitempty"`
+	// Name is the name of the application credential, except for the issue events.
+	Name string `json:"name,omitempty"`
+	// Tokens and Failures are the numbers of tokens issued and of failed issuances of an issue
+	// event.
+	Tokens   int `json:"tokens,omitempty"`
+	Failures int `json:"failures,omitempty"`
+	// Latency is the duration of the operation: the creation of a credential, the issuance of
+	// the tokens of a round, or the propagation of a revocation.
+	Latency time.Duration `json:"latency"`
+	// Error is the error of the operation, if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// A credential is an application credential of an auth churn.
+type credential struct {
+	ns      string
+	appcred *gaia.AppCredential
+	// aap is the API authorization policy granting the roles of appcred, if any.
+	aap *gaia.APIAuthorizationPolicy
+}
+
+// An AuthController churns application credentials, and records the operations as JSON lines to
+// Events, if set.
+type AuthController struct {
+	Client AuthClient
+	Config AuthConfig
+	Events io.Writer
+
+	rand *rand.Rand
+	// poll is the interval between two checks of a revoked credential.
+	poll time.Duration
+	// creds are the credentials of the pool.
+	creds []*credential
+
+	// mu guards events and Events, written by the revocation checks.
+	mu     sync.Mutex
+	events []AuthEvent
+	// checks are the revocation checks in progress.
+	checks sync.WaitGroup
+}
+
+// NewAuthController returns an AuthController churning with client following c, which is
+// validated.
+func NewAuthController(client AuthClient, c AuthConfig, events io.Writer) (*AuthController,
+	error) {
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid auth churn configuration: %v", err)
+	}
+	if len(c.Roles) == 0 {
+		c.Roles = DefaultAuthRoles
+	}
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &AuthController{
+		Client: client,
+		Config: c,
+		Events: events,
+		rand:   rand.New(rand.NewSource(seed)),
+		poll:   time.Second,
+	}, nil
+}
+
+// Run creates, uses, rotates and revokes application credentials spread over namespaces every
+// interval for the duration of the churn, or until ctx is done, and returns the events once the
+// revocations are checked. The failed operations are recorded and logged, but do not stop the
+// churn. If cleanup is set, the credentials left by the churn are deleted at the end.
+func (c *AuthController) Run(
+	ctx context.Context,
+	namespaces []string,
+	cleanup bool,
+) ([]AuthEvent, error) {
+
+	if len(namespaces) == 0 {
+		return nil, fmt.Errorf("no namespaces")
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(c.Config.Interval)
+	defer ticker.Stop()
+
+	var err error
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		case now := <-ticker.C:
+			if now.Sub(start) > c.Config.Duration {
+				break loop
+			}
+			c.round(ctx, namespaces)
+		}
+	}
+	c.checks.Wait()
+
+	if cleanup {
+		for _, cred := range c.creds {
+			c.record(AuthEvent{Op: OpDelete, Namespace: cred.ns, Name: cred.appcred.Name},
+				c.delete(cred))
+		}
+		c.creds = nil
+	}
+
+	common.Log.Infof("Auth churn completed after %v with %d events", time.Since(start),
+		len(c.events))
+	return c.events, err
+}
+
+// round refills the pool of credentials, issues the tokens of the round, then rotates and revokes
+// credentials picked at random, checking the revocations in the background.
+func (c *AuthController) round(ctx context.Context, namespaces []string) {
+
+	for len(c.creds) < c.Config.AppCreds {
+		ns := namespaces[c.rand.Intn(len(namespaces))]
+		roles := []string{c.Config.Roles[c.rand.Intn(len(c.Config.Roles))]}
+		start := time.Now()
+		cred, err := c.create(ns, roles)
+		c.record(AuthEvent{Op: OpCreate, Namespace: ns, Name: cred.appcred.Name,
+			Latency: time.Since(start)}, err)
+		if err != nil {
+			break
+		}
+		c.creds = append(c.creds, cred)
+	}
+	if len(c.creds) == 0 {
+		return
+	}
+
+	c.issue()
+
+	picked := c.rand.Perm(len(c.creds))
+	if n := c.Config.Rotations + c.Config.Revocations; len(picked) > n {
+		picked = picked[:n]
+	}
+	revoked := make([]*credential, len(picked))
+	for i, p := range picked {
+		revoked[i] = c.creds[p]
+	}
+	c.forget(revoked)
+
+	for i, cred := range revoked {
+		op := OpRevoke
+		if i < c.Config.Rotations {
+			op = OpRotate
+			replacement, err := c.create(cred.ns, cred.appcred.Roles)
+			if err != nil {
+				c.record(AuthEvent{Op: op, Namespace: cred.ns, Name: cred.appcred.Name}, err)
+				c.creds = append(c.creds, cred)
+				continue
+			}
+			c.creds = append(c.creds, replacement)
+		}
+		if err := c.delete(cred); err != nil {
+			// The credential is kept in the pool, to be deleted again later.
+			c.record(AuthEvent{Op: op, Namespace: cred.ns, Name: cred.appcred.Name}, err)
+			c.creds = append(c.creds, cred)
+			continue
+		}
+		c.checks.Add(1)
+		go c.checkRevoked(ctx, op, cred)
+	}
+}
+
+// issue issues the tokens of a round from credentials picked at random, with Concurrency issuers.
+func (c *AuthController) issue() {
+
+	if c.Config.Tokens == 0 {
+		return
+	}
+
+	jobs := make(chan *credential, c.Config.Tokens)
+	for i := 0; i < c.Config.Tokens; i++ {
+		jobs <- c.creds[c.rand.Intn(len(c.creds))]
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failures int
+	var lastErr error
+	start := time.Now()
+	for i := 0; i < c.Config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cred := range jobs {
+				if _, err := c.Client.IssueToken(cred.appcred, tokenValidity); err != nil {
+					mu.Lock()
+					failures, lastErr = failures+1, err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	ev := AuthEvent{Op: OpIssue, Tokens: c.Config.Tokens - failures, Failures: failures,
+		Latency: time.Since(start)}
+	if lastErr != nil {
+		lastErr = fmt.Errorf("%d of %d issuances failed, last error: %v", failures,
+			c.Config.Tokens, lastErr)
+	}
+	c.record(ev, lastErr)
+}
+
+// checkRevoked records the event op of the revoked credential cred once it stops issuing tokens,
+// with the propagation time of its revocation, or an error after RevocationTimeout.
+func (c *AuthController) checkRevoked(ctx context.Context, op Op, cred *credential) {
+
+	defer c.checks.Done()
+
+	ev := AuthEvent{Op: op, Namespace: cred.ns, Name: cred.appcred.Name}
+	start := time.Now()
+	timeout := time.NewTimer(c.Config.RevocationTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(c.poll)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.Client.IssueToken(cred.appcred, tokenValidity); err != nil {
+			ev.Latency = time.Since(start)
+			c.record(ev, nil)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			ev.Latency = time.Since(start)
+			c.record(ev, fmt.Errorf("revocation check interrupted: %v", ctx.Err()))
+			return
+		case <-timeout.C:
+			ev.Latency = time.Since(start)
+			c.record(ev, fmt.Errorf("revoked appcred still issues tokens after %v",
+				c.Config.RevocationTimeout))
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// create creates a credential with roles in ns, and its API authorization policy if enabled. The
+// credential is named even if its creation failed, in which case its appcred is deleted.
+func (c *AuthController) create(ns string, roles []string) (*credential, error) {
+
+	name := "churn-" + common.RandomString(6)
+	cred := &credential{ns: ns, appcred: &gaia.AppCredential{Name: name, Roles: roles}}
+
+	appcred, err := c.Client.CreateAppCredential(name, ns, roles)
+	if err != nil {
+		return cred, err
+	}
+	cred.appcred = appcred
+
+	if c.Config.Policies {
+		subject := []string{fmt.Sprintf("commonname=app:credential:%s:%s", appcred.ID, name)}
+		aap, err := c.Client.CreateAPIAuthPolicy(name, ns,
+			[]string{"creator=simulator-test-harness", "churn=auth"}, roles,
+			gaia.IssueRealmCertificate, subject)
+		if err != nil {
+			if derr := c.Client.DeleteAppCredential(appcred, ns); derr != nil {
+				return cred, fmt.Errorf("%v, and deleting the appcred: %v", err, derr)
+			}
+			return cred, err
+		}
+		cred.aap = aap
+	}
+
+	return cred, nil
+}
+
+// delete deletes the API authorization policy of cred, if any, then cred. If the deletion of the
+// appcred fails, cred is left without policy, and can be deleted again.
+func (c *AuthController) delete(cred *credential) error {
+
+	if cred.aap != nil {
+		if err := c.Client.DeleteAPIAuthPolicy(cred.aap, cred.ns); err != nil {
+			return err
+		}
+		cred.aap = nil
+	}
+
+	return c.Client.DeleteAppCredential(cred.appcred, cred.ns)
+}
+
+// forget removes creds from the pool.
+func (c *AuthController) forget(creds []*credential) {
+
+	kept := c.creds[:0]
+	for _, cred := range c.creds {
+		forgotten := false
+		for _, f := range creds {
+			forgotten = forgotten || f == cred
+		}
+		if !forgotten {
+			kept = append(kept, cred)
+		}
+	}
+	c.creds = kept
+}
+
+// record completes ev with its time and err, logs it and appends it to the events.
+func (c *AuthController) record(ev AuthEvent, err error) {
+
+	ev.Time = time.Now()
+	if err != nil {
+		ev.Error = err.Error()
+		common.Log.Warnf("Auth churn %s %s in %s: %v", ev.Op, ev.Name, ev.Namespace, err)
+	} else {
+		common.Log.Infof("Auth churn %s %s in %s after %v", ev.Op, ev.Name, ev.Namespace,
+			ev.Latency)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.events = append(c.events, ev)
+	if c.Events != nil {
+		data, err := json.Marshal(ev)
+		if err == nil {
+			_, err = fmt.Fprintf(c.Events, "%s\n", data)
+		}
+		if err != nil {
+			common.Log.Warnf("Recording auth churn event: %v", err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Package churn keeps scaling the simulator deployments of a run up and down after its initial
+// rollout, following a pattern, to exercise the enforcer registration, unregistration and
+// reconnection at scale like an autoscaled fleet.
+package churn
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// A PatternType is a churn pattern.
+type PatternType string
+
+const (
+	// PatternSine scales the deployments along a sine wave between Min and Max replicas.
+	PatternSine PatternType = "sine"
+	// PatternRandomWalk scales each deployment by up to Step replicas, up or down, at random.
+	PatternRandomWalk PatternType = "random-walk"
+	// PatternSteps scales the deployments to the replicas of scheduled steps.
+	PatternSteps PatternType = "steps"
+)
+
+// PatternTypes are all the supported pattern types.
+var PatternTypes = []PatternType{
+	PatternSine,
+	PatternRandomWalk,
+	PatternSteps,
+}
+
+// A Step is a scheduled step of the steps pattern.
+type Step struct {
+	// After is the time since the start of the churn at which the step starts.
+	After time.Duration `yaml:"after"`
+	// Replicas is the number of replicas of every deployment during the step.
+	Replicas int `yaml:"replicas"`
+}
+
+// A Config is the parameters of a churn.
+type Config struct {
+	Pattern PatternType `yaml:"pattern"`
+	// Interval is the interval between two scalings.
+	Interval time.Duration `yaml:"interval"`
+	// Duration is the duration of the churn.
+	Duration time.Duration `yaml:"duration"`
+	// Min and Max are the bounds of the replicas of every deployment.
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+	// Period is the period of the sine wave.
+	Period time.Duration `yaml:"period,omitempty"`
+	// Step is the maximum number of replicas added or removed by a random walk step.
+	Step int `yaml:"step,omitempty"`
+	// Steps are the scheduled steps, sorted by start time.
+	Steps []Step `yaml:"steps,omitempty"`
+	// Seed is the seed of the random walk. A random seed is used if 0.
+	Seed int64 `yaml:"seed,omitempty"`
+}
+
+// Validate checks that the parameters of c are valid.
+func (c *Config) Validate() error {
+
+	if c.Interval <= 0 || c.Duration <= 0 {
+		return fmt.Errorf("interval and duration must be positive")
+	}
+	if c.Min < 0 || c.Max < c.Min {
+		return fmt.Errorf("invalid replicas bounds [%d, %d]", c.Min, c.Max)
+	}
+
+	switch c.Pattern {
+	case PatternSine:
+		if c.Period <= 0 {
+			return fmt.Errorf("%s: period must be positive", c.Pattern)
+		}
+	case PatternRandomWalk:
+		if c.Step < 1 {
+			return fmt.Errorf("%s: step must be positive", c.Pattern)
+		}
+	case PatternSteps:
+		if len(c.Steps) == 0 {
+			return fmt.Errorf("%s: no steps", c.Pattern)
+		}
+		for i, s := range c.Steps {
+			if s.Replicas < c.Min || s.Replicas > c.Max {
+				return fmt.Errorf("%s: step %d replicas %d out of [%d, %d]", c.Pattern, i,
+					s.Replicas, c.Min, c.Max)
+			}
+			if i > 0 && s.After < c.Steps[i-1].After {
+				return fmt.Errorf("%s: steps are not sorted", c.Pattern)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown pattern %q", c.Pattern)
+	}
+
+	return nil
+}
+
+// A Pattern returns the replicas of the deployments over time.
+type Pattern interface {
+	// Replicas returns the replicas of a deployment with current replicas, elapsed since the
+	// start of the churn.
+	Replicas(elapsed time.Duration, current int) int
+}
+
+// NewPattern returns the pattern of c, which must be valid.
+func (c *Config) NewPattern() Pattern {
+
+	switch c.Pattern {
+	case PatternSine:
+		return &sine{min: c.Min, max: c.Max, period: c.Period}
+	case PatternRandomWalk:
+		seed := c.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		return &randomWalk{
+			min:  c.Min,
+			max:  c.Max,
+			step: c.Step,
+			rand: rand.New(rand.NewSource(seed)),
+		}
+	default:
+		return &steps{steps: c.Steps}
+	}
+}
+
+// A sine is a sine wave between min and max, starting at its middle and going up.
+type sine struct {
+	min, max int
+	period   time.Duration
+}
+
+func (s *sine) Replicas(elapsed time.Duration, current int) int {
+
+	phase := 2 * math.Pi * float64(elapsed) / float64(s.period)
+	amplitude := float64(s.max-s.min) / 2
+
+	return int(math.Round(float64(s.min) + amplitude + amplitude*math.Sin(phase)))
+}
+
+// A randomWalk adds up to step replicas to the current replicas, or removes them, within
+// [min, max].
+type randomWalk struct {
+	min, max, step int
+	rand           *rand.Rand
+}
+
+func (w *randomWalk) Replicas(elapsed time.Duration, current int) int {
+
+	next := current + w.rand.Intn(2*w.step+1) - w.step
+	if next < w.min {
+		next = w.min
+	}
+	if next > w.max {
+		next = w.max
+	}
+
+	return next
+}
+
+// A steps is the replicas of scheduled steps. The replicas are unchanged before the first step.
+type steps struct {
+	steps []Step
+}
+
+func (s *steps) Replicas(elapsed time.Duration, current int) int {
+
+	replicas := current
+	for _, step := range s.steps {
+		if step.After > elapsed {
+			break
+		}
+		replicas = step.Replicas
+	}
+
+	return replicas
+}
@@ -0,0 +1,534 @@
+package churn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.aporeto.io/gaia"
+)
+
+func TestPatterns(t *testing.T) {
+
+	steps := []Step{{After: time.Minute, Replicas: 4}, {After: 3 * time.Minute, Replicas: 0}}
+	tests := []struct {
+		name    string
+		config  Config
+		elapsed time.Duration
+		current int
+		want    int
+	}{
+		{
+			name:   "sine start",
+			config: Config{Pattern: PatternSine, Min: 0, Max: 10, Period: 4 * time.Minute},
+			want:   5,
+		},
+		{
+			name:    "sine peak",
+			config:  Config{Pattern: PatternSine, Min: 0, Max: 10, Period: 4 * time.Minute},
+			elapsed: time.Minute,
+			want:    10,
+		},
+		{
+			name:    "sine trough",
+			config:  Config{Pattern: PatternSine, Min: 2, Max: 10, Period: 4 * time.Minute},
+			elapsed: 3 * time.Minute,
+			want:    2,
+		},
+		{
+			name:    "before steps",
+			config:  Config{Pattern: PatternSteps, Max: 4, Steps: steps},
+			elapsed: 30 * time.Second,
+			current: 3,
+			want:    3,
+		},
+		{
+			name:    "first step",
+			config:  Config{Pattern: PatternSteps, Max: 4, Steps: steps},
+			elapsed: 2 * time.Minute,
+			current: 3,
+			want:    4,
+		},
+		{
+			name:    "last step",
+			config:  Config{Pattern: PatternSteps, Max: 4, Steps: steps},
+			elapsed: time.Hour,
+			current: 4,
+			want:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Interval, tt.config.Duration = time.Second, time.Hour
+			if err := tt.config.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			got := tt.config.NewPattern().Replicas(tt.elapsed, tt.current)
+			if got != tt.want {
+				t.Errorf("Replicas() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRandomWalk(t *testing.T) {
+
+	c := Config{Pattern: PatternRandomWalk, Min: 1, Max: 5, Step: 2, Seed: 42}
+	walk := func() []int {
+		p := c.NewPattern()
+		replicas := []int{3}
+		for i := 0; i < 100; i++ {
+			replicas = append(replicas, p.Replicas(0, replicas[i]))
+		}
+		return replicas
+	}
+
+	first, second := walk(), walk()
+	for i, r := range first {
+		if r < c.Min || r > c.Max {
+			t.Fatalf("step %d: replicas %d out of bounds", i, r)
+		}
+		if i > 0 && (r-first[i-1] > c.Step || first[i-1]-r > c.Step) {
+			t.Fatalf("step %d: from %d to %d replicas", i, first[i-1], r)
+		}
+		if second[i] != r {
+			t.Fatalf("step %d: same seed, different walks", i)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	valid := Config{Pattern: PatternSine, Interval: time.Second, Duration: time.Minute, Max: 3,
+		Period: time.Minute}
+	tests := []struct {
+		name   string
+		config func(c *Config)
+	}{
+		{name: "unknown pattern", config: func(c *Config) { c.Pattern = "spike" }},
+		{name: "no interval", config: func(c *Config) { c.Interval = 0 }},
+		{name: "inverted bounds", config: func(c *Config) { c.Min = 4 }},
+		{name: "no period", config: func(c *Config) { c.Period = 0 }},
+		{name: "no step", config: func(c *Config) { c.Pattern = PatternRandomWalk }},
+		{name: "no steps", config: func(c *Config) { c.Pattern = PatternSteps }},
+		{
+			name: "unsorted steps",
+			config: func(c *Config) {
+				c.Pattern = PatternSteps
+				c.Steps = []Step{{After: time.Minute}, {After: time.Second}}
+			},
+		},
+	}
+
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid
+			tt.config(&c)
+			if err := c.Validate(); err == nil {
+				t.Errorf("Validate() succeeded")
+			}
+		})
+	}
+}
+
+// A fakeScaler records the replicas of the deployments, failing for the deployment broken.
+type fakeScaler struct {
+	replicas map[string]int
+	broken   string
+}
+
+func (s *fakeScaler) ScaleDeployment(
+	ctx context.Context,
+	namespace, name string,
+	replicas int,
+) error {
+
+	if name == s.broken {
+		return fmt.Errorf("deployment %s not found", name)
+	}
+	s.replicas[name] = replicas
+
+	return nil
+}
+
+func TestController(t *testing.T) {
+
+	c := Config{
+		Pattern:  PatternSteps,
+		Interval: time.Millisecond,
+		Duration: 50 * time.Millisecond,
+		Max:      10,
+		Steps:    []Step{{Replicas: 1}, {After: 25 * time.Millisecond, Replicas: 8}},
+	}
+	scaler := &fakeScaler{replicas: map[string]int{}, broken: "sim-pods-c"}
+	var log bytes.Buffer
+	ctrl, err := NewController(scaler, c, 10, &log)
+	if err != nil {
+		t.Fatalf("NewController() error = %v", err)
+	}
+
+	deps := []*Deployment{
+		{Namespace: "sim", Name: "sim-pods-a", Replicas: 4},
+		{Namespace: "sim", Name: "sim-pods-b", Replicas: 8},
+		{Namespace: "sim", Name: "sim-pods-c", Replicas: 4},
+	}
+	events, err := ctrl.Run(context.Background(), deps, true)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if scaler.replicas["sim-pods-a"] != 4 || scaler.replicas["sim-pods-b"] != 8 {
+		t.Errorf("replicas not restored: %v", scaler.replicas)
+	}
+
+	var recorded []Event
+	scanner := bufio.NewScanner(&log)
+	for scanner.Scan() {
+		var ev Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("unmarshal event %q: %v", scanner.Text(), err)
+		}
+		recorded = append(recorded, ev)
+	}
+	if len(recorded) != len(events) {
+		t.Fatalf("recorded %d events, returned %d", len(recorded), len(events))
+	}
+
+	enforcers, failed := 0, 0
+	for _, ev := range recorded {
+		enforcers += ev.Enforcers
+		if ev.Error != "" {
+			failed++
+		}
+	}
+	if enforcers != 0 {
+		t.Errorf("got %d enforcers after restoring, want 0", enforcers)
+	}
+	if failed == 0 || failed == len(recorded) {
+		t.Errorf("got %d failed events out of %d", failed, len(recorded))
+	}
+	// a: 4 -> 1 -> 8 -> 4, b: 8 -> 1 -> 8, c fails every scaling.
+	if succeeded := len(recorded) - failed; succeeded != 5 {
+		t.Errorf("got %d successful events, want 5", succeeded)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ctrl.Run(ctx, deps[:1], false); err == nil {
+		t.Errorf("Run() succeeded with a cancelled context")
+	}
+}
+
+// A fakePolicyClient records the objects of every namespace, failing the updates in broken.
+type fakePolicyClient struct {
+	objects map[string]map[string]bool
+	updates int
+	broken  string
+}
+
+func (f *fakePolicyClient) add(ns, name string) {
+
+	if f.objects[ns] == nil {
+		f.objects[ns] = map[string]bool{}
+	}
+	f.objects[ns][name] = true
+}
+
+func (f *fakePolicyClient) CreateNetworkPolicy(name, ns string, tags []string,
+	subject [][]string, propagate bool,
+	incomings, outgoings []*gaia.NetworkRule) (*gaia.NetworkRuleSetPolicy, error) {
+
+	f.add(ns, name)
+	return &gaia.NetworkRuleSetPolicy{Name: name, Subject: subject, Propagate: propagate}, nil
+}
+
+func (f *fakePolicyClient) UpdateNetworkPolicy(np *gaia.NetworkRuleSetPolicy, ns string) error {
+
+	if ns == f.broken {
+		return fmt.Errorf("namespace %s is broken", ns)
+	}
+	f.updates++
+	return nil
+}
+
+func (f *fakePolicyClient) DeleteNetworkPolicy(np *gaia.NetworkRuleSetPolicy, ns string) error {
+
+	delete(f.objects[ns], np.Name)
+	return nil
+}
+
+func (f *fakePolicyClient) CreateExternalNetwork(name, ns string, tags, networks []string,
+	propagate bool) (*gaia.ExternalNetwork, error) {
+
+	f.add(ns, name)
+	return &gaia.ExternalNetwork{Name: name, Entries: networks}, nil
+}
+
+func (f *fakePolicyClient) UpdateExternalNetwork(en *gaia.ExternalNetwork, ns string) error {
+
+	return f.UpdateNetworkPolicy(nil, ns)
+}
+
+func (f *fakePolicyClient) DeleteExternalNetwork(en *gaia.ExternalNetwork, ns string) error {
+
+	delete(f.objects[ns], en.Name)
+	return nil
+}
+
+func TestPolicyController(t *testing.T) {
+
+	c := PolicyConfig{
+		Interval:    time.Millisecond,
+		Duration:    30 * time.Millisecond,
+		BlastRadius: 2,
+		Mutations:   3,
+		MaxObjects:  4,
+		Kinds:       ObjectKinds,
+		Seed:        42,
+	}
+	client := &fakePolicyClient{objects: map[string]map[string]bool{}, broken: "/base/ns-3"}
+	var log bytes.Buffer
+	ctrl, err := NewPolicyController(client, c, &log)
+	if err != nil {
+		t.Fatalf("NewPolicyController() error = %v", err)
+	}
+
+	namespaces := []string{"/base/ns-1", "/base/ns-2", "/base/ns-3", "/base/ns-4"}
+	events, err := ctrl.Run(context.Background(), namespaces, true)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	var recorded []PolicyEvent
+	scanner := bufio.NewScanner(&log)
+	for scanner.Scan() {
+		var ev PolicyEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			t.Fatalf("unmarshal event %q: %v", scanner.Text(), err)
+		}
+		recorded = append(recorded, ev)
+	}
+	if len(recorded) != len(events) || len(events) == 0 {
+		t.Fatalf("recorded %d events, returned %d", len(recorded), len(events))
+	}
+
+	ops := map[Op]int{}
+	live := map[string]int{}
+	for _, ev := range events {
+		if ev.Time.IsZero() || ev.Name == "" {
+			t.Errorf("incomplete event %+v", ev)
+		}
+		if ev.Op == OpUpdate && (ev.Namespace == client.broken) != (ev.Error != "") {
+			t.Errorf("unexpected update error in %s: %q", ev.Namespace, ev.Error)
+		}
+		ops[ev.Op]++
+		switch ev.Op {
+		case OpCreate:
+			live[ev.Namespace]++
+		case OpDelete:
+			live[ev.Namespace]--
+		}
+		if live[ev.Namespace] > c.MaxObjects {
+			t.Fatalf("%d objects in %s, want at most %d", live[ev.Namespace], ev.Namespace,
+				c.MaxObjects)
+		}
+	}
+	if ops[OpCreate] == 0 || ops[OpUpdate] == 0 || ops[OpDelete] == 0 {
+		t.Errorf("got mutations %v, want all of them", ops)
+	}
+	if client.updates == 0 {
+		t.Errorf("no update applied")
+	}
+	for ns, objects := range client.objects {
+		if len(objects) > 0 {
+			t.Errorf("%d objects left in %s after cleanup", len(objects), ns)
+		}
+	}
+}
+
+// A fakeAuthClient records the live application credentials and policies. A revoked credential
+// keeps issuing lag tokens, or forever if stuck. The first policyFailures policy creations and
+// deleteFailures appcred deletions fail.
+type fakeAuthClient struct {
+	mu             sync.Mutex
+	appcreds       map[string]bool
+	policies       map[string]bool
+	revoked        map[string]int
+	issued         int
+	lag            int
+	stuck          bool
+	policyFailures int
+	deleteFailures int
+}
+
+func (f *fakeAuthClient) CreateAppCredential(name, ns string,
+	roles []string) (*gaia.AppCredential, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.appcreds[name] = true
+	return &gaia.AppCredential{ID: "id-" + name, Name: name, Namespace: ns, Roles: roles}, nil
+}
+
+func (f *fakeAuthClient) DeleteAppCredential(appcred *gaia.AppCredential, ns string) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deleteFailures > 0 {
+		f.deleteFailures--
+		return fmt.Errorf("cannot delete %s", appcred.Name)
+	}
+	delete(f.appcreds, appcred.Name)
+	f.revoked[appcred.Name] = f.lag
+	return nil
+}
+
+func (f *fakeAuthClient) IssueToken(appcred *gaia.AppCredential,
+	validity time.Duration) (string, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.appcreds[appcred.Name] {
+		if f.revoked[appcred.Name] == 0 && !f.stuck {
+			return "", fmt.Errorf("appcred %s revoked", appcred.Name)
+		}
+		f.revoked[appcred.Name]--
+	}
+	f.issued++
+	return "token-" + appcred.Name, nil
+}
+
+func (f *fakeAuthClient) CreateAPIAuthPolicy(name, ns string, tags, roles []string,
+	subjectRealm gaia.IssueRealmValue,
+	subjectAuthTags []string) (*gaia.APIAuthorizationPolicy, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.policyFailures > 0 {
+		f.policyFailures--
+		return nil, fmt.Errorf("cannot create policy %s", name)
+	}
+	f.policies[name] = true
+	return &gaia.APIAuthorizationPolicy{Name: name}, nil
+}
+
+func (f *fakeAuthClient) DeleteAPIAuthPolicy(aap *gaia.APIAuthorizationPolicy, ns string) error {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.policies, aap.Name)
+	return nil
+}
+
+func TestAuthController(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		stuck          bool
+		policyFailures int
+		deleteFailures int
+	}{
+		{
+			name: "revocations propagated",
+		},
+		{
+			name:  "revoked appcreds still working",
+			stuck: true,
+		},
+		{
+			name:           "policy creations failing",
+			policyFailures: 2,
+		},
+		{
+			name:           "appcred deletion failing",
+			deleteFailures: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := AuthConfig{
+				Interval:          5 * time.Millisecond,
+				Duration:          30 * time.Millisecond,
+				AppCreds:          4,
+				Tokens:            8,
+				Concurrency:       3,
+				Rotations:         1,
+				Revocations:       1,
+				RevocationTimeout: 20 * time.Millisecond,
+				Policies:          true,
+				Seed:              42,
+			}
+			client := &fakeAuthClient{
+				appcreds:       map[string]bool{},
+				policies:       map[string]bool{},
+				revoked:        map[string]int{},
+				lag:            2,
+				stuck:          tt.stuck,
+				policyFailures: tt.policyFailures,
+				deleteFailures: tt.deleteFailures,
+			}
+			var log bytes.Buffer
+			ctrl, err := NewAuthController(client, c, &log)
+			if err != nil {
+				t.Fatalf("NewAuthController() error = %v", err)
+			}
+			ctrl.poll = time.Millisecond
+
+			events, err := ctrl.Run(context.Background(), []string{"/base/ns-1", "/base/ns-2"},
+				true)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if n := bytes.Count(log.Bytes(), []byte("\n")); n != len(events) || n == 0 {
+				t.Fatalf("recorded %d events, returned %d", n, len(events))
+			}
+
+			// Only the credentials created with their policy issue tokens, and the revocations
+			// fail for the stuck credentials and the failed deletions.
+			ops := map[Op]int{}
+			errs := map[Op]int{}
+			for _, ev := range events {
+				ops[ev.Op]++
+				if ev.Error != "" {
+					errs[ev.Op]++
+				}
+				if ev.Op == OpIssue && (ev.Tokens != c.Tokens || ev.Failures != 0) {
+					t.Errorf("unexpected issue event %+v", ev)
+				}
+			}
+			revocations := errs[OpRotate] + errs[OpRevoke]
+			wantRevocations := tt.deleteFailures
+			if tt.stuck {
+				wantRevocations = ops[OpRotate] + ops[OpRevoke]
+			}
+			if revocations != wantRevocations {
+				t.Errorf("got %d failed revocations, want %d", revocations, wantRevocations)
+			}
+			if errs[OpCreate] != tt.policyFailures || errs[OpIssue]+errs[OpDelete] > 0 {
+				t.Errorf("got errors %v, want %d failed creations", errs, tt.policyFailures)
+			}
+			if ops[OpIssue] == 0 || ops[OpRotate] != ops[OpIssue] ||
+				ops[OpRevoke] != ops[OpIssue] {
+				t.Errorf("got operations %v, want a rotation and a revocation per round", ops)
+			}
+			if client.issued < ops[OpIssue]*c.Tokens {
+				t.Errorf("issued %d tokens, want at least %d", client.issued,
+					ops[OpIssue]*c.Tokens)
+			}
+			if len(client.appcreds) > 0 || len(client.policies) > 0 {
+				t.Errorf("%d appcreds and %d policies left after cleanup",
+					len(client.appcreds), len(client.policies))
+			}
+		})
+	}
+}
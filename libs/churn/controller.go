@@ -0,0 +1,154 @@
+package churn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"go.aporeto.io/simulator-test-harness/common"
+)
+
+// A Scaler scales deployments, e.g. a *k8s.Client.
+type Scaler interface {
+	// ScaleDeployment sets the replicas of the deployment name.
+	ScaleDeployment(ctx context.Context, namespace, name string, replicas int) error
+}
+
+// A Deployment is a simulator deployment churned.
+type Deployment struct {
+	Namespace string
+	Name      string
+	// Replicas is the current number of replicas of the deployment.
+	Replicas int
+}
+
+// An Event is a scaling event, recorded to correlate the backend metrics with the churn.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Namespace  string    `json:"namespace"`
+	Deployment string    `json:"deployment"`
+	From       int       `json:"from"`
+	To         int       `json:"to"`
+	// Enforcers is the number of enforcers registered (if positive) or unregistered (if negative)
+	// by the event.
+	Enforcers int `json:"enforcers"`
+	// Error is the error of the scaling, if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// A Controller churns deployments of simulators following a pattern, and records the scaling
+// events as JSON lines to Events, if set.
+type Controller struct {
+	Scaler  Scaler
+	Config  Config
+	Pattern Pattern
+	// Simulators is the number of simulators (i.e. enforcers) per replica.
+	Simulators int
+	Events     io.Writer
+}
+
+// NewController returns a Controller scaling with s following c, which is validated.
+func NewController(s Scaler, c Config, simulators int, events io.Writer) (*Controller, error) {
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid churn configuration: %v", err)
+	}
+
+	return &Controller{
+		Scaler:     s,
+		Config:     c,
+		Pattern:    c.NewPattern(),
+		Simulators: simulators,
+		Events:     events,
+	}, nil
+}
+
+// Run churns deps every interval for the duration of the churn, or until ctx is done, and
+// returns the events. The failed scalings are recorded and logged, but do not stop the churn. If
+// restore is set, the deployments are scaled back to their initial replicas at the end.
+func (c *Controller) Run(ctx context.Context, deps []*Deployment, restore bool) ([]Event, error) {
+
+	initial := make([]int, len(deps))
+	for i, d := range deps {
+		initial[i] = d.Replicas
+	}
+
+	var events []Event
+	start := time.Now()
+	ticker := time.NewTicker(c.Config.Interval)
+	defer ticker.Stop()
+
+	var err error
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		case now := <-ticker.C:
+			elapsed := now.Sub(start)
+			if elapsed > c.Config.Duration {
+				break loop
+			}
+
+			for _, d := range deps {
+				if ev, ok := c.scale(ctx, d, c.Pattern.Replicas(elapsed, d.Replicas)); ok {
+					events = append(events, ev)
+				}
+			}
+		}
+	}
+
+	if restore {
+		// Restore even when interrupted, so that the run is left as deployed.
+		rctx := context.WithoutCancel(ctx)
+		for i, d := range deps {
+			if ev, ok := c.scale(rctx, d, initial[i]); ok {
+				events = append(events, ev)
+			}
+		}
+	}
+
+	common.Log.Infof("Churn completed after %v with %d scaling events", time.Since(start),
+		len(events))
+	return events, err
+}
+
+// scale scales d to replicas unless unchanged, and returns the event recorded.
+func (c *Controller) scale(ctx context.Context, d *Deployment, replicas int) (Event, bool) {
+
+	if replicas == d.Replicas {
+		return Event{}, false
+	}
+
+	ev := Event{
+		Time:       time.Now(),
+		Namespace:  d.Namespace,
+		Deployment: d.Name,
+		From:       d.Replicas,
+		To:         replicas,
+		Enforcers:  (replicas - d.Replicas) * c.Simulators,
+	}
+	if err := c.Scaler.ScaleDeployment(ctx, d.Namespace, d.Name, replicas); err != nil {
+		ev.Error = err.Error()
+		ev.Enforcers = 0
+		common.Log.Warnf("Scaling %s from %d to %d: %v", d.Name, ev.From, ev.To, err)
+	} else {
+		d.Replicas = replicas
+		common.Log.Infof("Scaled %s from %d to %d replicas", d.Name, ev.From, ev.To)
+	}
+
+	if c.Events != nil {
+		data, err := json.Marshal(ev)
+		if err == nil {
+			_, err = fmt.Fprintf(c.Events, "%s\n", data)
+		}
+		if err != nil {
+			common.Log.Warnf("Recording scaling event: %v", err)
+		}
+	}
+
+	return ev, true
+}
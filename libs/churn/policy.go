@@ -0,0 +1,358 @@
+package churn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/simulator-test-harness/common"
+	"go.aporeto.io/simulator-test-harness/libs/testsetup"
+)
+
+// An ObjectKind is a kind of policy object mutated by a policy churn.
+type ObjectKind string
+
+const (
+	// KindNetworkPolicy is the kind of the network rule set policies.
+	KindNetworkPolicy ObjectKind = "networkpolicy"
+	// KindExternalNetwork is the kind of the external networks.
+	KindExternalNetwork ObjectKind = "externalnetwork"
+)
+
+// ObjectKinds are all the supported object kinds.
+var ObjectKinds = []ObjectKind{
+	KindNetworkPolicy,
+	KindExternalNetwork,
+}
+
+// An Op is a mutation of a policy object.
+type Op string
+
+const (
+	// OpCreate creates an object.
+	OpCreate Op = "create"
+	// OpUpdate updates an object created by the churn.
+	OpUpdate Op = "update"
+	// OpDelete deletes an object created by the churn.
+	OpDelete Op = "delete"
+)
+
+// A PolicyConfig is the parameters of a policy churn. Its blast radius is the number of namespaces
+// mutated every interval, whether the objects match the PUs of the namespaces (so that their
+// enforcers pull their policies again) and whether they are propagated to the children
+// namespaces.
+type PolicyConfig struct {
+	// Interval is the interval between two rounds of mutations.
+	Interval time.Duration `yaml:"interval"`
+	// Duration is the duration of the churn.
+	Duration time.Duration `yaml:"duration"`
+	// BlastRadius is the number of namespaces, picked at random, mutated every round.
+	BlastRadius int `yaml:"blastRadius"`
+	// Mutations is the number of mutations per namespace every round.
+	Mutations int `yaml:"mutations"`
+	// MaxObjects is the maximum number of objects of the churn per namespace.
+	MaxObjects int `yaml:"maxObjects"`
+	// Kinds are the kinds of the objects mutated.
+	Kinds []ObjectKind `yaml:"kinds"`
+	// Match makes the network policies select all the PUs of their namespace, instead of none.
+	Match bool `yaml:"match"`
+	// Propagate propagates the objects to the children namespaces.
+	Propagate bool `yaml:"propagate"`
+	// Seed is the seed of the mutations. A random seed is used if 0.
+	Seed int64 `yaml:"seed,omitempty"`
+}
+
+// Validate checks that the parameters of c are valid.
+func (c *PolicyConfig) Validate() error {
+
+	if c.Interval <= 0 || c.Duration <= 0 {
+		return fmt.Errorf("interval and duration must be positive")
+	}
+	if c.BlastRadius < 1 || c.Mutations < 1 || c.MaxObjects < 1 {
+		return fmt.Errorf("blast radius, mutations and max objects must be positive")
+	}
+	if len(c.Kinds) == 0 {
+		return fmt.Errorf("no object kinds")
+	}
+	for _, k := range c.Kinds {
+		if k != KindNetworkPolicy && k != KindExternalNetwork {
+			return fmt.Errorf("unknown object kind %q, expected one of %v", k, ObjectKinds)
+		}
+	}
+
+	return nil
+}
+
+// A PolicyClient creates, updates and deletes policy objects, e.g. a *testsetup.Client.
+type PolicyClient interface {
+	CreateNetworkPolicy(name, ns string, tags []string, subject [][]string, propagate bool,
+		incomings, outgoings []*gaia.NetworkRule) (*gaia.NetworkRuleSetPolicy, error)
+	UpdateNetworkPolicy(np *gaia.NetworkRuleSetPolicy, ns string) error
+	DeleteNetworkPolicy(np *gaia.NetworkRuleSetPolicy, ns string) error
+	CreateExternalNetwork(name, ns string, tags, networks []string,
+		propagate bool) (*gaia.ExternalNetwork, error)
+	UpdateExternalNetwork(en *gaia.ExternalNetwork, ns string) error
+	DeleteExternalNetwork(en *gaia.ExternalNetwork, ns string) error
+}
+
+// A PolicyEvent is a mutation of a policy object, recorded to correlate the backend metrics with
+// the churn.
+type PolicyEvent struct {
+	Time      time.Time  `json:"time"`
+	Op        Op         `json:"op"`
+	Kind      ObjectKind `json:"kind"`
+	Namespace string     `json:"namespace"`
+	Name      string     `json:"name"`
+	// Error is the error of the mutation, if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// An object is a policy object created by a policy churn.
+type object struct {
+	kind ObjectKind
+	np   *gaia.NetworkRuleSetPolicy
+	en   *gaia.ExternalNetwork
+}
+
+// name returns the name of o.
+func (o *object) name() string {
+
+	if o.np != nil {
+		return o.np.Name
+	}
+
+	return o.en.Name
+}
+
+// A PolicyController churns the policy objects of namespaces, and records the mutations as JSON
+// lines to Events, if set.
+type PolicyController struct {
+	Client PolicyClient
+	Config PolicyConfig
+	Events io.Writer
+
+	rand *rand.Rand
+	// objects are the objects created by the churn, per namespace.
+	objects map[string][]*object
+}
+
+// NewPolicyController returns a PolicyController mutating with client following c, which is
+// validated.
+func NewPolicyController(
+	client PolicyClient,
+	c PolicyConfig,
+	events io.Writer,
+) (*PolicyController, error) {
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy churn configuration: %v", err)
+	}
+	seed := c.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &PolicyController{
+		Client:  client,
+		Config:  c,
+		Events:  events,
+		rand:    rand.New(rand.NewSource(seed)),
+		objects: map[string][]*object{},
+	}, nil
+}
+
+// Run mutates the objects of BlastRadius namespaces picked among namespaces every interval for
+// the duration of the churn, or until ctx is done, and returns the events. The failed mutations
+// are recorded and logged, but do not stop the churn. If cleanup is set, the objects left by the
+// churn are deleted at the end.
+func (c *PolicyController) Run(
+	ctx context.Context,
+	namespaces []string,
+	cleanup bool,
+) ([]PolicyEvent, error) {
+
+	if len(namespaces) == 0 {
+		return nil, fmt.Errorf("no namespaces")
+	}
+
+	var events []PolicyEvent
+	start := time.Now()
+	ticker := time.NewTicker(c.Config.Interval)
+	defer ticker.Stop()
+
+	var err error
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		case now := <-ticker.C:
+			if now.Sub(start) > c.Config.Duration {
+				break loop
+			}
+			events = append(events, c.round(namespaces)...)
+		}
+	}
+
+	if cleanup {
+		for _, ns := range namespaces {
+			for len(c.objects[ns]) > 0 {
+				events = append(events, c.mutate(ns, OpDelete))
+			}
+		}
+	}
+
+	common.Log.Infof("Policy churn completed after %v with %d mutations", time.Since(start),
+		len(events))
+	return events, err
+}
+
+// round mutates BlastRadius namespaces picked among namespaces.
+func (c *PolicyController) round(namespaces []string) []PolicyEvent {
+
+	var events []PolicyEvent
+	picked := c.rand.Perm(len(namespaces))
+	if len(picked) > c.Config.BlastRadius {
+		picked = picked[:c.Config.BlastRadius]
+	}
+
+	for _, i := range picked {
+		ns := namespaces[i]
+		for m := 0; m < c.Config.Mutations; m++ {
+			events = append(events, c.mutate(ns, c.pickOp(ns)))
+		}
+	}
+
+	return events
+}
+
+// pickOp returns a random mutation of the objects of ns: a creation if there is none, and no
+// creation if there are MaxObjects of them.
+func (c *PolicyController) pickOp(ns string) Op {
+
+	switch n := len(c.objects[ns]); {
+	case n == 0:
+		return OpCreate
+	case n >= c.Config.MaxObjects:
+		return []Op{OpUpdate, OpDelete}[c.rand.Intn(2)]
+	default:
+		return []Op{OpCreate, OpUpdate, OpDelete}[c.rand.Intn(3)]
+	}
+}
+
+// mutate applies op to an object of ns, picked at random unless created, and returns the event
+// recorded. A deleted object is forgotten even if its deletion failed.
+func (c *PolicyController) mutate(ns string, op Op) PolicyEvent {
+
+	var o *object
+	var err error
+	switch op {
+	case OpCreate:
+		o, err = c.create(ns)
+		if err == nil {
+			c.objects[ns] = append(c.objects[ns], o)
+		}
+	case OpUpdate:
+		o = c.objects[ns][c.rand.Intn(len(c.objects[ns]))]
+		err = c.update(ns, o)
+	case OpDelete:
+		i := c.rand.Intn(len(c.objects[ns]))
+		o = c.objects[ns][i]
+		c.objects[ns] = append(c.objects[ns][:i], c.objects[ns][i+1:]...)
+		if o.kind == KindNetworkPolicy {
+			err = c.Client.DeleteNetworkPolicy(o.np, ns)
+		} else {
+			err = c.Client.DeleteExternalNetwork(o.en, ns)
+		}
+	}
+
+	ev := PolicyEvent{Time: time.Now(), Op: op, Namespace: ns}
+	if o != nil {
+		ev.Kind, ev.Name = o.kind, o.name()
+	}
+	if err != nil {
+		ev.Error = err.Error()
+		common.Log.Warnf("Policy churn %s in %s: %v", op, ns, err)
+	} else {
+		common.Log.Infof("Policy churn %s %s %s in %s", op, ev.Kind, ev.Name, ns)
+	}
+
+	if c.Events != nil {
+		data, err := json.Marshal(ev)
+		if err == nil {
+			_, err = fmt.Fprintf(c.Events, "%s\n", data)
+		}
+		if err != nil {
+			common.Log.Warnf("Recording policy churn event: %v", err)
+		}
+	}
+
+	return ev
+}
+
+// create creates an object of a random kind in ns.
+func (c *PolicyController) create(ns string) (*object, error) {
+
+	name := "churn-" + common.RandomString(6)
+	tags := []string{"creator=simulator-test-harness", "churn=policy"}
+	kind := c.Config.Kinds[c.rand.Intn(len(c.Config.Kinds))]
+
+	if kind == KindExternalNetwork {
+		en, err := c.Client.CreateExternalNetwork(name, ns,
+			append(tags, "externalnetwork:name="+name), []string{c.network()}, c.Config.Propagate)
+		if err != nil {
+			return &object{kind: kind, en: &gaia.ExternalNetwork{Name: name}}, err
+		}
+		return &object{kind: kind, en: en}, nil
+	}
+
+	subject := [][]string{{"matchedby=" + name}}
+	if c.Config.Match {
+		subject = [][]string{{"$identity=processingunit"}}
+	}
+	rules := []*gaia.NetworkRule{c.rule(name)}
+	np, err := c.Client.CreateNetworkPolicy(name, ns, tags, subject, c.Config.Propagate, rules,
+		rules)
+	if err != nil {
+		return &object{kind: kind, np: &gaia.NetworkRuleSetPolicy{Name: name}}, err
+	}
+
+	return &object{kind: kind, np: np}, nil
+}
+
+// update changes the rules of the network policy o, or the entries of the external network o.
+func (c *PolicyController) update(ns string, o *object) error {
+
+	if o.kind == KindExternalNetwork {
+		o.en.Entries = []string{c.network()}
+		return c.Client.UpdateExternalNetwork(o.en, ns)
+	}
+
+	rules := []*gaia.NetworkRule{c.rule(o.np.Name)}
+	o.np.IncomingRules, o.np.OutgoingRules = rules, rules
+	return c.Client.UpdateNetworkPolicy(o.np, ns)
+}
+
+// rule returns a rule named name with a random action and port towards all the PUs.
+func (c *PolicyController) rule(name string) *gaia.NetworkRule {
+
+	actions := []gaia.NetworkRuleActionValue{
+		gaia.NetworkRuleActionAllow,
+		gaia.NetworkRuleActionReject,
+	}
+
+	return testsetup.CreateNewNetworkRule(name, actions[c.rand.Intn(len(actions))],
+		[][]string{{"$identity=processingunit"}},
+		[]string{fmt.Sprintf("tcp/%d", 1025+c.rand.Intn(64_000))})
+}
+
+// network returns a random /24 network.
+func (c *PolicyController) network() string {
+
+	return fmt.Sprintf("10.%d.%d.0/24", c.rand.Intn(256), c.rand.Intn(256))
+}
@@ -0,0 +1,246 @@
+package faultproxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduleActive(t *testing.T) {
+
+	latency := &Fault{Type: FaultLatency, Start: time.Minute, Duration: time.Minute,
+		Latency: time.Second}
+	partition := &Fault{Type: FaultPartition, Start: 90 * time.Second}
+	s := &Schedule{Faults: []*Fault{latency, partition}, Repeat: 5 * time.Minute}
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    []*Fault
+	}{
+		{name: "before faults", elapsed: 30 * time.Second},
+		{name: "latency", elapsed: time.Minute, want: []*Fault{latency}},
+		{name: "both", elapsed: 100 * time.Second, want: []*Fault{latency, partition}},
+		{name: "partition", elapsed: 4 * time.Minute, want: []*Fault{partition}},
+		{name: "repeated", elapsed: 6 * time.Minute, want: []*Fault{latency}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.Active(tt.elapsed)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Active() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Active() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		fault Fault
+	}{
+		{name: "unknown type", fault: Fault{Type: "slow"}},
+		{name: "no latency", fault: Fault{Type: FaultLatency}},
+		{name: "invalid status", fault: Fault{Type: FaultError, Status: 200}},
+		{name: "negative start", fault: Fault{Type: FaultDrop, Start: -time.Second}},
+		{name: "invalid ratio", fault: Fault{Type: FaultDrop, Ratio: 2}},
+		{name: "after repeat", fault: Fault{Type: FaultDrop, Start: time.Hour}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Schedule{Faults: []*Fault{&tt.fault}, Repeat: time.Minute}
+			if err := s.Validate(); err == nil {
+				t.Errorf("Validate() succeeded")
+			}
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "upstream "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name       string
+		fault      Fault
+		path       string
+		wantStatus int
+		wantDrop   bool
+		minLatency time.Duration
+	}{
+		{
+			name:       "no fault",
+			fault:      Fault{Type: FaultDrop, Start: time.Hour},
+			path:       "/",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "latency",
+			fault:      Fault{Type: FaultLatency, Latency: 50 * time.Millisecond},
+			path:       "/",
+			wantStatus: http.StatusOK,
+			minLatency: 50 * time.Millisecond,
+		},
+		{
+			name:       "error",
+			fault:      Fault{Type: FaultError},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "error status",
+			fault:      Fault{Type: FaultError, Status: http.StatusTooManyRequests},
+			wantStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:       "error path",
+			fault:      Fault{Type: FaultError, Paths: []string{"/policies"}},
+			path:       "/policies/abc",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "other path",
+			fault:      Fault{Type: FaultError, Paths: []string{"/policies"}},
+			path:       "/enforcers",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:     "drop",
+			fault:    Fault{Type: FaultDrop},
+			wantDrop: true,
+		},
+		{
+			name:     "partition",
+			fault:    Fault{Type: FaultPartition, Paths: []string{"/policies"}},
+			path:     "/enforcers",
+			wantDrop: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewProxy(upstream.URL, &Schedule{Faults: []*Fault{&tt.fault}})
+			if err != nil {
+				t.Fatalf("NewProxy() error = %v", err)
+			}
+			server := httptest.NewServer(p)
+			defer server.Close()
+
+			start := time.Now()
+			resp, err := http.Get(server.URL + tt.path)
+			if tt.wantDrop {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("request not dropped: %s", resp.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("request error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if latency := time.Since(start); latency < tt.minLatency {
+				t.Errorf("got latency %v, want at least %v", latency, tt.minLatency)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if tt.wantStatus == http.StatusOK && string(body) != "upstream "+tt.path {
+				t.Errorf("got body %q", body)
+			}
+		})
+	}
+}
+
+func TestProxyRatio(t *testing.T) {
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	s := &Schedule{Faults: []*Fault{{Type: FaultError, Ratio: 0.5}}}
+	p, err := NewProxy(upstream.URL, s, OptionSeed(42))
+	if err != nil {
+		t.Fatalf("NewProxy() error = %v", err)
+	}
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	failed := 0
+	for i := 0; i < 100; i++ {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("request error = %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			failed++
+		}
+	}
+	if failed < 30 || failed > 70 {
+		t.Errorf("got %d failed requests out of 100 with a 0.5 ratio", failed)
+	}
+}
+
+func TestProxyPartition(t *testing.T) {
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	var offset atomic.Int64
+	now := func() time.Time { return time.Now().Add(time.Duration(offset.Load())) }
+	s := &Schedule{Faults: []*Fault{{Type: FaultPartition, Start: time.Hour}}}
+	p, err := NewProxy(upstream.URL, s, OptionClock(now))
+	if err != nil {
+		t.Fatalf("NewProxy() error = %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- p.Serve(ctx, l, nil) }()
+
+	resp, err := http.Get("http://" + l.Addr().String())
+	if err != nil {
+		t.Fatalf("request before the partition error = %v", err)
+	}
+	resp.Body.Close()
+
+	// An idle connection, e.g. an enforcer waiting for push notifications.
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	time.Sleep(2 * watchInterval)
+
+	offset.Store(int64(time.Hour))
+	c.SetReadDeadline(time.Now().Add(10 * watchInterval))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read on partitioned connection error = %v, want EOF", err)
+	}
+
+	cancel()
+	if err := <-served; err != context.Canceled {
+		t.Errorf("Serve() error = %v", err)
+	}
+}
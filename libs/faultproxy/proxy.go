@@ -0,0 +1,273 @@
+package faultproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.aporeto.io/simulator-test-harness/common"
+)
+
+// watchInterval is the interval at which the proxy checks the schedule for fault transitions.
+const watchInterval = 100 * time.Millisecond
+
+// A Proxy is a reverse proxy to the API injecting the faults of a schedule. The schedule starts
+// with the proxy.
+type Proxy struct {
+	schedule *Schedule
+	proxy    *httputil.ReverseProxy
+	start    time.Time
+	now      func() time.Time
+
+	randLock sync.Mutex
+	rand     *rand.Rand
+
+	connsLock sync.Mutex
+	conns     map[net.Conn]struct{}
+}
+
+// An Option is an option of NewProxy.
+type Option func(*Proxy)
+
+// OptionTransport sets the transport of the requests to the upstream API, e.g. to trust its
+// certificate authority.
+func OptionTransport(t http.RoundTripper) Option {
+
+	return func(p *Proxy) {
+		p.proxy.Transport = t
+	}
+}
+
+// OptionSeed sets the seed of the selection of the requests affected by the faults.
+func OptionSeed(seed int64) Option {
+
+	return func(p *Proxy) {
+		p.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// OptionClock sets the clock of the schedule, instead of the wall clock.
+func OptionClock(now func() time.Time) Option {
+
+	return func(p *Proxy) {
+		p.now = now
+	}
+}
+
+// NewProxy returns a Proxy forwarding the requests to upstream, injecting the faults of s, which
+// is validated.
+func NewProxy(upstream string, s *Schedule, opts ...Option) (*Proxy, error) {
+
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("parse upstream %s: %v", upstream, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream %q, expected an absolute URL", upstream)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid schedule: %v", err)
+	}
+
+	p := &Proxy{
+		schedule: s,
+		proxy: &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.SetURL(u)
+				r.SetXForwarded()
+			},
+		},
+		now:   time.Now,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		conns: map[net.Conn]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.start = p.now()
+
+	return p, nil
+}
+
+// ServeHTTP injects the faults active for r, then forwards it to the upstream API unless failed.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	for _, f := range p.schedule.Active(p.now().Sub(p.start)) {
+		if !f.matches(r.URL.Path) || (f.Type != FaultPartition && !p.hit(f.Ratio)) {
+			continue
+		}
+
+		switch f.Type {
+		case FaultLatency:
+			select {
+			case <-time.After(f.Latency + p.jitter(f.Jitter)):
+			case <-r.Context().Done():
+				return
+			}
+		case FaultError:
+			status := f.Status
+			if status == 0 {
+				status = http.StatusServiceUnavailable
+			}
+			http.Error(w, fmt.Sprintf("fault injected: %s", f), status)
+			return
+		default:
+			// Aborting the handler closes the connection without answering.
+			panic(http.ErrAbortHandler)
+		}
+	}
+
+	p.proxy.ServeHTTP(w, r)
+}
+
+// hit returns true for a ratio of the calls, all of them if ratio is 0.
+func (p *Proxy) hit(ratio float64) bool {
+
+	if ratio == 0 || ratio >= 1 {
+		return true
+	}
+
+	p.randLock.Lock()
+	defer p.randLock.Unlock()
+
+	return p.rand.Float64() < ratio
+}
+
+// jitter returns a random duration up to max.
+func (p *Proxy) jitter(max time.Duration) time.Duration {
+
+	if max <= 0 {
+		return 0
+	}
+
+	p.randLock.Lock()
+	defer p.randLock.Unlock()
+
+	return time.Duration(p.rand.Int63n(int64(max)))
+}
+
+// Serve serves the proxy on l, over TLS if tlsConfig is set, until ctx is done. The connections
+// are tracked to be closed when a partition starts, including the upgraded connections (e.g. the
+// enforcer push channels), and the fault transitions are logged.
+func (p *Proxy) Serve(ctx context.Context, l net.Listener, tlsConfig *tls.Config) error {
+
+	l = &listener{Listener: l, proxy: p}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	server := &http.Server{Handler: p, ReadHeaderTimeout: time.Minute}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.watch(ctx)
+		server.Close()
+	}()
+
+	err := server.Serve(l)
+	<-done
+	if err == http.ErrServerClosed {
+		err = ctx.Err()
+	}
+
+	return err
+}
+
+// watch logs the fault transitions every watchInterval until ctx is done, and closes the tracked
+// connections when a partition starts.
+func (p *Proxy) watch(ctx context.Context) {
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	active := map[*Fault]bool{}
+	for {
+		current := map[*Fault]bool{}
+		partition := false
+		for _, f := range p.schedule.Active(p.now().Sub(p.start)) {
+			current[f] = true
+			if active[f] {
+				continue
+			}
+			common.Log.Infof("Fault %s started", f)
+			partition = partition || f.Type == FaultPartition
+		}
+		for f := range active {
+			if !current[f] {
+				common.Log.Infof("Fault %s ended", f)
+			}
+		}
+		active = current
+
+		if partition {
+			common.Log.Infof("Partition: closed %d connections", p.closeConns())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeConns closes the tracked connections and returns their number.
+func (p *Proxy) closeConns() int {
+
+	p.connsLock.Lock()
+	conns := make([]net.Conn, 0, len(p.conns))
+	for c := range p.conns {
+		conns = append(conns, c)
+	}
+	p.connsLock.Unlock()
+
+	// Closing a connection untracks it.
+	for _, c := range conns {
+		c.Close()
+	}
+
+	return len(conns)
+}
+
+// A listener tracks the connections accepted in its proxy, until closed.
+type listener struct {
+	net.Listener
+	proxy *Proxy
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &conn{Conn: c, proxy: l.proxy}
+	l.proxy.connsLock.Lock()
+	l.proxy.conns[tc] = struct{}{}
+	l.proxy.connsLock.Unlock()
+
+	return tc, nil
+}
+
+// A conn is a connection tracked by its proxy.
+type conn struct {
+	net.Conn
+	proxy *Proxy
+}
+
+func (c *conn) Close() error {
+
+	c.proxy.connsLock.Lock()
+	delete(c.proxy.conns, c)
+	c.proxy.connsLock.Unlock()
+
+	return c.Conn.Close()
+}
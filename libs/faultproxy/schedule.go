@@ -0,0 +1,175 @@
+// Package faultproxy implements a reverse proxy between the simulated enforcers and the API,
+// injecting latency, error responses, connection drops and full partitions on a schedule, to test
+// the enforcer offline API handling and the reconnect storms after a backend outage.
+package faultproxy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A FaultType is a type of fault injected by the proxy.
+type FaultType string
+
+const (
+	// FaultLatency delays the requests by Latency, plus up to Jitter.
+	FaultLatency FaultType = "latency"
+	// FaultError answers the requests with the Status error, without forwarding them.
+	FaultError FaultType = "error"
+	// FaultDrop closes the connection of the requests without answering them.
+	FaultDrop FaultType = "drop"
+	// FaultPartition closes all the connections when it starts, and the connection of every
+	// request while active, regardless of Ratio and Paths, as if the API was unreachable.
+	FaultPartition FaultType = "partition"
+)
+
+// FaultTypes are all the supported fault types.
+var FaultTypes = []FaultType{
+	FaultLatency,
+	FaultError,
+	FaultDrop,
+	FaultPartition,
+}
+
+// A Fault is a fault injected by the proxy during a window of the schedule.
+type Fault struct {
+	Type FaultType `yaml:"type"`
+	// Start is the start of the fault, since the start of the proxy or of the schedule period.
+	Start time.Duration `yaml:"start"`
+	// Duration is the duration of the fault. The fault lasts forever (or until the end of the
+	// schedule period) if 0.
+	Duration time.Duration `yaml:"duration,omitempty"`
+	// Ratio is the ratio of the matching requests affected, all of them if 0.
+	Ratio float64 `yaml:"ratio,omitempty"`
+	// Latency and Jitter are the delay of the latency faults.
+	Latency time.Duration `yaml:"latency,omitempty"`
+	Jitter  time.Duration `yaml:"jitter,omitempty"`
+	// Status is the status code of the error faults, 503 if 0.
+	Status int `yaml:"status,omitempty"`
+	// Paths are the path prefixes of the requests affected, all of them if empty.
+	Paths []string `yaml:"paths,omitempty"`
+}
+
+// String returns a human readable description of f.
+func (f *Fault) String() string {
+
+	s := fmt.Sprintf("%s at %v", f.Type, f.Start)
+	if f.Duration > 0 {
+		s += fmt.Sprintf(" for %v", f.Duration)
+	}
+
+	return s
+}
+
+// Validate checks that the parameters of f are valid.
+func (f *Fault) Validate() error {
+
+	switch f.Type {
+	case FaultLatency:
+		if f.Latency <= 0 && f.Jitter <= 0 {
+			return fmt.Errorf("%s: latency or jitter must be positive", f.Type)
+		}
+	case FaultError:
+		if f.Status != 0 && (f.Status < 400 || f.Status > 599) {
+			return fmt.Errorf("%s: invalid error status %d", f.Type, f.Status)
+		}
+	case FaultDrop, FaultPartition:
+	default:
+		return fmt.Errorf("unknown fault type %q", f.Type)
+	}
+
+	if f.Start < 0 || f.Duration < 0 || f.Latency < 0 || f.Jitter < 0 {
+		return fmt.Errorf("%s: negative duration", f.Type)
+	}
+	if f.Ratio < 0 || f.Ratio > 1 {
+		return fmt.Errorf("%s: ratio %v out of [0, 1]", f.Type, f.Ratio)
+	}
+
+	return nil
+}
+
+// active returns true if f is active elapsed since the start of the schedule period.
+func (f *Fault) active(elapsed time.Duration) bool {
+
+	return elapsed >= f.Start && (f.Duration == 0 || elapsed < f.Start+f.Duration)
+}
+
+// matches returns true if f applies to the requests of path.
+func (f *Fault) matches(path string) bool {
+
+	if f.Type == FaultPartition || len(f.Paths) == 0 {
+		return true
+	}
+	for _, p := range f.Paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A Schedule is the faults injected by the proxy over time.
+type Schedule struct {
+	Faults []*Fault `yaml:"faults"`
+	// Repeat is the period of the schedule, which is run once if 0.
+	Repeat time.Duration `yaml:"repeat,omitempty"`
+}
+
+// LoadSchedule loads and validates the schedule stored in file.
+func LoadSchedule(file string) (*Schedule, error) {
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", file, err)
+	}
+
+	var s Schedule
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %v", file, err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid schedule %s: %v", file, err)
+	}
+
+	return &s, nil
+}
+
+// Validate checks that the faults of s are valid.
+func (s *Schedule) Validate() error {
+
+	if s.Repeat < 0 {
+		return fmt.Errorf("negative repeat period")
+	}
+	for i, f := range s.Faults {
+		if err := f.Validate(); err != nil {
+			return fmt.Errorf("fault %d: %v", i, err)
+		}
+		if s.Repeat > 0 && f.Start >= s.Repeat {
+			return fmt.Errorf("fault %d: starts after the repeat period %v", i, s.Repeat)
+		}
+	}
+
+	return nil
+}
+
+// Active returns the faults of s active elapsed since the start of the schedule.
+func (s *Schedule) Active(elapsed time.Duration) []*Fault {
+
+	if s.Repeat > 0 {
+		elapsed %= s.Repeat
+	}
+
+	var active []*Fault
+	for _, f := range s.Faults {
+		if f.active(elapsed) {
+			active = append(active, f)
+		}
+	}
+
+	return active
+}
@@ -80,6 +80,30 @@ func (ac *APIClient) CreateInNS(ns string, object elemental.Identifiable,
 	return nil
 }
 
+// UpdateInNS updates object in namespace ns, prepending mctxopts to the options used to create
+// the manipulator's context.
+func (ac *APIClient) UpdateInNS(ns string, object elemental.Identifiable,
+	mctxopts ...manipulate.ContextOption) error {
+
+	// NOTE: The options passed in the manipulator and the context are merged, with the context's
+	// taking precedence.
+	ctx := context.Background()
+	if ac.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ac.Timeout)
+		defer cancel()
+	}
+	mctxopts = append(mctxopts, manipulate.ContextOptionNamespace(ns))
+	mctx := manipulate.NewContext(ctx, mctxopts...)
+
+	// Update the object on the backend
+	if err := ac.Manipulator.Update(mctx, object); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // DeleteInNS deletes object from namespace ns, prepending mctxopts to the options used to create
 // the manipulator's context.
 func (ac *APIClient) DeleteInNS(ns string, object elemental.Identifiable,
@@ -0,0 +1,157 @@
+// Package k8s implements the kubernetes cluster operations of the simulator tests (namespaces,
+// secrets, deployments rollouts, pods readiness and cleanup) on top of client-go.
+//
+// When run in a pod (e.g. as an in-cluster Job), the service account of the pod needs create, get,
+// list, patch, update and delete access to namespaces, secrets, serviceaccounts, configmaps, pods
+// and deployments.
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.aporeto.io/simulator-test-harness/common"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// DefaultTimeout is the default timeout of a single API call.
+	DefaultTimeout = 30 * time.Second
+	// DefaultWaitTimeout is the default timeout of the operations waiting for the cluster to
+	// converge (rollouts, pods readiness, namespace deletion).
+	DefaultWaitTimeout = 5 * time.Minute
+	// DefaultPollInterval is the default interval between checks while waiting.
+	DefaultPollInterval = 5 * time.Second
+)
+
+// A Client performs the cluster operations of the simulator tests.
+type Client struct {
+	cs           kubernetes.Interface
+	timeout      time.Duration
+	waitTimeout  time.Duration
+	pollInterval time.Duration
+}
+
+// An Option configures a Client.
+type Option func(*Client)
+
+// OptionTimeout sets the timeout of a single API call. Defaults to DefaultTimeout.
+func OptionTimeout(timeout time.Duration) Option {
+
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// OptionWaitTimeout sets the timeout of the operations waiting for the cluster to converge.
+// Defaults to DefaultWaitTimeout.
+func OptionWaitTimeout(timeout time.Duration) Option {
+
+	return func(c *Client) {
+		c.waitTimeout = timeout
+	}
+}
+
+// OptionPollInterval sets the interval between checks while waiting. Defaults to
+// DefaultPollInterval.
+func OptionPollInterval(interval time.Duration) Option {
+
+	return func(c *Client) {
+		c.pollInterval = interval
+	}
+}
+
+// NewClient returns a Client using cs, which can be a fake clientset for testing.
+func NewClient(cs kubernetes.Interface, opts ...Option) *Client {
+
+	c := &Client{
+		cs:           cs,
+		timeout:      DefaultTimeout,
+		waitTimeout:  DefaultWaitTimeout,
+		pollInterval: DefaultPollInterval,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// NewClientFromKubeconfig returns a Client for the cluster of kubeconfig. If kubeconfig is empty,
+// the in-cluster configuration is used, falling back to the default loading rules of kubectl
+// (i.e. $KUBECONFIG or ~/.kube/config) when not running in a pod.
+func NewClientFromKubeconfig(kubeconfig string, opts ...Option) (*Client, error) {
+
+	var config *rest.Config
+	var err error
+	if kubeconfig == "" {
+		config, err = rest.InClusterConfig()
+	}
+	if kubeconfig != "" || err == rest.ErrNotInCluster {
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{
+				ExplicitPath: kubeconfig,
+				Precedence:   clientcmd.NewDefaultClientConfigLoadingRules().Precedence,
+			},
+			&clientcmd.ConfigOverrides{},
+		).ClientConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load kubernetes configuration: %v", err)
+	}
+
+	cs, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("create kubernetes clientset: %v", err)
+	}
+
+	common.Log.Debugf("Using kubernetes API server %s", config.Host)
+	return NewClient(cs, opts...), nil
+}
+
+// Clientset returns the client's underlying clientset.
+func (c *Client) Clientset() kubernetes.Interface {
+
+	return c.cs
+}
+
+// call runs the API call f with the client timeout.
+func (c *Client) call(ctx context.Context, f func(ctx context.Context) error) error {
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	return f(ctx)
+}
+
+// waitFor polls cond until it returns true, an error, or the wait timeout expires. cond returns a
+// description of the status it observed, which is reported on timeout.
+func (c *Client) waitFor(
+	ctx context.Context,
+	op, namespace, name string,
+	cond func(ctx context.Context) (done bool, status string, err error),
+) error {
+
+	var status string
+	err := wait.PollUntilContextTimeout(ctx, c.pollInterval, c.waitTimeout, true,
+		func(ctx context.Context) (done bool, err error) {
+			err = c.call(ctx, func(ctx context.Context) error {
+				done, status, err = cond(ctx)
+				return err
+			})
+			if !done && err == nil {
+				common.Log.Debugf("%s %s/%s: %s", op, namespace, name, status)
+			}
+			return done, err
+		},
+	)
+	if err != nil {
+		return &Error{Op: op, Namespace: namespace, Name: name, Err: err, Status: status}
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package k8s
+
+import (
+	"fmt"
+	"path"
+)
+
+// An Error is the error of a cluster operation. The underlying error can be inspected with the
+// k8s.io/apimachinery/pkg/api/errors helpers (e.g. IsNotFound), or errors.Is for the context
+// errors of timeouts.
+type Error struct {
+	// Op is the failed operation, e.g. "create namespace".
+	Op string
+	// Namespace is the namespace of the object, if namespaced.
+	Namespace string
+	// Name is the name of the object, if any.
+	Name string
+	// Err is the underlying error.
+	Err error
+	// Status is the last status observed by the operations waiting for the cluster to converge.
+	Status string
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+
+	msg := e.Op
+	if e.Name != "" || e.Namespace != "" {
+		msg += " " + path.Join(e.Namespace, e.Name)
+	}
+	msg += fmt.Sprintf(": %v", e.Err)
+	if e.Status != "" {
+		msg += fmt.Sprintf(" (%s)", e.Status)
+	}
+
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+
+	return e.Err
+}
+
+// newError returns an *Error for op on namespace/name, or nil if err is nil.
+func newError(op, namespace, name string, err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	return &Error{Op: op, Namespace: namespace, Name: name, Err: err}
+}
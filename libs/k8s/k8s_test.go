@@ -0,0 +1,317 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.aporeto.io/simulator-test-harness/libs/chart"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testClient(objects ...runtime.Object) *Client {
+
+	return NewClient(fake.NewSimpleClientset(objects...),
+		OptionPollInterval(time.Millisecond),
+		OptionWaitTimeout(50*time.Millisecond),
+	)
+}
+
+func pod(name string, phase corev1.PodPhase, ready bool, containers int) *corev1.Pod {
+
+	p := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "sim",
+			Labels:    map[string]string{"app": "simulator"},
+		},
+		Spec:   corev1.PodSpec{Containers: make([]corev1.Container, containers)},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	p.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+
+	return p
+}
+
+func TestNamespaces(t *testing.T) {
+
+	ctx := context.Background()
+	c := testClient()
+
+	for i := 0; i < 2; i++ {
+		if err := c.CreateNamespace(ctx, "simulator-ab12c", nil); err != nil {
+			t.Fatalf("CreateNamespace() error = %v", err)
+		}
+	}
+	if err := c.CreateNamespace(ctx, "other", nil); err != nil {
+		t.Fatalf("CreateNamespace() error = %v", err)
+	}
+
+	names, err := c.ListNamespaces(ctx, "simulator-")
+	if err != nil {
+		t.Fatalf("ListNamespaces() error = %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"simulator-ab12c"}) {
+		t.Errorf("ListNamespaces() = %v", names)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := c.DeleteNamespace(ctx, "simulator-ab12c"); err != nil {
+			t.Fatalf("DeleteNamespace() error = %v", err)
+		}
+	}
+}
+
+func TestSecrets(t *testing.T) {
+
+	ctx := context.Background()
+	c := testClient(&corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "sim"},
+	})
+
+	for _, creds := range []string{"old", "new"} {
+		if err := c.ApplyCredsSecret(ctx, "sim", "enforcerd", []byte(creds)); err != nil {
+			t.Fatalf("ApplyCredsSecret() error = %v", err)
+		}
+	}
+	secret, err := c.cs.CoreV1().Secrets("sim").Get(ctx, "enforcerd", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get secret: %v", err)
+	}
+	if string(secret.Data[CredsKey]) != "new" {
+		t.Errorf("got creds %q, want new", secret.Data[CredsKey])
+	}
+
+	if err := c.PatchServiceAccountPullSecret(ctx, "sim", "default", "apo-secret"); err != nil {
+		t.Fatalf("PatchServiceAccountPullSecret() error = %v", err)
+	}
+	sa, err := c.cs.CoreV1().ServiceAccounts("sim").Get(ctx, "default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get service account: %v", err)
+	}
+	if len(sa.ImagePullSecrets) != 1 || sa.ImagePullSecrets[0].Name != "apo-secret" {
+		t.Errorf("got image pull secrets %v", sa.ImagePullSecrets)
+	}
+
+	err = c.PatchServiceAccountPullSecret(ctx, "other", "default", "apo-secret")
+	var kerr *Error
+	if !errors.As(err, &kerr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("PatchServiceAccountPullSecret() error = %v, want a timeout *Error", err)
+	}
+	if kerr.Namespace != "other" || kerr.Status != "service account not found" {
+		t.Errorf("unexpected error details %+v", kerr)
+	}
+}
+
+func TestRolloutDone(t *testing.T) {
+
+	replicas := int32(3)
+	tests := []struct {
+		name    string
+		status  appsv1.DeploymentStatus
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:   "complete",
+			status: appsv1.DeploymentStatus{Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3},
+			want:   true,
+		},
+		{
+			name:   "updating",
+			status: appsv1.DeploymentStatus{Replicas: 3, UpdatedReplicas: 1, AvailableReplicas: 1},
+		},
+		{
+			name:   "old replicas",
+			status: appsv1.DeploymentStatus{Replicas: 4, UpdatedReplicas: 3, AvailableReplicas: 3},
+		},
+		{
+			name:   "unavailable",
+			status: appsv1.DeploymentStatus{Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 2},
+		},
+		{
+			name: "progress deadline exceeded",
+			status: appsv1.DeploymentStatus{
+				Conditions: []appsv1.DeploymentCondition{
+					{Type: appsv1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded"},
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dep := &appsv1.Deployment{
+				Spec:   appsv1.DeploymentSpec{Replicas: &replicas},
+				Status: tt.status,
+			}
+			got, _, err := rolloutDone(dep)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rolloutDone() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("rolloutDone() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRolloutStatus(t *testing.T) {
+
+	ctx := context.Background()
+	replicas := int32(2)
+	c := testClient(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "sim-pods", Namespace: "sim"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 1},
+	})
+
+	err := c.RolloutStatus(ctx, "sim", "sim-pods")
+	var kerr *Error
+	if !errors.As(err, &kerr) || kerr.Status != "2/2 replicas updated, 1 available" {
+		t.Fatalf("RolloutStatus() error = %v, want a timeout with the last status", err)
+	}
+
+	if err := c.RolloutStatus(ctx, "sim", "missing"); err == nil {
+		t.Errorf("RolloutStatus() of a missing deployment succeeded")
+	}
+
+	if err := c.ScaleDeployment(ctx, "sim", "sim-pods", 5); err != nil {
+		t.Fatalf("ScaleDeployment() error = %v", err)
+	}
+	if got, err := c.DeploymentReplicas(ctx, "sim", "sim-pods"); err != nil || got != 5 {
+		t.Errorf("DeploymentReplicas() = %d, %v, want 5", got, err)
+	}
+	if err := c.ScaleDeployment(ctx, "sim", "missing", 5); err == nil {
+		t.Errorf("ScaleDeployment() of a missing deployment succeeded")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := c.DeleteDeployment(ctx, "sim", "sim-pods"); err != nil {
+			t.Fatalf("DeleteDeployment() error = %v", err)
+		}
+	}
+	if exists, err := c.DeploymentExists(ctx, "sim", "sim-pods"); err != nil || exists {
+		t.Errorf("DeploymentExists() = %v, %v after deletion", exists, err)
+	}
+}
+
+func TestPods(t *testing.T) {
+
+	ctx := context.Background()
+	sidecar := pod("sidecar", corev1.PodRunning, true, 3)
+	sidecar.Spec.Containers[2].Name = chart.FaultProxyContainer
+	c := testClient(
+		pod("running", corev1.PodRunning, true, 10),
+		sidecar,
+		pod("pending", corev1.PodPending, false, 10),
+		pod("failed", corev1.PodFailed, false, 10),
+		pod("succeeded", corev1.PodSucceeded, false, 10),
+	)
+
+	if err := c.WaitPodsReady(ctx, "sim", "app=simulator"); err == nil {
+		t.Errorf("WaitPodsReady() succeeded with unready pods")
+	}
+
+	count, err := c.CountRunningContainers(ctx, "sim", "app=simulator")
+	if err != nil {
+		t.Fatalf("CountRunningContainers() error = %v", err)
+	}
+	if count != 12 {
+		t.Errorf("CountRunningContainers() = %d, want 12 without the fault proxy", count)
+	}
+
+	crashing := pod("crashing", corev1.PodRunning, false, 1)
+	crashing.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		State: corev1.ContainerState{
+			Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+		},
+	}}
+	if _, err := c.cs.CoreV1().Pods("sim").Create(ctx, crashing, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	failed, err := c.FailedPods(ctx, "sim", "app=simulator")
+	if err != nil {
+		t.Fatalf("FailedPods() error = %v", err)
+	}
+	if !reflect.DeepEqual(failed, []string{"crashing", "failed", "succeeded"}) {
+		t.Errorf("FailedPods() = %v", failed)
+	}
+	if err := c.cs.CoreV1().Pods("sim").Delete(ctx, "crashing", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := c.DeleteFailedPods(ctx, "sim")
+	if err != nil {
+		t.Fatalf("DeleteFailedPods() error = %v", err)
+	}
+	if !reflect.DeepEqual(deleted, []string{"failed", "succeeded"}) {
+		t.Errorf("DeleteFailedPods() = %v", deleted)
+	}
+
+	if err := c.cs.CoreV1().Pods("sim").Delete(ctx, "pending", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WaitPodsReady(ctx, "sim", ""); err != nil {
+		t.Errorf("WaitPodsReady() error = %v", err)
+	}
+	if err := c.WaitPodsReady(ctx, "empty", ""); err == nil {
+		t.Errorf("WaitPodsReady() succeeded without pods")
+	}
+}
+
+func TestAssignPodSlots(t *testing.T) {
+
+	ctx := context.Background()
+	assigned := pod("assigned", corev1.PodRunning, true, 2)
+	assigned.Labels["npod"] = "ab12c-1-1"
+	duplicate := pod("duplicate", corev1.PodPending, false, 2)
+	duplicate.Labels["npod"] = "ab12c-1-1"
+	failed := pod("failed", corev1.PodFailed, false, 2)
+	failed.Labels["npod"] = "ab12c-1-0"
+	c := testClient(
+		assigned,
+		duplicate,
+		failed,
+		pod("unassigned", corev1.PodPending, false, 2),
+	)
+
+	slots := []string{"ab12c-1-0", "ab12c-1-1", "ab12c-1-2"}
+	if err := c.AssignPodSlots(ctx, "sim", "app=simulator", "npod", slots); err != nil {
+		t.Fatalf("AssignPodSlots() error = %v", err)
+	}
+
+	got := map[string]string{}
+	pods, err := c.listPods(ctx, "sim", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pods {
+		got[p.Name] = p.Labels["npod"]
+	}
+	want := map[string]string{
+		"assigned":   "ab12c-1-1",
+		"duplicate":  "ab12c-1-0",
+		"failed":     "ab12c-1-0",
+		"unassigned": "ab12c-1-2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pod slots = %v, want %v", got, want)
+	}
+
+	if err := c.AssignPodSlots(ctx, "sim", "app=simulator", "npod",
+		append(slots, "ab12c-1-3")); err == nil {
+		t.Errorf("AssignPodSlots() succeeded with more slots than pods")
+	}
+}
@@ -0,0 +1,94 @@
+package k8s
+
+import (
+	"context"
+	"strings"
+
+	"go.aporeto.io/simulator-test-harness/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CreateNamespace creates the namespace name with labels. Creating an existing namespace is not an
+// error.
+func (c *Client) CreateNamespace(ctx context.Context, name string, labels map[string]string) error {
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+
+	err := c.call(ctx, func(ctx context.Context) error {
+		_, err := c.cs.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
+		return err
+	})
+	if k8serrors.IsAlreadyExists(err) {
+		common.Log.Debugf("Namespace %s already exists", name)
+		return nil
+	}
+	if err != nil {
+		return newError("create namespace", "", name, err)
+	}
+
+	common.Log.Infof("Created namespace %s", name)
+	return nil
+}
+
+// DeleteNamespace deletes the namespace name, and waits for the deletion of all its objects.
+// Deleting a missing namespace is not an error.
+func (c *Client) DeleteNamespace(ctx context.Context, name string) error {
+
+	err := c.call(ctx, func(ctx context.Context) error {
+		return c.cs.CoreV1().Namespaces().Delete(ctx, name, metav1.DeleteOptions{})
+	})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return newError("delete namespace", "", name, err)
+	}
+
+	err = c.waitFor(ctx, "delete namespace", "", name,
+		func(ctx context.Context) (bool, string, error) {
+			ns, err := c.cs.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+			if k8serrors.IsNotFound(err) {
+				return true, "", nil
+			}
+			if err != nil {
+				return false, "", err
+			}
+			return false, string(ns.Status.Phase), nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	common.Log.Infof("Deleted namespace %s", name)
+	return nil
+}
+
+// ListNamespaces returns the names of the namespaces starting with prefix.
+func (c *Client) ListNamespaces(ctx context.Context, prefix string) ([]string, error) {
+
+	var list *corev1.NamespaceList
+	err := c.call(ctx, func(ctx context.Context) (err error) {
+		list, err = c.cs.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, newError("list namespaces", "", "", err)
+	}
+
+	var names []string
+	for _, ns := range list.Items {
+		if strings.HasPrefix(ns.Name, prefix) {
+			names = append(names, ns.Name)
+		}
+	}
+
+	return names, nil
+}
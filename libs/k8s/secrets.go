@@ -0,0 +1,96 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.aporeto.io/simulator-test-harness/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// CredsKey is the key of the enforcer application credentials in their secret, as expected by the
+// enforcer-sim chart.
+const CredsKey = "aporeto.creds"
+
+// ApplySecret creates secret, or updates it if it already exists.
+func (c *Client) ApplySecret(ctx context.Context, secret *corev1.Secret) error {
+
+	secrets := c.cs.CoreV1().Secrets(secret.Namespace)
+	err := c.call(ctx, func(ctx context.Context) error {
+		_, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+		if k8serrors.IsAlreadyExists(err) {
+			_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		}
+		return err
+	})
+	if err != nil {
+		return newError("apply secret", secret.Namespace, secret.Name, err)
+	}
+
+	common.Log.Infof("Applied secret %s/%s", secret.Namespace, secret.Name)
+	return nil
+}
+
+// ApplyCredsSecret creates or updates the secret name holding the enforcer application credentials
+// creds.
+func (c *Client) ApplyCredsSecret(ctx context.Context, namespace, name string, creds []byte) error {
+
+	return c.ApplySecret(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       map[string][]byte{CredsKey: creds},
+	})
+}
+
+// ApplyImagePullSecret creates or updates the secret name holding the docker configuration
+// dockerConfig, which must be logged in the private registry of the images.
+func (c *Client) ApplyImagePullSecret(
+	ctx context.Context,
+	namespace, name string,
+	dockerConfig []byte,
+) error {
+
+	return c.ApplySecret(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: dockerConfig},
+	})
+}
+
+// PatchServiceAccountPullSecret adds the image pull secret to the service account. As the service
+// accounts of new namespaces are created asynchronously, it waits for the service account to exist.
+func (c *Client) PatchServiceAccountPullSecret(
+	ctx context.Context,
+	namespace, serviceAccount, secret string,
+) error {
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"imagePullSecrets": []corev1.LocalObjectReference{{Name: secret}},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal service account patch: %v", err)
+	}
+
+	err = c.waitFor(ctx, "patch service account", namespace, serviceAccount,
+		func(ctx context.Context) (bool, string, error) {
+			_, err := c.cs.CoreV1().ServiceAccounts(namespace).Patch(
+				ctx, serviceAccount, types.StrategicMergePatchType, patch, metav1.PatchOptions{},
+			)
+			if k8serrors.IsNotFound(err) {
+				return false, "service account not found", nil
+			}
+			return err == nil, "", err
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	common.Log.Infof("Added image pull secret %s to service account %s/%s", secret, namespace,
+		serviceAccount)
+	return nil
+}
@@ -0,0 +1,378 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.aporeto.io/simulator-test-harness/common"
+	"go.aporeto.io/simulator-test-harness/libs/chart"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RolloutStatus waits for the rollout of the deployment name to complete, like kubectl rollout
+// status. It fails early if the deployment exceeded its progress deadline.
+func (c *Client) RolloutStatus(ctx context.Context, namespace, name string) error {
+
+	err := c.waitFor(ctx, "rollout status", namespace, name,
+		func(ctx context.Context) (bool, string, error) {
+			dep, err := c.cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return false, "", err
+			}
+			return rolloutDone(dep)
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	common.Log.Infof("Deployment %s/%s successfully rolled out", namespace, name)
+	return nil
+}
+
+// rolloutDone reports whether the rollout of dep is complete, along with its status.
+func rolloutDone(dep *appsv1.Deployment) (bool, string, error) {
+
+	if dep.Generation > dep.Status.ObservedGeneration {
+		return false, "waiting for the deployment spec update to be observed", nil
+	}
+
+	for _, cond := range dep.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+			return false, "", fmt.Errorf("deployment exceeded its progress deadline")
+		}
+	}
+
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	status := fmt.Sprintf("%d/%d replicas updated, %d available", dep.Status.UpdatedReplicas,
+		replicas, dep.Status.AvailableReplicas)
+
+	switch {
+	case dep.Status.UpdatedReplicas < replicas:
+		return false, status, nil
+	case dep.Status.Replicas > dep.Status.UpdatedReplicas:
+		return false, status + ", old replicas pending termination", nil
+	case dep.Status.AvailableReplicas < dep.Status.UpdatedReplicas:
+		return false, status, nil
+	}
+
+	return true, status, nil
+}
+
+// WaitPodsReady waits for all the pods matching selector (all the pods of the namespace if empty)
+// to be ready, like kubectl wait --for condition=Ready. There must be at least one such pod.
+func (c *Client) WaitPodsReady(ctx context.Context, namespace, selector string) error {
+
+	return c.waitFor(ctx, "wait pods ready", namespace, selector,
+		func(ctx context.Context) (bool, string, error) {
+			pods, err := c.listPods(ctx, namespace, selector)
+			if err != nil {
+				return false, "", err
+			}
+
+			ready := 0
+			for i := range pods {
+				if podReady(&pods[i]) {
+					ready++
+				}
+			}
+			return len(pods) > 0 && ready == len(pods),
+				fmt.Sprintf("%d/%d pods ready", ready, len(pods)), nil
+		},
+	)
+}
+
+// podReady reports whether the Ready condition of pod is true.
+func podReady(pod *corev1.Pod) bool {
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// DeleteFailedPods deletes the pods of namespace which are neither running nor pending, and
+// returns their names.
+func (c *Client) DeleteFailedPods(ctx context.Context, namespace string) ([]string, error) {
+
+	pods, err := c.listPods(ctx, namespace, "")
+	if err != nil {
+		return nil, newError("list pods", namespace, "", err)
+	}
+
+	var deleted []string
+	for _, pod := range pods {
+		if !podTerminated(&pod) {
+			continue
+		}
+
+		err := c.call(ctx, func(ctx context.Context) error {
+			return c.cs.CoreV1().Pods(namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+		})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return deleted, newError("delete pod", namespace, pod.Name, err)
+		}
+
+		common.Log.Infof("Deleted %s pod %s/%s", pod.Status.Phase, namespace, pod.Name)
+		deleted = append(deleted, pod.Name)
+	}
+
+	return deleted, nil
+}
+
+// FailedPods returns the names of the pods matching selector (all the pods of the namespace if
+// empty) which are neither running nor pending, or have a container in CrashLoopBackOff.
+func (c *Client) FailedPods(ctx context.Context, namespace, selector string) ([]string, error) {
+
+	pods, err := c.listPods(ctx, namespace, selector)
+	if err != nil {
+		return nil, newError("list pods", namespace, selector, err)
+	}
+
+	var failed []string
+	for i := range pods {
+		if podTerminated(&pods[i]) || podCrashLooping(&pods[i]) {
+			failed = append(failed, pods[i].Name)
+		}
+	}
+
+	return failed, nil
+}
+
+// podTerminated reports whether pod is neither running nor pending.
+func podTerminated(pod *corev1.Pod) bool {
+
+	return pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != corev1.PodPending
+}
+
+// podCrashLooping reports whether a container of pod is waiting in CrashLoopBackOff.
+func podCrashLooping(pod *corev1.Pod) bool {
+
+	for _, statuses := range [][]corev1.ContainerStatus{
+		pod.Status.InitContainerStatuses,
+		pod.Status.ContainerStatuses,
+	} {
+		for _, cs := range statuses {
+			if cs.State.Waiting != nil && cs.State.Waiting.Reason == "CrashLoopBackOff" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// CountRunningContainers returns the number of containers (i.e. simulators) of the running pods
+// matching selector (all the pods of the namespace if empty), the fault proxy sidecars excepted.
+func (c *Client) CountRunningContainers(
+	ctx context.Context,
+	namespace, selector string,
+) (int, error) {
+
+	pods, err := c.listPods(ctx, namespace, selector)
+	if err != nil {
+		return 0, newError("list pods", namespace, selector, err)
+	}
+
+	count := 0
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		for _, c := range pod.Spec.Containers {
+			if c.Name != chart.FaultProxyContainer {
+				count++
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// AssignPodSlots labels the pods matching selector with the slots values of label, and waits for
+// all the slots to be assigned: every live pod whose label is not one of slots (e.g. a placeholder
+// set by its template) gets the first slot held by no other live pod.
+func (c *Client) AssignPodSlots(
+	ctx context.Context,
+	namespace, selector, label string,
+	slots []string,
+) error {
+
+	return c.waitFor(ctx, "assign pod slots", namespace, selector,
+		func(ctx context.Context) (bool, string, error) {
+			pods, err := c.listPods(ctx, namespace, selector)
+			if err != nil {
+				return false, "", err
+			}
+
+			held := map[string]bool{}
+			var unassigned []string
+			for i := range pods {
+				if pods[i].DeletionTimestamp != nil || podTerminated(&pods[i]) {
+					continue
+				}
+				if slot := pods[i].Labels[label]; contains(slots, slot) && !held[slot] {
+					held[slot] = true
+				} else {
+					unassigned = append(unassigned, pods[i].Name)
+				}
+			}
+
+			for _, slot := range slots {
+				if len(unassigned) == 0 {
+					break
+				}
+				if held[slot] {
+					continue
+				}
+				if err := c.labelPod(ctx, namespace, unassigned[0], label, slot); err != nil {
+					return false, "", err
+				}
+				common.Log.Debugf("Assigned slot %s to pod %s/%s", slot, namespace, unassigned[0])
+				held[slot], unassigned = true, unassigned[1:]
+			}
+
+			return len(held) == len(slots),
+				fmt.Sprintf("%d/%d slots assigned", len(held), len(slots)), nil
+		},
+	)
+}
+
+// labelPod sets the label of the pod name to value.
+func (c *Client) labelPod(ctx context.Context, namespace, name, label, value string) error {
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": map[string]string{label: value}},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal pod patch: %v", err)
+	}
+
+	err = c.call(ctx, func(ctx context.Context) error {
+		_, err := c.cs.CoreV1().Pods(namespace).Patch(
+			ctx, name, types.MergePatchType, patch, metav1.PatchOptions{},
+		)
+		return err
+	})
+	if err != nil {
+		return newError("label pod", namespace, name, err)
+	}
+
+	return nil
+}
+
+// contains reports whether s is one of values.
+func contains(values []string, s string) bool {
+
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listPods returns the pods of namespace matching the label selector.
+func (c *Client) listPods(ctx context.Context, namespace, selector string) ([]corev1.Pod, error) {
+
+	var list *corev1.PodList
+	err := c.call(ctx, func(ctx context.Context) (err error) {
+		list, err = c.cs.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: selector,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return list.Items, nil
+}
+
+// DeploymentExists reports whether the deployment name exists.
+func (c *Client) DeploymentExists(ctx context.Context, namespace, name string) (bool, error) {
+
+	err := c.call(ctx, func(ctx context.Context) error {
+		_, err := c.cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		return err
+	})
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, newError("get deployment", namespace, name, err)
+	}
+
+	return true, nil
+}
+
+// DeleteDeployment deletes the deployment name, along with its pods. A missing deployment is not
+// an error.
+func (c *Client) DeleteDeployment(ctx context.Context, namespace, name string) error {
+
+	propagation := metav1.DeletePropagationBackground
+	err := c.call(ctx, func(ctx context.Context) error {
+		return c.cs.AppsV1().Deployments(namespace).Delete(ctx, name, metav1.DeleteOptions{
+			PropagationPolicy: &propagation,
+		})
+	})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return newError("delete deployment", namespace, name, err)
+	}
+
+	common.Log.Infof("Deleted deployment %s/%s", namespace, name)
+	return nil
+}
+
+// ScaleDeployment sets the replicas of the deployment name.
+func (c *Client) ScaleDeployment(ctx context.Context, namespace, name string, replicas int) error {
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"replicas": replicas},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal deployment patch: %v", err)
+	}
+
+	err = c.call(ctx, func(ctx context.Context) error {
+		_, err := c.cs.AppsV1().Deployments(namespace).Patch(
+			ctx, name, types.MergePatchType, patch, metav1.PatchOptions{},
+		)
+		return err
+	})
+	if err != nil {
+		return newError("scale deployment", namespace, name, err)
+	}
+
+	return nil
+}
+
+// DeploymentReplicas returns the desired replicas of the deployment name.
+func (c *Client) DeploymentReplicas(ctx context.Context, namespace, name string) (int, error) {
+
+	var dep *appsv1.Deployment
+	err := c.call(ctx, func(ctx context.Context) (err error) {
+		dep, err = c.cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return 0, newError("get deployment", namespace, name, err)
+	}
+	if dep.Spec.Replicas == nil {
+		return 1, nil
+	}
+
+	return int(*dep.Spec.Replicas), nil
+}
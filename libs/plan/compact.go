@@ -0,0 +1,113 @@
+package plan
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// modelVersionKey is the key of the elemental model version, set to 1 in all gaia objects.
+const modelVersionKey = "modelversion"
+
+// Marshal marshals pl in yaml. With OptionCompact, all zero valued fields and the model versions
+// of the gaia objects are omitted, which is equivalent as far as the decoders are concerned (see
+// Unmarshal).
+func Marshal(pl *PlanLayout, opts ...Option) ([]byte, error) {
+
+	if !newOptions(opts...).compact {
+		return yaml.Marshal(pl)
+	}
+
+	var doc yaml.Node
+	if err := doc.Encode(pl); err != nil {
+		return nil, fmt.Errorf("encode plan: %v", err)
+	}
+	prune(&doc)
+
+	return yaml.Marshal(&doc)
+}
+
+// prune removes the zero valued mapping entries (i.e. zero scalars, nulls and empty sequences)
+// from the document rooted at n, and reports whether n itself holds a zero value.
+func prune(n *yaml.Node) bool {
+
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			prune(c)
+		}
+		return false
+
+	case yaml.MappingNode:
+		content := n.Content[:0]
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if prune(v) || k.Value == modelVersionKey {
+				continue
+			}
+			content = append(content, k, v)
+		}
+		n.Content = content
+		// NOTE: Empty mappings are kept, as they may decode to non nil pointers.
+		return false
+
+	case yaml.SequenceNode:
+		// NOTE: Sequence elements are never removed, as their position is meaningful.
+		for _, c := range n.Content {
+			prune(c)
+		}
+		return len(n.Content) == 0
+
+	case yaml.ScalarNode:
+		return isZeroScalar(n)
+
+	default:
+		return false
+	}
+}
+
+// isZeroScalar reports whether the scalar n decodes to the zero value of its type.
+func isZeroScalar(n *yaml.Node) bool {
+
+	switch n.ShortTag() {
+	case "!!null":
+		return true
+	case "!!str":
+		return n.Value == ""
+	case "!!bool":
+		return n.Value == "false"
+	case "!!int", "!!float":
+		var f float64
+		return n.Decode(&f) == nil && f == 0
+	case "!!timestamp":
+		var t time.Time
+		return n.Decode(&t) == nil && t.IsZero()
+	default:
+		return false
+	}
+}
+
+// restoreModelVersions sets the model version of the gaia objects of pl, which compact plans omit.
+func restoreModelVersions(pl *PlanLayout) {
+
+	for _, node := range pl.Plan.Nodes {
+		if pu := node.ProcessingUnit; pu != nil && pu.ModelVersion == 0 {
+			pu.ModelVersion = 1
+		}
+		if en := node.ExternalNetwork; en != nil && en.ModelVersion == 0 {
+			en.ModelVersion = 1
+		}
+		if hs := node.HostService; hs != nil && hs.ModelVersion == 0 {
+			hs.ModelVersion = 1
+		}
+		if node.Edges == nil {
+			continue
+		}
+		for _, flow := range node.Edges.Flows {
+			if fr := flow.Report; fr != nil && fr.ModelVersion == 0 {
+				fr.ModelVersion = 1
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package plan
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Unmarshal parses a plan from data, which can be either a full or a compact plan.
+func Unmarshal(data []byte) (*PlanLayout, error) {
+
+	var pl PlanLayout
+	if err := yaml.Unmarshal(data, &pl); err != nil {
+		return nil, fmt.Errorf("unmarshal plan: %v", err)
+	}
+	restoreModelVersions(&pl)
+
+	return &pl, nil
+}
+
+// Load parses the plan stored in planFile, which can be either a full or a compact plan.
+func Load(planFile string) (*PlanLayout, error) {
+
+	data, err := os.ReadFile(planFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", planFile, err)
+	}
+
+	pl, err := Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %v", planFile, err)
+	}
+
+	return pl, nil
+}
+
+// Save writes pl to planFile. See Marshal for the options.
+func Save(planFile string, pl *PlanLayout, opts ...Option) error {
+
+	data, err := Marshal(pl, opts...)
+	if err != nil {
+		return fmt.Errorf("marshal plan: %v", err)
+	}
+
+	if err := os.WriteFile(planFile, data, 0644); err != nil {
+		return fmt.Errorf("write plan to %q: %v", planFile, err)
+	}
+
+	return nil
+}
+
+// SaveShards writes each plan of shards to dir/plan-$i/plan.yaml, which is the layout expected by
+// the enforcer-sim chart. See Marshal for the options.
+func SaveShards(dir string, shards []*PlanLayout, opts ...Option) error {
+
+	for i, shard := range shards {
+		shardDir := filepath.Join(dir, fmt.Sprintf("plan-%d", i))
+		if err := os.MkdirAll(shardDir, 0755); err != nil {
+			return fmt.Errorf("create %s: %v", shardDir, err)
+		}
+		if err := Save(filepath.Join(shardDir, "plan.yaml"), shard, opts...); err != nil {
+			return fmt.Errorf("save shard %d: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// LoadConfig parses the plan generation configuration stored in configFile.
+func LoadConfig(configFile string) (*Config, error) {
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", configFile, err)
+	}
+
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %v", configFile, err)
+	}
+
+	return &c, nil
+}
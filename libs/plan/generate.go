@@ -0,0 +1,370 @@
+package plan
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.aporeto.io/gaia"
+)
+
+// DefaultProtocols are the protocols of the generated flows.
+var DefaultProtocols = []int{
+	1,  // ICMP
+	6,  // TCP
+	17, // UDP
+	41, // IPv6
+	47, // GRE
+}
+
+// DefaultServiceTypes are the service types of the generated flows.
+var DefaultServiceTypes = []gaia.FlowReportServiceTypeValue{
+	gaia.FlowReportServiceTypeHTTP,
+	gaia.FlowReportServiceTypeL3,
+	gaia.FlowReportServiceTypeTCP,
+}
+
+// PUTypes are the PU types that can be generated. The HostService PUs are generated from
+// Config.HostServices instead, as they need a host service behind them.
+var PUTypes = []gaia.ProcessingUnitTypeValue{
+	gaia.ProcessingUnitTypeDocker,
+	gaia.ProcessingUnitTypeHost,
+	gaia.ProcessingUnitTypeLinuxService,
+	gaia.ProcessingUnitTypeSSHSession,
+}
+
+// An Option configures the generation or serialization of a plan.
+type Option func(*options)
+
+type options struct {
+	rand         *rand.Rand
+	compact      bool
+	protocols    []int
+	serviceTypes []gaia.FlowReportServiceTypeValue
+	oracle       Oracle
+}
+
+// An Oracle returns the verdict of the deployed policies for the flows of a plan, e.g. a
+// *policyassert.Oracle.
+type Oracle interface {
+	// Accept returns true if the policies accept a flow from the PU src to the PU dst with the
+	// protocol number protocol on port.
+	Accept(src, dst *gaia.ProcessingUnit, protocol, port int) (bool, error)
+}
+
+// newOptions returns the options resulting from applying opts to the defaults.
+func newOptions(opts ...Option) *options {
+
+	o := &options{
+		rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		protocols:    DefaultProtocols,
+		serviceTypes: DefaultServiceTypes,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// OptionSeed seeds the random generator used for the plan generation, making it reproducible.
+func OptionSeed(seed int64) Option {
+
+	return func(o *options) {
+		o.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// OptionCompact selects the compact serialization, where the zero valued fields and the model
+// versions of the gaia objects are omitted.
+func OptionCompact(compact bool) Option {
+
+	return func(o *options) {
+		o.compact = compact
+	}
+}
+
+// OptionProtocols sets the protocols picked for the generated flows. Defaults to
+// DefaultProtocols.
+func OptionProtocols(protocols ...int) Option {
+
+	return func(o *options) {
+		o.protocols = protocols
+	}
+}
+
+// OptionServiceTypes sets the service types picked for the generated flows. Defaults to
+// DefaultServiceTypes.
+func OptionServiceTypes(serviceTypes ...gaia.FlowReportServiceTypeValue) Option {
+
+	return func(o *options) {
+		o.serviceTypes = serviceTypes
+	}
+}
+
+// OptionOracle sets the oracle computing the actions of the generated flows, so that the flows
+// reported agree with the deployed policies. The actions are picked at random if nil.
+func OptionOracle(oracle Oracle) Option {
+
+	return func(o *options) {
+		o.oracle = oracle
+	}
+}
+
+// Validate checks that the plan generation parameters of c are valid.
+func (c *Config) Validate() error {
+
+	if c.PUs < 0 {
+		return fmt.Errorf("invalid number of PUs %d", c.PUs)
+	}
+	if c.Flows < 0 {
+		return fmt.Errorf("invalid number of flows %d", c.Flows)
+	}
+	if c.Flows > 0 && c.PUs == 0 {
+		return fmt.Errorf("cannot generate %d flows per PU without PUs", c.Flows)
+	}
+	for _, tag := range c.PUMeta {
+		if !strings.HasPrefix(tag, "@") {
+			return fmt.Errorf("PU metadata %q does not start with @", tag)
+		}
+	}
+	if gaia.ProcessingUnitTypeValue(c.PUType) == gaia.ProcessingUnitTypeHostService {
+		return fmt.Errorf("PUs of type %s are generated from host-services", c.PUType)
+	}
+	for _, s := range c.Lifecycle.Scenarios {
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("invalid scenario: %v", err)
+		}
+	}
+	names := map[string]bool{}
+	for _, hs := range c.HostServices {
+		if err := hs.Validate(); err != nil {
+			return fmt.Errorf("invalid host service: %v", err)
+		}
+		if names[hs.Name] {
+			return fmt.Errorf("host service %s defined twice", hs.Name)
+		}
+		names[hs.Name] = true
+	}
+
+	return nil
+}
+
+// Validate checks that the services and metadata of hs are valid.
+func (hs *HostService) Validate() error {
+
+	if hs.Name == "" {
+		return fmt.Errorf("host service without name")
+	}
+	if len(hs.Services) == 0 {
+		return fmt.Errorf("host service %s has no services", hs.Name)
+	}
+	for _, service := range hs.Services {
+		if _, _, _, err := parseService(service); err != nil {
+			return fmt.Errorf("host service %s: %v", hs.Name, err)
+		}
+	}
+	for _, tag := range hs.Metadata {
+		if !strings.HasPrefix(tag, "@") {
+			return fmt.Errorf("host service %s metadata %q does not start with @", hs.Name, tag)
+		}
+	}
+
+	return nil
+}
+
+// Object returns the gaia host service of hs, tagged hostservice=<name>.
+func (hs *HostService) Object() *gaia.HostService {
+
+	o := gaia.NewHostService()
+	o.Name = hs.Name
+	o.AssociatedTags = []string{"hostservice=" + hs.Name}
+	o.Services = append([]string{}, hs.Services...)
+
+	return o
+}
+
+// serviceProtocols are the protocol numbers of the protocols of the host services.
+var serviceProtocols = map[string]int{
+	"tcp": 6,
+	"udp": 17,
+}
+
+// parseService returns the protocol number and the port range of service, e.g. tcp/22 or
+// udp/5000:5100.
+func parseService(service string) (protocol, lo, hi int, err error) {
+
+	name, ports, ok := strings.Cut(strings.ToLower(service), "/")
+	protocol, known := serviceProtocols[name]
+	if !ok || !known {
+		return 0, 0, 0, fmt.Errorf("invalid service %q, want tcp/<port>[:<port>] or udp/...",
+			service)
+	}
+
+	first, last, isRange := strings.Cut(ports, ":")
+	if !isRange {
+		last = first
+	}
+	if lo, err = strconv.Atoi(first); err == nil {
+		hi, err = strconv.Atoi(last)
+	}
+	if err != nil || lo < 1 || hi > 65535 || lo > hi {
+		return 0, 0, 0, fmt.Errorf("invalid ports of service %q", service)
+	}
+
+	return protocol, lo, hi, nil
+}
+
+// servicePort picks the protocol and port of a flow towards one of the services of hs.
+func servicePort(r *rand.Rand, hs *gaia.HostService) (protocol, port int) {
+
+	// NOTE: The services were validated with the configuration.
+	protocol, lo, hi, _ := parseService(hs.Services[r.Intn(len(hs.Services))])
+
+	return protocol, lo + r.Intn(hi-lo+1)
+}
+
+// puType returns cType if it is a valid gaia.ProcessingUnitTypeValue, else it returns a random
+// gaia.ProcessingUnitTypeValue
+func puType(r *rand.Rand, cType string) gaia.ProcessingUnitTypeValue {
+
+	for _, t := range PUTypes {
+		if gaia.ProcessingUnitTypeValue(cType) == t {
+			return t
+		}
+	}
+	return PUTypes[r.Intn(len(PUTypes))]
+}
+
+// randIP generates a valid random IP.
+func randIP(r *rand.Rand) string {
+
+	return fmt.Sprintf("%d.%d.%d.%d", r.Intn(250)+1, r.Intn(252), r.Intn(253), r.Intn(254))
+}
+
+// Generate does the plan generation, according to c.
+func Generate(c *Config, opts ...Option) (*PlanLayout, error) {
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+
+	o := newOptions(opts...)
+	if len(o.protocols) == 0 || len(o.serviceTypes) == 0 {
+		return nil, fmt.Errorf("no protocols or service types to pick from")
+	}
+
+	prefix := c.Name
+	if prefix == "" {
+		prefix = DefaultName
+	}
+
+	lifecycle, jitter := c.Lifecycle, c.Jitter
+	plan := Plan{
+		Lifecycle: &lifecycle,
+		Jitter:    &jitter,
+	}
+
+	// Generate PUs
+	plan.Nodes = make([]*Node, c.PUs)
+	for i := range plan.Nodes {
+		name := fmt.Sprintf("%s-%d", prefix, i+1)
+		plan.Nodes[i] = &Node{
+			ID:   fmt.Sprintf("%s-pu", name),
+			Type: gaia.ProcessingUnitIdentity.Name,
+			IP:   randIP(o.rand),
+		}
+
+		pu := gaia.NewProcessingUnit()
+		pu.Name = name
+		pu.Type = puType(o.rand, c.PUType)
+		if c.PUMeta == nil {
+			pu.Metadata = []string{
+				fmt.Sprintf("@sys:image=%s-image", name),
+				fmt.Sprintf("@usr:app=%s-app", name),
+				fmt.Sprintf("@usr:key=%s-key", name),
+			}
+		} else {
+			pu.Metadata = append([]string{}, c.PUMeta...)
+		}
+		// NOTE: These two are not necessary, as the simulator (currently) does not use these fields
+		// (i.e. all PUs are active and running, no matter what is specified here).
+		plan.Nodes[i].ProcessingUnit = pu
+	}
+	assignScenarios(o.rand, &lifecycle, plan.Nodes)
+
+	// Generate the PUs of the host services, after the scenarios which do not apply to them.
+	for _, hs := range c.HostServices {
+		name := fmt.Sprintf("%s-%d", prefix, len(plan.Nodes)+1)
+		pu := gaia.NewProcessingUnit()
+		pu.Name = name
+		pu.Type = gaia.ProcessingUnitTypeHostService
+		pu.Metadata = append([]string{"@usr:hostservice=" + hs.Name}, hs.Metadata...)
+		plan.Nodes = append(plan.Nodes, &Node{
+			ID:             fmt.Sprintf("%s-pu", name),
+			Type:           gaia.ProcessingUnitIdentity.Name,
+			IP:             randIP(o.rand),
+			ProcessingUnit: pu,
+			HostService:    hs.Object(),
+		})
+	}
+
+	// Generate flows
+	actions := []gaia.FlowReportActionValue{
+		gaia.FlowReportActionReject,
+		gaia.FlowReportActionAccept,
+	}
+
+	observedActions := []gaia.FlowReportObservedActionValue{
+		gaia.FlowReportObservedActionReject,
+		gaia.FlowReportObservedActionAccept,
+	}
+
+	for _, node := range plan.Nodes {
+		// NOTE: The host services only receive flows.
+		if node.HostService != nil {
+			node.Edges = &Edges{Flows: []*Flow{}}
+			continue
+		}
+		node.Edges = &Edges{
+			Flows: make([]*Flow, c.Flows),
+		}
+		for i := range node.Edges.Flows {
+			to := plan.Nodes[i%len(plan.Nodes)]
+			node.Edges.Flows[i] = &Flow{
+				To: to.ID,
+			}
+
+			fr := gaia.NewFlowReport()
+			fr.ServiceType = o.serviceTypes[o.rand.Intn(len(o.serviceTypes))]
+
+			axn := o.rand.Intn(len(actions))
+			fr.Action = actions[axn]
+			fr.ObservedAction = observedActions[axn]
+
+			fr.DestinationPort = 1025 + o.rand.Intn(64_000)
+			fr.Protocol = o.protocols[o.rand.Intn(len(o.protocols))]
+			if to.HostService != nil {
+				fr.Protocol, fr.DestinationPort = servicePort(o.rand, to.HostService)
+			}
+			if o.oracle != nil {
+				accept, err := o.oracle.Accept(node.ProcessingUnit, to.ProcessingUnit, fr.Protocol,
+					fr.DestinationPort)
+				if err != nil {
+					return nil, fmt.Errorf("verdict of flow %d of %s: %v", i, node.ID, err)
+				}
+				axn = 0
+				if accept {
+					axn = 1
+				}
+				fr.Action = actions[axn]
+				fr.ObservedAction = observedActions[axn]
+			}
+			node.Edges.Flows[i].Report = fr
+		}
+	}
+
+	return &PlanLayout{plan}, nil
+}
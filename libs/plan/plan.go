@@ -0,0 +1,106 @@
+// Package plan implements the generation, transformation and serialization of the plans run by the
+// enforcer simulators.
+package plan
+
+import (
+	"time"
+
+	"go.aporeto.io/gaia"
+)
+
+// A Config is the configuration for the plan generation parameters.
+type Config struct {
+	// Name is the prefix of all identifiers. Defaults to DefaultName.
+	Name string `yaml:"name"`
+	// PUs is the number of PUs per simulator.
+	PUs int `yaml:"pus"`
+	// PUType is the type of the PUs, either a gaia.ProcessingUnitTypeValue or "random".
+	PUType string `yaml:"pu-type"`
+	// PUMeta are the external tags for each PU, which must all start with "@". If nil, unique tags
+	// are generated for each PU.
+	PUMeta []string `yaml:"pu-meta,omitempty"`
+	// Flows is the number of flows per PU.
+	Flows int `yaml:"flows"`
+	// HostServices are the host services of the simulators, each generated as an extra PU of type
+	// HostService receiving flows on its services.
+	HostServices []*HostService `yaml:"host-services,omitempty"`
+	Lifecycle    Lifecycle      `yaml:"lifecycle"`
+	Jitter       Jitter         `yaml:"jitter"`
+}
+
+// A HostService is a host service of the simulators, e.g. the ssh daemon of the hosts.
+type HostService struct {
+	// Name is the name of the host service, tagged hostservice=<name>.
+	Name string `yaml:"name"`
+	// Services are the protocols and ports of the service, e.g. tcp/22 or udp/5000:5100.
+	Services []string `yaml:"services"`
+	// Metadata are extra external tags of the PU, which must all start with "@".
+	Metadata []string `yaml:"metadata,omitempty"`
+}
+
+// DefaultName is the identifiers prefix used when Config.Name is empty.
+const DefaultName = "auto-generate-plan"
+
+// A PlanLayout is the layout of the plan.
+type PlanLayout struct {
+	Plan Plan `yaml:"plan"`
+}
+
+// A Plan to simulate.
+type Plan struct {
+	Lifecycle *Lifecycle `yaml:"lifecycle"`
+	Jitter    *Jitter    `yaml:"jitter,omitempty"`
+	Nodes     []*Node    `yaml:"nodes"`
+}
+
+// A Lifecycle represents how we want the simulator to behave in terms of
+// iterations, interval, bursts, burst-sizes, etc.
+type Lifecycle struct {
+	// PUIterations can be "infinite".
+	PUIterations string        `yaml:"pu-iterations"`
+	PUInterval   time.Duration `yaml:"pu-interval,omitempty"`
+	PUCleanup    time.Duration `yaml:"pu-cleanup,omitempty"`
+	// FlowIterations can be "infinite".
+	FlowIterations string        `yaml:"flow-iterations"`
+	FlowInterval   time.Duration `yaml:"flow-interval,omitempty"`
+	DNSReportRate  string        `yaml:"dns-report-rate,omitempty"`
+	// Scenarios are run on top of the lifecycle, each on a ratio of the PUs.
+	Scenarios []*Scenario `yaml:"scenarios,omitempty"`
+}
+
+// A Jitter stores different delay parameters for lifecycle operations.
+// Jitters are simulated for node, PU & flow cycles.
+type Jitter struct {
+	Variance   string        `yaml:"variance,omitempty"`
+	PUStart    time.Duration `yaml:"pu-start,omitempty"`
+	PUReport   time.Duration `yaml:"pu-report,omitempty"`
+	FlowReport time.Duration `yaml:"flow-report,omitempty"`
+}
+
+// A Node represent a node, Pu or ExtNet.
+type Node struct {
+	ID              string                `yaml:"ID"`
+	Type            string                `yaml:"type"`
+	IP              string                `yaml:"IP"`
+	ExternalNetwork *gaia.ExternalNetwork `yaml:"externalNetwork"`
+	ProcessingUnit  *gaia.ProcessingUnit  `yaml:"processingUnit"`
+	// HostService is the host service of a PU of type HostService.
+	HostService *gaia.HostService `yaml:"hostService,omitempty"`
+	Edges       *Edges            `yaml:"edges,omitempty"`
+	// Scenarios are the types of the lifecycle scenarios applied to this PU.
+	Scenarios []ScenarioType `yaml:"scenarios,omitempty"`
+	// Mutations are the metadata set on the PU by tag mutation scenarios.
+	Mutations []string `yaml:"mutations,omitempty"`
+}
+
+// Edges represent the edges definition.
+type Edges struct {
+	Frequency string  `yaml:"frequency,omitempty"`
+	Flows     []*Flow `yaml:"flows"`
+}
+
+// A Flow represents a flow.
+type Flow struct {
+	Report *gaia.FlowReport `yaml:"report"`
+	To     string           `yaml:"to"`
+}
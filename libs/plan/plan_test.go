@@ -0,0 +1,735 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"go.aporeto.io/gaia"
+	"gopkg.in/yaml.v3"
+)
+
+func testConfig(pus, flows int) *Config {
+
+	return &Config{
+		Name:   "test",
+		PUs:    pus,
+		PUType: "random",
+		Flows:  flows,
+	}
+}
+
+func TestGenerate(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		config  *Config
+		wantErr bool
+	}{
+		{
+			name:   "PUs with flows",
+			config: testConfig(10, 5),
+		},
+		{
+			name:   "no PUs",
+			config: testConfig(0, 0),
+		},
+		{
+			name:    "flows without PUs",
+			config:  testConfig(0, 5),
+			wantErr: true,
+		},
+		{
+			name: "invalid metadata",
+			config: &Config{
+				PUs:    1,
+				PUMeta: []string{"simulated=true"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pl, err := Generate(tt.config, OptionSeed(1))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Generate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(pl.Plan.Nodes) != tt.config.PUs {
+				t.Errorf("got %d nodes, want %d", len(pl.Plan.Nodes), tt.config.PUs)
+			}
+			for _, node := range pl.Plan.Nodes {
+				if len(node.Edges.Flows) != tt.config.Flows {
+					t.Errorf("node %s has %d flows, want %d", node.ID, len(node.Edges.Flows),
+						tt.config.Flows)
+				}
+			}
+
+			// The same seed must generate the same plan.
+			again, err := Generate(tt.config, OptionSeed(1))
+			if err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+			if !bytes.Equal(mustMarshal(t, pl), mustMarshal(t, again)) {
+				t.Errorf("plans generated with the same seed differ")
+			}
+		})
+	}
+}
+
+// A tcpOracle accepts the tcp flows, and fails on the flows to the PU named fail.
+type tcpOracle struct {
+	fail string
+}
+
+func (o tcpOracle) Accept(src, dst *gaia.ProcessingUnit, protocol, port int) (bool, error) {
+
+	if dst.Name == o.fail {
+		return false, fmt.Errorf("PU %s not found", dst.Name)
+	}
+
+	return protocol == 6, nil
+}
+
+func TestOracle(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		oracle  tcpOracle
+		wantErr bool
+	}{
+		{
+			name: "actions of the oracle",
+		},
+		{
+			name:    "oracle error",
+			oracle:  tcpOracle{fail: "test-2"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pl, err := Generate(testConfig(4, 8), OptionSeed(1), OptionOracle(tt.oracle))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Generate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			accepted := 0
+			for _, node := range pl.Plan.Nodes {
+				for _, flow := range node.Edges.Flows {
+					fr := flow.Report
+					want := gaia.FlowReportActionReject
+					if fr.Protocol == 6 {
+						want = gaia.FlowReportActionAccept
+						accepted++
+					}
+					if fr.Action != want || string(fr.ObservedAction) != string(want) {
+						t.Errorf("flow of %s with protocol %d: got %s/%s, want %s", node.ID,
+							fr.Protocol, fr.Action, fr.ObservedAction, want)
+					}
+				}
+			}
+			if accepted == 0 {
+				t.Errorf("no tcp flow generated")
+			}
+		})
+	}
+}
+
+func TestHostServices(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		hostServices []*HostService
+		puType       string
+		wantErr      bool
+	}{
+		{
+			name:         "ssh and syslog",
+			hostServices: testHostServices(),
+		},
+		{
+			name:         "invalid service",
+			hostServices: []*HostService{{Name: "ssh", Services: []string{"sctp/22"}}},
+			wantErr:      true,
+		},
+		{
+			name:         "invalid port range",
+			hostServices: []*HostService{{Name: "ssh", Services: []string{"tcp/23:22"}}},
+			wantErr:      true,
+		},
+		{
+			name: "duplicate name",
+			hostServices: []*HostService{
+				{Name: "ssh", Services: []string{"tcp/22"}},
+				{Name: "ssh", Services: []string{"tcp/2222"}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "HostService PU type",
+			puType:  string(gaia.ProcessingUnitTypeHostService),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig(4, 8)
+			c.HostServices = tt.hostServices
+			if tt.puType != "" {
+				c.PUType = tt.puType
+			}
+			pl, err := Generate(c, OptionSeed(1))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Generate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got, want := len(pl.Plan.Nodes), c.PUs+len(tt.hostServices); got != want {
+				t.Fatalf("got %d nodes, want %d", got, want)
+			}
+			if got := len(HostServices(pl)); got != len(tt.hostServices) {
+				t.Errorf("got %d host services, want %d", got, len(tt.hostServices))
+			}
+
+			for _, node := range pl.Plan.Nodes {
+				pu := node.ProcessingUnit
+				if (pu.Type == gaia.ProcessingUnitTypeHostService) != (node.HostService != nil) {
+					t.Errorf("PU %s of type %s with host service %v", pu.Name, pu.Type,
+						node.HostService)
+				}
+				if node.HostService != nil && len(node.Edges.Flows) > 0 {
+					t.Errorf("host service PU %s has flows", pu.Name)
+				}
+			}
+			if checkServicePorts(t, pl) == 0 {
+				t.Errorf("no flow to the host services")
+			}
+		})
+	}
+}
+
+// checkServicePorts checks that the flows of pl towards the HostService PUs target one of the
+// services of testHostServices, and returns their number.
+func checkServicePorts(t *testing.T, pl *PlanLayout) int {
+
+	t.Helper()
+
+	index := nodeIndex(pl.Plan.Nodes)
+	received := 0
+	for _, node := range pl.Plan.Nodes {
+		for _, flow := range node.Edges.Flows {
+			hs := pl.Plan.Nodes[index[flow.To]].HostService
+			if hs == nil {
+				continue
+			}
+			received++
+			fr := flow.Report
+			service := fmt.Sprintf("%d/%d", fr.Protocol, fr.DestinationPort)
+			switch service {
+			case "6/22", "17/514", "6/6514", "6/6515":
+			default:
+				t.Errorf("flow from %s to %s on %s", node.ID, hs.Name, service)
+			}
+		}
+	}
+
+	return received
+}
+
+// testHostServices are the host services of the plans whose flows are checked by
+// checkServicePorts.
+func testHostServices() []*HostService {
+
+	return []*HostService{
+		{Name: "ssh", Services: []string{"tcp/22"}},
+		{Name: "syslog", Services: []string{"udp/514", "tcp/6514:6515"},
+			Metadata: []string{"@usr:log=true"}},
+	}
+}
+
+func TestTransformHostServices(t *testing.T) {
+
+	c := testConfig(10, 10)
+	c.HostServices = testHostServices()
+	pl, err := Generate(c, OptionSeed(1))
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		transform func() ([]*PlanLayout, error)
+	}{
+		{
+			name:      "split",
+			transform: func() ([]*PlanLayout, error) { return Split(pl, 3) },
+		},
+		{
+			name: "scale down",
+			transform: func() ([]*PlanLayout, error) {
+				scaled, err := Scale(pl, 0.5, 1)
+				return []*PlanLayout{scaled}, err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pls, err := tt.transform()
+			if err != nil {
+				t.Fatalf("transform error = %v", err)
+			}
+
+			// The flows retargeted to the host services are moved onto their services.
+			received := 0
+			for _, transformed := range pls {
+				received += checkServicePorts(t, transformed)
+			}
+			if received == 0 {
+				t.Errorf("no flow to the host services")
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+
+	pl, err := Generate(testConfig(10, 10), OptionSeed(1))
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	shards, err := Split(pl, 3)
+	if err != nil {
+		t.Fatalf("Split() error = %v", err)
+	}
+
+	nodes := 0
+	for i, shard := range shards {
+		nodes += len(shard.Plan.Nodes)
+		ids := nodeIndex(shard.Plan.Nodes)
+		for _, node := range shard.Plan.Nodes {
+			for _, flow := range node.Edges.Flows {
+				if _, ok := ids[flow.To]; !ok {
+					t.Errorf("shard %d: flow from %s to %s leaves the shard", i, node.ID, flow.To)
+				}
+			}
+		}
+	}
+	if nodes != len(pl.Plan.Nodes) {
+		t.Errorf("got %d nodes in shards, want %d", nodes, len(pl.Plan.Nodes))
+	}
+
+	if _, err := Split(pl, 11); err == nil {
+		t.Errorf("Split() in more shards than nodes should fail")
+	}
+}
+
+func TestScale(t *testing.T) {
+
+	pl, err := Generate(testConfig(4, 6), OptionSeed(1))
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	big, err := Generate(testConfig(8, 6), OptionSeed(1))
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	shards, err := Split(big, 2)
+	if err != nil {
+		t.Fatalf("Split() error = %v", err)
+	}
+	sparse := &PlanLayout{Plan: Plan{
+		Lifecycle: big.Plan.Lifecycle,
+		Jitter:    big.Plan.Jitter,
+		Nodes:     copyNodes([]*Node{big.Plan.Nodes[0], big.Plan.Nodes[2], big.Plan.Nodes[6]}),
+	}}
+
+	tests := []struct {
+		name       string
+		pl         *PlanLayout
+		puFactor   float64
+		flowFactor float64
+		wantPUs    int
+		wantFlows  int
+		// wantLast is the name of the last PU.
+		wantLast string
+	}{
+		{"scale up", pl, 2.5, 2, 10, 12, "test-10"},
+		{"scale down", pl, 0.5, 0.5, 2, 3, "test-3"},
+		{"never below one", pl, 0.01, 0.01, 1, 1, "test-1"},
+		{"split then scale up", shards[1], 1.5, 1, 6, 6, "test-10"},
+		{"scale up with gaps", sparse, 2, 1, 6, 6, "test-10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scaled, err := Scale(tt.pl, tt.puFactor, tt.flowFactor)
+			if err != nil {
+				t.Fatalf("Scale() error = %v", err)
+			}
+
+			if len(scaled.Plan.Nodes) != tt.wantPUs {
+				t.Errorf("got %d PUs, want %d", len(scaled.Plan.Nodes), tt.wantPUs)
+			}
+			ids := nodeIndex(scaled.Plan.Nodes)
+			if len(ids) != tt.wantPUs {
+				t.Errorf("got %d unique IDs, want %d", len(ids), tt.wantPUs)
+			}
+			names := map[string]bool{}
+			for _, node := range scaled.Plan.Nodes {
+				name := node.ProcessingUnit.Name
+				if names[name] || node.ID != name+"-pu" {
+					t.Errorf("node %s has a duplicate or mismatched PU name %s", node.ID, name)
+				}
+				names[name] = true
+				if len(node.Edges.Flows) != tt.wantFlows {
+					t.Errorf("node %s has %d flows, want %d", node.ID, len(node.Edges.Flows),
+						tt.wantFlows)
+				}
+				for _, flow := range node.Edges.Flows {
+					if _, ok := ids[flow.To]; !ok {
+						t.Errorf("flow from %s to unknown node %s", node.ID, flow.To)
+					}
+				}
+			}
+			last := scaled.Plan.Nodes[len(scaled.Plan.Nodes)-1].ProcessingUnit.Name
+			if last != tt.wantLast {
+				t.Errorf("got last PU %s, want %s", last, tt.wantLast)
+			}
+		})
+	}
+
+	if len(pl.Plan.Nodes) != 4 || len(shards[1].Plan.Nodes) != 4 {
+		t.Errorf("Scale() modified the original plans")
+	}
+}
+
+func TestRenameAndMerge(t *testing.T) {
+
+	pl, err := Generate(testConfig(3, 3), OptionSeed(1))
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if _, err := Merge(pl, pl); err == nil {
+		t.Errorf("Merge() of plans with the same IDs should fail")
+	}
+
+	renamed, err := Rename(pl, "", "other")
+	if err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if prefix, err := Prefix(renamed); err != nil || prefix != "other" {
+		t.Errorf("Prefix() = %q, %v, want %q", prefix, err, "other")
+	}
+	if renamed.Plan.Nodes[0].ID != "other-1-pu" {
+		t.Errorf("got ID %s, want %s", renamed.Plan.Nodes[0].ID, "other-1-pu")
+	}
+
+	merged, err := Merge(pl, renamed)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if len(merged.Plan.Nodes) != 6 {
+		t.Errorf("got %d merged nodes, want 6", len(merged.Plan.Nodes))
+	}
+}
+
+func TestCompact(t *testing.T) {
+
+	pl, err := Generate(testConfig(3, 3), OptionSeed(1))
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	full := mustMarshal(t, pl)
+	compact, err := Marshal(pl, OptionCompact(true))
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if len(compact) >= len(full) {
+		t.Errorf("compact plan (%d bytes) is not smaller than the full one (%d bytes)",
+			len(compact), len(full))
+	}
+
+	loaded, err := Unmarshal(compact)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !bytes.Equal(full, mustMarshal(t, loaded)) {
+		t.Errorf("compact plan does not decode to the original plan")
+	}
+}
+
+func mustMarshal(t *testing.T, pl *PlanLayout) []byte {
+
+	t.Helper()
+	data, err := Marshal(pl)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	return data
+}
+
+func TestScenarios(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		scenarios []*Scenario
+		wantErr   bool
+	}{
+		{
+			name: "long-lived and churning PUs",
+			scenarios: []*Scenario{
+				{Type: ScenarioCrash, Ratio: 0.5, Rate: 1},
+				{Type: ScenarioLongLived, Ratio: 0.6},
+				{Type: ScenarioTagMutation, Ratio: 1, Rate: 1},
+			},
+		},
+		{
+			name:      "unknown type",
+			scenarios: []*Scenario{{Type: "explode", Ratio: 1, Rate: 1}},
+			wantErr:   true,
+		},
+		{
+			name:      "missing rate",
+			scenarios: []*Scenario{{Type: ScenarioPauseResume, Ratio: 1}},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(10, 0)
+			config.Lifecycle.Scenarios = tt.scenarios
+			pl, err := Generate(config, OptionSeed(1))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Generate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			counts := map[ScenarioType]int{}
+			for _, node := range pl.Plan.Nodes {
+				longLived, churns, mutates := false, false, false
+				for _, s := range node.Scenarios {
+					counts[s]++
+					longLived = longLived || s == ScenarioLongLived
+					churns = churns || s.churns()
+					mutates = mutates || s == ScenarioTagMutation
+				}
+				if longLived && churns {
+					t.Errorf("long-lived node %s has churning scenarios %v", node.ID, node.Scenarios)
+				}
+				if mutates != (len(node.Mutations) > 0) {
+					t.Errorf("node %s has scenarios %v and mutations %v", node.ID, node.Scenarios,
+						node.Mutations)
+				}
+			}
+			if counts[ScenarioLongLived] != 6 || counts[ScenarioCrash] != 4 ||
+				counts[ScenarioTagMutation] != 10 {
+				t.Errorf("unexpected scenario counts %v", counts)
+			}
+		})
+	}
+}
+
+func TestSchema(t *testing.T) {
+
+	pl, err := Generate(testConfig(5, 5), OptionSeed(1))
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	compact, err := Marshal(pl, OptionCompact(true))
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	read := func(file string) []byte {
+		data, err := os.ReadFile(filepath.Join("../../utils/plan-gen", file))
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		data   []byte
+	}{
+		{name: "example configuration", target: "config", data: read("config.example.yaml")},
+		{name: "example plan", target: "plan", data: read("plan.example.yaml")},
+		{name: "example compact plan", target: "plan", data: read("plan.compact.example.yaml")},
+		{name: "generated plan", target: "plan", data: mustMarshal(t, pl)},
+		{name: "generated compact plan", target: "plan", data: compact},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Schema(tt.target)
+			if err != nil {
+				t.Fatalf("Schema() error = %v", err)
+			}
+			var root map[string]interface{}
+			if err := json.Unmarshal(data, &root); err != nil {
+				t.Fatalf("unmarshal schema: %v", err)
+			}
+			var doc interface{}
+			if err := yaml.Unmarshal(tt.data, &doc); err != nil {
+				t.Fatalf("unmarshal document: %v", err)
+			}
+			if err := validate(root, root, doc, ""); err != nil {
+				t.Errorf("document does not match the %s schema: %v", tt.target, err)
+			}
+		})
+	}
+
+	// The schema rejects unknown choices.
+	data, err := Schema("plan")
+	if err != nil {
+		t.Fatalf("Schema() error = %v", err)
+	}
+	var root map[string]interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		t.Fatalf("unmarshal schema: %v", err)
+	}
+	var doc interface{}
+	good := mustMarshal(t, pl)
+	bad := bytes.Replace(good, []byte("type: Docker"), []byte("type: Unknown"), 1)
+	if bytes.Equal(bad, good) {
+		t.Fatalf("generated plan without any Docker PU")
+	}
+	if err := yaml.Unmarshal(bad, &doc); err != nil {
+		t.Fatalf("unmarshal document: %v", err)
+	}
+	if err := validate(root, root, doc, ""); err == nil {
+		t.Errorf("plan with an unknown PU type matches the schema")
+	}
+}
+
+// validate checks the yaml document v at path against the JSON Schema s of the root schema,
+// supporting the keywords used by Schema.
+func validate(root, s map[string]interface{}, v interface{}, path string) error {
+
+	if ref, ok := s["$ref"].(string); ok {
+		name := ref[len("#/$defs/"):]
+		return validate(root, root["$defs"].(map[string]interface{})[name].(map[string]interface{}),
+			v, path)
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok {
+		var errs []error
+		for _, sub := range anyOf {
+			err := validate(root, sub.(map[string]interface{}), v, path)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err)
+		}
+		return fmt.Errorf("%s matches none of %v", path, errs)
+	}
+
+	if typ, ok := s["type"]; ok {
+		types, ok := typ.([]interface{})
+		if !ok {
+			types = []interface{}{typ}
+		}
+		matched := false
+		for _, typ := range types {
+			matched = matched || matchesType(typ.(string), v)
+		}
+		if !matched {
+			return fmt.Errorf("%s: %v is not of type %v", path, v, typ)
+		}
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == v
+		}
+		if !found {
+			return fmt.Errorf("%s: %v not in %v", path, v, enum)
+		}
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		if str, ok := v.(string); ok && !regexp.MustCompile(pattern).MatchString(str) {
+			return fmt.Errorf("%s: %q does not match %s", path, str, pattern)
+		}
+	}
+
+	switch v := v.(type) {
+	case []interface{}:
+		if items, ok := s["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := validate(root, items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		props, _ := s["properties"].(map[string]interface{})
+		for k, item := range v {
+			sub, ok := props[k].(map[string]interface{})
+			if !ok {
+				switch additional := s["additionalProperties"].(type) {
+				case bool:
+					if !additional {
+						return fmt.Errorf("%s: unknown property %s", path, k)
+					}
+					continue
+				case map[string]interface{}:
+					sub = additional
+				default:
+					continue
+				}
+			}
+			if err := validate(root, sub, item, path+"."+k); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchesType returns true if the yaml value v is of the JSON Schema type typ.
+func matchesType(typ string, v interface{}) bool {
+
+	switch v := v.(type) {
+	case nil:
+		return typ == "null"
+	case bool:
+		return typ == "boolean"
+	case int, int64, uint64:
+		return typ == "integer" || typ == "number"
+	case float64:
+		return typ == "number" || typ == "integer" && v == float64(int64(v))
+	case string, time.Time:
+		return typ == "string"
+	case []interface{}:
+		return typ == "array"
+	case map[string]interface{}:
+		return typ == "object"
+	}
+
+	return false
+}
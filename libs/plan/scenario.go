@@ -0,0 +1,133 @@
+package plan
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// A ScenarioType is a PU lifecycle scenario, run on top of the create, start, report, stop and
+// destroy lifecycle.
+type ScenarioType string
+
+const (
+	// ScenarioRestartStorm restarts the PU Burst times in a row, without waiting in between.
+	ScenarioRestartStorm ScenarioType = "restart-storm"
+	// ScenarioPauseResume pauses the PU for Pause, then resumes it.
+	ScenarioPauseResume ScenarioType = "pause-resume"
+	// ScenarioTagMutation replaces the metadata of the running PU with the node mutations, to
+	// exercise the dynamic tag synchronization.
+	ScenarioTagMutation ScenarioType = "tag-mutation"
+	// ScenarioCrash destroys the PU without stopping it first (i.e. unclean shutdown).
+	ScenarioCrash ScenarioType = "crash"
+	// ScenarioLongLived keeps the PU running for the whole simulation: it is created once and
+	// never stopped or destroyed, no matter the PU iterations and cleanup.
+	ScenarioLongLived ScenarioType = "long-lived"
+)
+
+// ScenarioTypes are all the supported scenario types.
+var ScenarioTypes = []ScenarioType{
+	ScenarioRestartStorm,
+	ScenarioPauseResume,
+	ScenarioTagMutation,
+	ScenarioCrash,
+	ScenarioLongLived,
+}
+
+// churns reports whether scenarios of type t stop the PU, which long-lived PUs never do.
+func (t ScenarioType) churns() bool {
+
+	return t == ScenarioRestartStorm || t == ScenarioPauseResume || t == ScenarioCrash
+}
+
+// A Scenario is a lifecycle scenario applied to a ratio of the PUs of each simulator.
+type Scenario struct {
+	Type ScenarioType `yaml:"type"`
+	// Ratio is the ratio of the PUs the scenario applies to, in [0, 1].
+	Ratio float64 `yaml:"ratio"`
+	// Rate is the number of occurrences per PU per minute. Ignored for long-lived PUs.
+	Rate float64 `yaml:"rate,omitempty"`
+	// Jitter is the maximum random delay added to each occurrence.
+	Jitter time.Duration `yaml:"jitter,omitempty"`
+	// Burst is the number of restarts of each restart storm. Defaults to 1.
+	Burst int `yaml:"burst,omitempty"`
+	// Pause is how long PUs are paused for a pause-resume. Defaults to the interval between
+	// occurrences.
+	Pause time.Duration `yaml:"pause,omitempty"`
+	// Tags are the metadata set on tag mutations. If empty, unique tags are generated for each PU.
+	Tags []string `yaml:"tags,omitempty"`
+}
+
+// Validate checks that the parameters of s are valid.
+func (s *Scenario) Validate() error {
+
+	known := false
+	for _, t := range ScenarioTypes {
+		known = known || s.Type == t
+	}
+	if !known {
+		return fmt.Errorf("unknown scenario type %q", s.Type)
+	}
+	if s.Ratio < 0 || s.Ratio > 1 {
+		return fmt.Errorf("%s: ratio %v is not in [0, 1]", s.Type, s.Ratio)
+	}
+	if s.Rate < 0 || s.Burst < 0 || s.Jitter < 0 || s.Pause < 0 {
+		return fmt.Errorf("%s: negative rate, burst, jitter or pause", s.Type)
+	}
+	if s.Type != ScenarioLongLived && s.Ratio > 0 && s.Rate == 0 {
+		return fmt.Errorf("%s: rate must be set", s.Type)
+	}
+
+	return nil
+}
+
+// assignScenarios assigns the scenarios of l to the PU nodes. Long-lived PUs are picked first, and
+// are never assigned scenarios which stop them.
+func assignScenarios(r *rand.Rand, l *Lifecycle, nodes []*Node) {
+
+	longLived := map[*Node]bool{}
+	assign := func(s *Scenario, candidates []*Node) {
+		count := int(math.Round(s.Ratio * float64(len(nodes))))
+		if count > len(candidates) {
+			count = len(candidates)
+		}
+		for _, i := range r.Perm(len(candidates))[:count] {
+			node := candidates[i]
+			node.Scenarios = append(node.Scenarios, s.Type)
+			if s.Type == ScenarioLongLived {
+				longLived[node] = true
+			}
+			if s.Type == ScenarioTagMutation {
+				node.Mutations = append([]string{}, s.Tags...)
+				if len(s.Tags) == 0 {
+					node.Mutations = []string{
+						fmt.Sprintf("@usr:mutation=%s-mutated", node.ProcessingUnit.Name),
+					}
+				}
+			}
+		}
+	}
+
+	for _, s := range l.Scenarios {
+		if s.Type == ScenarioLongLived {
+			assign(s, nodes)
+		}
+	}
+
+	for _, s := range l.Scenarios {
+		if s.Type == ScenarioLongLived {
+			continue
+		}
+		candidates := nodes
+		if s.Type.churns() {
+			candidates = nil
+			for _, node := range nodes {
+				if !longLived[node] {
+					candidates = append(candidates, node)
+				}
+			}
+		}
+		assign(s, candidates)
+	}
+}
@@ -0,0 +1,258 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.aporeto.io/elemental"
+)
+
+// schemaDraft is the JSON Schema dialect of the generated schemas.
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// durationPattern matches the time.Duration strings accepted by the yaml decoder.
+const durationPattern = `^-?([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$`
+
+// A schema is a JSON Schema document, or a subschema of one.
+type schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 interface{}        `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	AnyOf                []*schema          `json:"anyOf,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Defs                 map[string]*schema `json:"$defs,omitempty"`
+}
+
+// schemaTargets are the types a schema can be generated for, by name.
+var schemaTargets = map[string]struct {
+	title string
+	value interface{}
+}{
+	"config": {"plan-gen configuration", Config{}},
+	"plan":   {"simulator plan", PlanLayout{}},
+}
+
+// Schema generates the JSON Schema of the type registered as target in schemaTargets.
+// The schema is derived from the Go types at runtime, so it always matches the structs used to
+// parse configurations and plans. Enum values and descriptions of gaia attributes are taken from
+// the gaia specifications.
+func Schema(target string) ([]byte, error) {
+
+	t, ok := schemaTargets[target]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema %q, expected one of: config, plan", target)
+	}
+
+	g := &schemaGenerator{
+		defs: map[string]*schema{},
+		overrides: map[string]*schema{
+			"plan.Config.PUType": {
+				Type: "string",
+				Enum: append(puTypeChoices(), "random"),
+			},
+			"plan.Scenario.Type": {
+				Type: "string",
+				Enum: scenarioTypeChoices(),
+			},
+		},
+	}
+
+	root := g.typeSchema(reflect.TypeOf(t.value))
+	root.Schema = schemaDraft
+	root.Title = t.title
+	root.Defs = g.defs
+
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s schema: %v", target, err)
+	}
+
+	return data, nil
+}
+
+// A schemaGenerator generates JSON Schemas from Go types, following the field naming rules of
+// gopkg.in/yaml.v3.
+type schemaGenerator struct {
+	// defs are the schemas of the named struct types, referenced by name.
+	defs map[string]*schema
+	// overrides replace the schema of struct fields, keyed by <type>.<field>.
+	overrides map[string]*schema
+}
+
+var (
+	durationType             = reflect.TypeOf(time.Duration(0))
+	timeType                 = reflect.TypeOf(time.Time{})
+	attributeSpecifiableType = reflect.TypeOf((*elemental.AttributeSpecifiable)(nil)).Elem()
+)
+
+// typeSchema returns the schema of t. Named struct types are added to the definitions and
+// referenced.
+func (g *schemaGenerator) typeSchema(t reflect.Type) *schema {
+
+	switch t {
+	case durationType:
+		return &schema{
+			AnyOf: []*schema{
+				{Type: "string", Pattern: durationPattern},
+				{Type: "integer"},
+			},
+		}
+	case timeType:
+		return &schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return nullable(g.typeSchema(t.Elem()))
+	case reflect.Slice, reflect.Array:
+		return nullable(&schema{Type: "array", Items: g.typeSchema(t.Elem())})
+	case reflect.Map:
+		return nullable(&schema{Type: "object", AdditionalProperties: g.typeSchema(t.Elem())})
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := t.String()
+		if _, ok := g.defs[name]; !ok {
+			// NOTE: Register the name before recursing, to support recursive types.
+			g.defs[name] = nil
+			g.defs[name] = g.structSchema(t)
+		}
+		return &schema{Ref: "#/$defs/" + name}
+	case reflect.String:
+		// NOTE: The yaml decoder accepts any scalar for a string, e.g. pu-iterations: 1.
+		return &schema{Type: []string{"string", "number", "boolean"}}
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	default:
+		// Interfaces accept any value.
+		return &schema{}
+	}
+}
+
+// structSchema returns the schema of struct type t.
+func (g *schemaGenerator) structSchema(t reflect.Type) *schema {
+
+	s := &schema{
+		Type:                 "object",
+		Properties:           map[string]*schema{},
+		AdditionalProperties: false,
+	}
+
+	specs := attributeSpecs(t)
+	for i := 0; i < t.NumField(); i++ {
+
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name, inline := yamlFieldName(f)
+		if name == "-" {
+			continue
+		}
+		if inline {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			for k, v := range g.structSchema(ft).Properties {
+				s.Properties[k] = v
+			}
+			continue
+		}
+
+		fs, ok := g.overrides[t.String()+"."+f.Name]
+		if !ok {
+			fs = g.typeSchema(f.Type)
+		}
+		if spec, ok := specs[f.Name]; ok {
+			fs.Description = spec.Description
+			if len(spec.AllowedChoices) > 0 {
+				// NOTE: The unset attributes are written as "", or null once edited.
+				fs = nullable(&schema{
+					Type: "string",
+					Enum: append([]string{""}, spec.AllowedChoices...),
+				})
+				fs.Description = spec.Description
+			}
+		}
+		s.Properties[name] = fs
+	}
+
+	return s
+}
+
+// attributeSpecs returns the elemental attribute specifications of t by Go field name, if t is a
+// gaia model.
+func attributeSpecs(t reflect.Type) map[string]elemental.AttributeSpecification {
+
+	if !reflect.PointerTo(t).Implements(attributeSpecifiableType) {
+		return nil
+	}
+
+	specs := map[string]elemental.AttributeSpecification{}
+	as := reflect.New(t).Interface().(elemental.AttributeSpecifiable)
+	for _, spec := range as.AttributeSpecifications() {
+		specs[spec.ConvertedName] = spec
+	}
+
+	return specs
+}
+
+// yamlFieldName returns the key of f in yaml documents, and whether f is inlined.
+func yamlFieldName(f reflect.StructField) (string, bool) {
+
+	tag := f.Tag.Get("yaml")
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = strings.ToLower(f.Name)
+	}
+
+	return name, strings.Contains(","+opts+",", ",inline,")
+}
+
+// nullable returns a schema accepting either what s accepts or null.
+func nullable(s *schema) *schema {
+
+	return &schema{
+		AnyOf: []*schema{s, {Type: "null"}},
+	}
+}
+
+// puTypeChoices returns the PU types that can be set in a configuration.
+func puTypeChoices() []string {
+
+	var choices []string
+	for _, t := range PUTypes {
+		choices = append(choices, string(t))
+	}
+
+	return choices
+}
+
+// scenarioTypeChoices returns the supported lifecycle scenario types.
+func scenarioTypeChoices() []string {
+
+	var choices []string
+	for _, t := range ScenarioTypes {
+		choices = append(choices, string(t))
+	}
+
+	return choices
+}
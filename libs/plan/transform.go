@@ -0,0 +1,373 @@
+package plan
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"go.aporeto.io/gaia"
+)
+
+// Merge combines the nodes of all plans in pls into a single plan. The lifecycle and jitter
+// of the first plan are kept. Node IDs must be unique across all plans (see Rename).
+func Merge(pls ...*PlanLayout) (*PlanLayout, error) {
+
+	if len(pls) == 0 {
+		return nil, fmt.Errorf("no plans to merge")
+	}
+
+	merged := &PlanLayout{
+		Plan: Plan{
+			Lifecycle: pls[0].Plan.Lifecycle,
+			Jitter:    pls[0].Plan.Jitter,
+		},
+	}
+
+	ids := map[string]int{}
+	for i, pl := range pls {
+		for _, node := range pl.Plan.Nodes {
+			if j, ok := ids[node.ID]; ok {
+				return nil, fmt.Errorf("node %q of plan %d already defined in plan %d", node.ID, i, j)
+			}
+			ids[node.ID] = i
+			merged.Plan.Nodes = append(merged.Plan.Nodes, node)
+		}
+	}
+
+	return merged, nil
+}
+
+// transformSeed seeds the picks of the ports of the flows retargeted by the transforms, which
+// are reproducible.
+const transformSeed = 1
+
+// Split divides pl into n plans with balanced node sets. Flows are kept inside each shard:
+// a flow towards a node of another shard is retargeted to a node of its own shard.
+func Split(pl *PlanLayout, n int) ([]*PlanLayout, error) {
+
+	nodes := pl.Plan.Nodes
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid number of shards %d", n)
+	}
+	if n > len(nodes) {
+		return nil, fmt.Errorf("cannot split %d nodes in %d shards", len(nodes), n)
+	}
+
+	r := rand.New(rand.NewSource(transformSeed))
+	index := nodeIndex(nodes)
+	shards := make([]*PlanLayout, n)
+	for i := range shards {
+
+		// NOTE: Shard sizes differ by at most one node.
+		start, end := i*len(nodes)/n, (i+1)*len(nodes)/n
+
+		shard := copyNodes(nodes[start:end])
+		inShard := nodeIndex(shard)
+		for _, node := range shard {
+			if node.Edges == nil {
+				continue
+			}
+			for _, flow := range node.Edges.Flows {
+				if _, ok := inShard[flow.To]; ok {
+					continue
+				}
+				retarget(r, flow, shard[index[flow.To]%len(shard)])
+			}
+		}
+
+		shards[i] = &PlanLayout{
+			Plan: Plan{
+				Lifecycle: pl.Plan.Lifecycle,
+				Jitter:    pl.Plan.Jitter,
+				Nodes:     shard,
+			},
+		}
+	}
+
+	return shards, nil
+}
+
+// Scale multiplies the number of PUs of pl by puFactor and the number of flows of each PU by
+// flowFactor. New PUs and flows are cloned round-robin from the existing ones and removed ones are
+// picked at even intervals, so that the distributions of PU types, metadata, service types,
+// actions and protocols are kept. The new PUs are numbered after the highest index of pl, which
+// may be a shard (see Split) or have gaps.
+func Scale(pl *PlanLayout, puFactor, flowFactor float64) (*PlanLayout, error) {
+
+	if puFactor <= 0 || flowFactor <= 0 {
+		return nil, fmt.Errorf("scale factors must be positive, got pus=%v flows=%v",
+			puFactor, flowFactor)
+	}
+
+	nodes := copyNodes(pl.Plan.Nodes)
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("plan has no nodes")
+	}
+
+	prefix, err := Prefix(pl)
+	if err != nil {
+		return nil, fmt.Errorf("scale PUs: %v", err)
+	}
+
+	last := lastIndex(nodes)
+	scaled := resize(nodes, scaledCount(len(nodes), puFactor), func(i int, node *Node) *Node {
+		clone := copyNodes([]*Node{node})[0]
+		renameNode(clone, nodeName(node), fmt.Sprintf("%s-%d", prefix, last+i-len(nodes)+1))
+		return clone
+	})
+
+	r := rand.New(rand.NewSource(transformSeed))
+	index := nodeIndex(nodes)
+	kept := nodeIndex(scaled)
+	for _, node := range scaled {
+		if node.Edges == nil {
+			continue
+		}
+		node.Edges.Flows = resize(node.Edges.Flows, scaledCount(len(node.Edges.Flows), flowFactor),
+			func(_ int, flow *Flow) *Flow {
+				return copyFlow(flow)
+			})
+		// Retarget the flows towards removed nodes.
+		for _, flow := range node.Edges.Flows {
+			if _, ok := kept[flow.To]; !ok {
+				retarget(r, flow, scaled[index[flow.To]%len(scaled)])
+			}
+		}
+	}
+
+	return &PlanLayout{
+		Plan: Plan{
+			Lifecycle: pl.Plan.Lifecycle,
+			Jitter:    pl.Plan.Jitter,
+			Nodes:     scaled,
+		},
+	}, nil
+}
+
+// Rename rewrites the ID and name prefix of all nodes of pl from "from" to "to". If from is
+// empty, the prefix is inferred from the plan (see Prefix).
+func Rename(pl *PlanLayout, from, to string) (*PlanLayout, error) {
+
+	if to == "" {
+		return nil, fmt.Errorf("empty target prefix")
+	}
+
+	if from == "" {
+		var err error
+		if from, err = Prefix(pl); err != nil {
+			return nil, fmt.Errorf("infer prefix: %v", err)
+		}
+	}
+
+	nodes := copyNodes(pl.Plan.Nodes)
+	for _, node := range nodes {
+		renameNode(node, from, to)
+	}
+
+	return &PlanLayout{
+		Plan: Plan{
+			Lifecycle: pl.Plan.Lifecycle,
+			Jitter:    pl.Plan.Jitter,
+			Nodes:     nodes,
+		},
+	}, nil
+}
+
+// Prefix returns the name prefix shared by all PUs of pl (i.e. the Config.Name used to generate
+// it). Generated PUs are named <prefix>-<index>.
+func Prefix(pl *PlanLayout) (string, error) {
+
+	prefix := ""
+	for _, node := range pl.Plan.Nodes {
+		if node.ProcessingUnit == nil {
+			continue
+		}
+		name := node.ProcessingUnit.Name
+		i := strings.LastIndex(name, "-")
+		if i < 1 {
+			return "", fmt.Errorf("PU %q is not named <prefix>-<index>", name)
+		}
+		if _, err := strconv.Atoi(name[i+1:]); err != nil {
+			return "", fmt.Errorf("PU %q is not named <prefix>-<index>", name)
+		}
+		if prefix != "" && prefix != name[:i] {
+			return "", fmt.Errorf("PUs with different prefixes %q and %q", prefix, name[:i])
+		}
+		prefix = name[:i]
+	}
+
+	if prefix == "" {
+		return "", fmt.Errorf("plan has no PUs")
+	}
+
+	return prefix, nil
+}
+
+// HostServices returns the host services of the HostService PUs of pl, once per name.
+func HostServices(pl *PlanLayout) []*gaia.HostService {
+
+	var hss []*gaia.HostService
+	seen := map[string]bool{}
+	for _, node := range pl.Plan.Nodes {
+		if hs := node.HostService; hs != nil && !seen[hs.Name] {
+			seen[hs.Name] = true
+			hss = append(hss, hs)
+		}
+	}
+
+	return hss
+}
+
+// nodeName returns the name from which the ID, metadata and flow targets of node derive: the name
+// of its PU, or its ID.
+func nodeName(node *Node) string {
+
+	if node.ProcessingUnit != nil {
+		return node.ProcessingUnit.Name
+	}
+
+	return node.ID
+}
+
+// lastIndex returns the highest index of the PUs of nodes, named <prefix>-<index> (see Prefix).
+func lastIndex(nodes []*Node) int {
+
+	last := 0
+	for _, node := range nodes {
+		if node.ProcessingUnit == nil {
+			continue
+		}
+		name := node.ProcessingUnit.Name
+		if i, err := strconv.Atoi(name[strings.LastIndex(name, "-")+1:]); err == nil && i > last {
+			last = i
+		}
+	}
+
+	return last
+}
+
+// renameNode replaces the prefix from with to in the ID, names, metadata and mutation values and
+// flow targets of node.
+func renameNode(node *Node, from, to string) {
+
+	rename := func(s string) string {
+		if s == from || strings.HasPrefix(s, from+"-") {
+			return to + s[len(from):]
+		}
+		return s
+	}
+
+	node.ID = rename(node.ID)
+	if pu := node.ProcessingUnit; pu != nil {
+		pu.Name = rename(pu.Name)
+		renameTags(pu.Metadata, rename)
+	}
+	renameTags(node.Mutations, rename)
+	if en := node.ExternalNetwork; en != nil {
+		en.Name = rename(en.Name)
+	}
+	if node.Edges != nil {
+		for _, flow := range node.Edges.Flows {
+			flow.To = rename(flow.To)
+		}
+	}
+}
+
+// renameTags applies rename to the values of tags, in place.
+func renameTags(tags []string, rename func(string) string) {
+
+	for i, tag := range tags {
+		if k, v, ok := strings.Cut(tag, "="); ok {
+			tags[i] = k + "=" + rename(v)
+		}
+	}
+}
+
+// nodeIndex maps the ID of each node in nodes to its index.
+func nodeIndex(nodes []*Node) map[string]int {
+
+	index := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		index[node.ID] = i
+	}
+
+	return index
+}
+
+// retarget points flow to node, towards one of its services if it is a HostService PU, as
+// generated.
+func retarget(r *rand.Rand, flow *Flow, node *Node) {
+
+	flow.To = node.ID
+	if node.HostService != nil && flow.Report != nil {
+		flow.Report.Protocol, flow.Report.DestinationPort = servicePort(r, node.HostService)
+	}
+}
+
+// copyNodes returns a deep copy of nodes.
+func copyNodes(nodes []*Node) []*Node {
+
+	copies := make([]*Node, len(nodes))
+	for i, node := range nodes {
+		c := *node
+		c.Scenarios = append([]ScenarioType(nil), node.Scenarios...)
+		c.Mutations = append([]string(nil), node.Mutations...)
+		c.ProcessingUnit = node.ProcessingUnit.DeepCopy()
+		c.ExternalNetwork = node.ExternalNetwork.DeepCopy()
+		c.HostService = node.HostService.DeepCopy()
+		if node.Edges != nil {
+			edges := *node.Edges
+			edges.Flows = make([]*Flow, len(node.Edges.Flows))
+			for j, flow := range node.Edges.Flows {
+				edges.Flows[j] = copyFlow(flow)
+			}
+			c.Edges = &edges
+		}
+		copies[i] = &c
+	}
+
+	return copies
+}
+
+// copyFlow returns a deep copy of flow.
+func copyFlow(flow *Flow) *Flow {
+
+	return &Flow{
+		Report: flow.Report.DeepCopy(),
+		To:     flow.To,
+	}
+}
+
+// scaledCount returns n scaled by factor, never going below 1 for a non empty set.
+func scaledCount(n int, factor float64) int {
+
+	count := int(math.Round(float64(n) * factor))
+	if n > 0 && count < 1 {
+		return 1
+	}
+
+	return count
+}
+
+// resize returns count elements of items. If count is lower than len(items), the elements are
+// picked at even intervals. Otherwise, the missing elements are created by calling clone on the
+// items round-robin, with i being the index of the new element.
+func resize[T any](items []T, count int, clone func(i int, item T) T) []T {
+
+	resized := make([]T, 0, count)
+	if count < len(items) {
+		for i := 0; i < count; i++ {
+			resized = append(resized, items[i*len(items)/count])
+		}
+		return resized
+	}
+
+	resized = append(resized, items...)
+	for i := len(items); i < count; i++ {
+		resized = append(resized, clone(i, items[i%len(items)]))
+	}
+
+	return resized
+}
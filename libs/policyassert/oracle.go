@@ -0,0 +1,166 @@
+package policyassert
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.aporeto.io/gaia"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A NamespacedPolicy is a policy of a policy set and the namespace it is created in, as written by
+// policy-gen.
+type NamespacedPolicy struct {
+	Namespace string                     `yaml:"namespace"`
+	Policy    *gaia.NetworkRuleSetPolicy `yaml:"policy"`
+}
+
+// LoadPolicies loads the policy set stored in file, e.g. the output of policy-gen.
+func LoadPolicies(file string) ([]*NamespacedPolicy, error) {
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", file, err)
+	}
+
+	var policies []*NamespacedPolicy
+	if err := yaml.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %v", file, err)
+	}
+	for i, p := range policies {
+		if p.Policy == nil || p.Namespace == "" {
+			return nil, fmt.Errorf("policy %d of %s has no policy or namespace", i, file)
+		}
+	}
+
+	return policies, nil
+}
+
+// Applicable returns the policies applying to a PU of ns with tags: the policies of ns and the
+// propagated policies of its ancestors, whose subject matches the tags.
+func Applicable(policies []*NamespacedPolicy, ns string,
+	tags []string) []*gaia.NetworkRuleSetPolicy {
+
+	var applicable []*gaia.NetworkRuleSetPolicy
+	for _, p := range policies {
+		inherited := p.Policy.Propagate &&
+			strings.HasPrefix(ns, strings.TrimSuffix(p.Namespace, "/")+"/")
+		if (p.Namespace == ns || inherited) && Match(p.Policy.Subject, tags) {
+			applicable = append(applicable, p.Policy)
+		}
+	}
+
+	return applicable
+}
+
+// An Oracle computes the verdict of the policies for the flows between PUs of a namespace. The
+// verdicts agree with the policies actually deployed, unlike the random actions of the generated
+// plans.
+type Oracle struct {
+	// Namespace is the namespace of the PUs.
+	Namespace string
+
+	policies func(pu *gaia.ProcessingUnit, tags []string) ([]*gaia.NetworkRuleSetPolicy, error)
+	// cache are the policies applying to every PU, by name.
+	cache map[string][]*gaia.NetworkRuleSetPolicy
+}
+
+// NewOracle returns an Oracle evaluating the policies of a policy set, e.g. loaded with
+// LoadPolicies, for the PUs of ns.
+func NewOracle(ns string, policies []*NamespacedPolicy) *Oracle {
+
+	return &Oracle{
+		Namespace: ns,
+		policies: func(_ *gaia.ProcessingUnit, tags []string) ([]*gaia.NetworkRuleSetPolicy,
+			error) {
+			return Applicable(policies, ns, tags), nil
+		},
+		cache: map[string][]*gaia.NetworkRuleSetPolicy{},
+	}
+}
+
+// NewRenderOracle returns an Oracle evaluating the policies rendered by r for the PUs of ns.
+func NewRenderOracle(r Renderer, ns string) *Oracle {
+
+	return &Oracle{
+		Namespace: ns,
+		policies: func(pu *gaia.ProcessingUnit, tags []string) ([]*gaia.NetworkRuleSetPolicy,
+			error) {
+			rp, err := r.RenderPolicies(pu.Name, ns, tags, pu.Type)
+			if err != nil {
+				return nil, err
+			}
+			return rp.RuleSetPolicies, nil
+		},
+		cache: map[string][]*gaia.NetworkRuleSetPolicy{},
+	}
+}
+
+// Accept returns true if the outgoing rules of src and the incoming rules of dst both allow a
+// flow with the protocol number protocol on port. It implements plan.Oracle.
+func (o *Oracle) Accept(src, dst *gaia.ProcessingUnit, protocol, port int) (bool, error) {
+
+	if src == nil || dst == nil {
+		return false, fmt.Errorf("flow without PU")
+	}
+
+	srcPolicies, err := o.applicable(src)
+	if err != nil {
+		return false, err
+	}
+	dstPolicies, err := o.applicable(dst)
+	if err != nil {
+		return false, err
+	}
+
+	c := &Case{Direction: Outgoing, Protocol: protocolName(protocol), Port: port,
+		Peer: o.tags(dst)}
+	if v, _ := Evaluate(srcPolicies, c); v != Allow {
+		return false, nil
+	}
+	c.Direction, c.Peer = Incoming, o.tags(src)
+	v, _ := Evaluate(dstPolicies, c)
+
+	return v == Allow, nil
+}
+
+// applicable returns the policies applying to pu, cached by name.
+func (o *Oracle) applicable(pu *gaia.ProcessingUnit) ([]*gaia.NetworkRuleSetPolicy, error) {
+
+	if policies, ok := o.cache[pu.Name]; ok {
+		return policies, nil
+	}
+
+	policies, err := o.policies(pu, o.tags(pu))
+	if err != nil {
+		return nil, fmt.Errorf("policies of PU %s: %v", pu.Name, err)
+	}
+	o.cache[pu.Name] = policies
+
+	return policies, nil
+}
+
+// tags returns the tags of pu: its metadata and associated tags, and the tags added by a backend.
+func (o *Oracle) tags(pu *gaia.ProcessingUnit) []string {
+
+	tags := append(append([]string{}, pu.Metadata...), pu.AssociatedTags...)
+	return puTags(o.Namespace, pu.Type, tags)
+}
+
+// protocolName returns the name of the protocol number protocol used by the rules, e.g. tcp.
+func protocolName(protocol int) string {
+
+	switch protocol {
+	case 1:
+		return "icmp"
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	default:
+		return strconv.Itoa(protocol)
+	}
+}
@@ -0,0 +1,313 @@
+// Package policyassert checks the policies rendered by a backend for PUs against expected
+// outcomes: whether the traffic of a PU with peers of given tags, on given protocols and ports, is
+// allowed or rejected. The expected outcomes are written in a YAML test table, which verifies that
+// a namespace setup or a generated policy set behaves as intended before launching simulators.
+package policyassert
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"go.aporeto.io/gaia"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A Direction is the direction of the traffic of a PU.
+type Direction string
+
+const (
+	// Incoming is the traffic from the peer to the PU, matched by the incoming rules.
+	Incoming Direction = "incoming"
+	// Outgoing is the traffic from the PU to the peer, matched by the outgoing rules.
+	Outgoing Direction = "outgoing"
+)
+
+// A Verdict is the outcome of the policies for a traffic.
+type Verdict string
+
+const (
+	// Allow is the verdict of a traffic matched by allow rules only.
+	Allow Verdict = "allow"
+	// Reject is the verdict of a traffic matched by a reject rule, or by no rule.
+	Reject Verdict = "reject"
+)
+
+// A Case is a traffic of a PU and its expected verdict.
+type Case struct {
+	Name      string    `yaml:"name"`
+	Direction Direction `yaml:"direction"`
+	// Protocol is the protocol of the traffic, e.g. tcp, udp or icmp.
+	Protocol string `yaml:"protocol"`
+	// Port is the port of the traffic, or the type of an icmp traffic.
+	Port int `yaml:"port"`
+	// Peer are the tags of the peer, e.g. $identity=processingunit and its user tags, or
+	// $identity=externalnetwork and the tags of an external network.
+	Peer   []string `yaml:"peer"`
+	Expect Verdict  `yaml:"expect"`
+}
+
+// A PU is the processing unit whose policies are rendered.
+type PU struct {
+	Name string `yaml:"name"`
+	// Namespace is the namespace of the PU, relative to the base namespace of the check unless
+	// absolute.
+	Namespace string `yaml:"namespace"`
+	// Tags are the user tags of the PU.
+	Tags []string                     `yaml:"tags"`
+	Type gaia.ProcessingUnitTypeValue `yaml:"type"`
+}
+
+// A Test is the cases of a PU.
+type Test struct {
+	Name  string  `yaml:"name"`
+	PU    PU      `yaml:"pu"`
+	Cases []*Case `yaml:"cases"`
+}
+
+// A Table is a test table of rendered policy assertions.
+type Table struct {
+	Tests []*Test `yaml:"tests"`
+}
+
+// LoadTable loads and validates the test table stored in file.
+func LoadTable(file string) (*Table, error) {
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", file, err)
+	}
+
+	var t Table
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %v", file, err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid test table %s: %v", file, err)
+	}
+
+	return &t, nil
+}
+
+// Validate checks that the tests of t are valid, defaulting the PU types to Docker.
+func (t *Table) Validate() error {
+
+	if len(t.Tests) == 0 {
+		return fmt.Errorf("no tests")
+	}
+	for _, test := range t.Tests {
+		if test.PU.Name == "" {
+			return fmt.Errorf("test %q: no PU name", test.Name)
+		}
+		if test.PU.Type == "" {
+			test.PU.Type = gaia.ProcessingUnitTypeDocker
+		}
+		for _, c := range test.Cases {
+			if c.Direction != Incoming && c.Direction != Outgoing {
+				return fmt.Errorf("test %q, case %q: unknown direction %q", test.Name, c.Name,
+					c.Direction)
+			}
+			if c.Expect != Allow && c.Expect != Reject {
+				return fmt.Errorf("test %q, case %q: unknown verdict %q", test.Name, c.Name,
+					c.Expect)
+			}
+			if c.Protocol == "" || c.Port < 0 {
+				return fmt.Errorf("test %q, case %q: invalid protocol or port", test.Name, c.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Evaluate returns the verdict of the policies for the traffic of c, and the name of the rule
+// deciding it, if any. The policies are the ones applying to the PU. As in the backend, a reject
+// rule takes precedence over the allow rules, and the traffic matched by no rule is rejected. The
+// rules in observation mode are not enforced, so they are ignored.
+func Evaluate(policies []*gaia.NetworkRuleSetPolicy, c *Case) (Verdict, string) {
+
+	allowed := ""
+	for _, np := range policies {
+		if np.Disabled {
+			continue
+		}
+		rules := np.IncomingRules
+		if c.Direction == Outgoing {
+			rules = np.OutgoingRules
+		}
+		for _, r := range rules {
+			if r.ObservationEnabled || !Match(r.Object, c.Peer) || !matchPorts(r.ProtocolPorts, c) {
+				continue
+			}
+			if r.Action == gaia.NetworkRuleActionReject {
+				return Reject, r.Name
+			}
+			if allowed == "" {
+				allowed = r.Name
+			}
+		}
+	}
+
+	if allowed != "" {
+		return Allow, allowed
+	}
+
+	return Reject, ""
+}
+
+// Match returns true if tags satisfy one of the clauses, i.e. contain all its tags.
+func Match(clauses [][]string, tags []string) bool {
+
+	set := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		set[tag] = true
+	}
+
+	for _, clause := range clauses {
+		matched := len(clause) > 0
+		for _, tag := range clause {
+			if !set[tag] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPorts returns true if one of the protocol ports, e.g. any, udp, tcp/443, tcp/1000:2000 or
+// icmp/8, matches the protocol and port of c.
+func matchPorts(protocolPorts []string, c *Case) bool {
+
+	for _, pp := range protocolPorts {
+		proto, ports, ranged := strings.Cut(strings.ToLower(pp), "/")
+		if proto == "any" {
+			return true
+		}
+		if proto != strings.ToLower(c.Protocol) {
+			continue
+		}
+		if !ranged {
+			return true
+		}
+		// The code of an icmp type is not asserted.
+		ports, _, _ = strings.Cut(ports, "/")
+		lo, hi, isRange := strings.Cut(ports, ":")
+		if !isRange {
+			hi = lo
+		}
+		from, err1 := strconv.Atoi(lo)
+		to, err2 := strconv.Atoi(hi)
+		if err1 == nil && err2 == nil && c.Port >= from && c.Port <= to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A Renderer renders the policies applying to a PU, e.g. a *testsetup.Client.
+type Renderer interface {
+	RenderPolicies(name, ns string, tags []string,
+		puType gaia.ProcessingUnitTypeValue) (*gaia.RenderedPolicy, error)
+}
+
+// A Result is the outcome of a case.
+type Result struct {
+	Test string
+	Case *Case
+	// Got is the verdict of the rendered policies.
+	Got Verdict
+	// Rule is the name of the rule deciding the verdict, if any.
+	Rule string
+}
+
+// Passed returns true if the verdict of r is the expected one.
+func (r *Result) Passed() bool {
+
+	return r.Got == r.Case.Expect
+}
+
+// Check renders the policies of the PU of every test of t, in its namespace under base, and
+// returns the results of its cases. The PUs are given the tags a backend adds to the PUs, i.e.
+// their identity, namespace and type.
+func Check(r Renderer, base string, t *Table) ([]*Result, error) {
+
+	var results []*Result
+	for _, test := range t.Tests {
+		ns := test.PU.Namespace
+		if !path.IsAbs(ns) {
+			ns = path.Join(base, ns)
+		}
+		tags := puTags(ns, test.PU.Type, test.PU.Tags)
+
+		rp, err := r.RenderPolicies(test.PU.Name, ns, tags, test.PU.Type)
+		if err != nil {
+			return nil, fmt.Errorf("test %q: %v", test.Name, err)
+		}
+
+		for _, c := range test.Cases {
+			got, rule := Evaluate(rp.RuleSetPolicies, c)
+			results = append(results, &Result{Test: test.Name, Case: c, Got: got, Rule: rule})
+		}
+	}
+
+	return results, nil
+}
+
+// puTags returns tags with the tags a backend adds to the PUs of type puType in ns.
+func puTags(ns string, puType gaia.ProcessingUnitTypeValue, tags []string) []string {
+
+	return append([]string{
+		"$identity=processingunit",
+		"$namespace=" + ns,
+		"$type=" + string(puType),
+	}, tags...)
+}
+
+// Failed returns the number of failed results.
+func Failed(results []*Result) int {
+
+	failed := 0
+	for _, r := range results {
+		if !r.Passed() {
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// Summary returns a human readable summary of results.
+func Summary(results []*Result) string {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%d cases: %d passed, %d failed\n", len(results),
+		len(results)-Failed(results), Failed(results))
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TEST\tCASE\tTRAFFIC\tEXPECTED\tGOT\tRULE\tRESULT")
+	for _, r := range results {
+		res := "ok"
+		if !r.Passed() {
+			res = "FAIL"
+		}
+		rule := r.Rule
+		if rule == "" {
+			rule = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s %s/%d\t%s\t%s\t%s\t%s\n", r.Test, r.Case.Name,
+			r.Case.Direction, r.Case.Protocol, r.Case.Port, r.Case.Expect, r.Got, rule, res)
+	}
+	w.Flush()
+
+	return b.String()
+}
@@ -0,0 +1,279 @@
+package policyassert
+
+import (
+	"fmt"
+	"testing"
+
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/simulator-test-harness/libs/testsetup"
+)
+
+// basicPolicy returns a policy with the rules of testsetup.BasicNamespaceSetup, and a reject rule.
+func basicPolicy() *gaia.NetworkRuleSetPolicy {
+
+	np := gaia.NewNetworkRuleSetPolicy()
+	np.Name = "basic-setup"
+	np.IncomingRules = []*gaia.NetworkRule{
+		testsetup.CreateNewNetworkRule("allow-ssh", gaia.NetworkRuleActionAllow,
+			[][]string{{"externalnetwork:name=ssh"}}, []string{"tcp/22"}),
+		testsetup.CreateNewNetworkRule("allow-ptp", gaia.NetworkRuleActionAllow,
+			[][]string{{"$identity=processingunit"}}, []string{"any"}),
+		testsetup.CreateNewNetworkRule("reject-db", gaia.NetworkRuleActionReject,
+			[][]string{{"$identity=processingunit", "app=db"}}, []string{"tcp/5000:6000"}),
+	}
+	np.OutgoingRules = []*gaia.NetworkRule{
+		testsetup.CreateNewNetworkRule("allow-dns", gaia.NetworkRuleActionAllow,
+			[][]string{{"externalnetwork:name=all"}}, []string{"udp/53", "icmp/8/0"}),
+	}
+
+	return np
+}
+
+func TestEvaluate(t *testing.T) {
+
+	observed := basicPolicy()
+	observed.Name = "observed"
+	for _, r := range observed.IncomingRules {
+		r.ObservationEnabled = true
+	}
+
+	tests := []struct {
+		name     string
+		policies []*gaia.NetworkRuleSetPolicy
+		c        Case
+		want     Verdict
+		wantRule string
+	}{
+		{
+			name:     "ssh from the external network",
+			policies: []*gaia.NetworkRuleSetPolicy{basicPolicy()},
+			c: Case{Direction: Incoming, Protocol: "TCP", Port: 22,
+				Peer: []string{"externalnetwork:name=ssh"}},
+			want:     Allow,
+			wantRule: "allow-ssh",
+		},
+		{
+			name:     "other port from the external network",
+			policies: []*gaia.NetworkRuleSetPolicy{basicPolicy()},
+			c: Case{Direction: Incoming, Protocol: "tcp", Port: 80,
+				Peer: []string{"externalnetwork:name=ssh"}},
+			want: Reject,
+		},
+		{
+			name:     "any port from a PU",
+			policies: []*gaia.NetworkRuleSetPolicy{basicPolicy()},
+			c: Case{Direction: Incoming, Protocol: "udp", Port: 5353,
+				Peer: []string{"$identity=processingunit", "app=web"}},
+			want:     Allow,
+			wantRule: "allow-ptp",
+		},
+		{
+			name:     "reject taking precedence in a port range",
+			policies: []*gaia.NetworkRuleSetPolicy{basicPolicy()},
+			c: Case{Direction: Incoming, Protocol: "tcp", Port: 5432,
+				Peer: []string{"$identity=processingunit", "app=db"}},
+			want:     Reject,
+			wantRule: "reject-db",
+		},
+		{
+			name:     "outgoing dns",
+			policies: []*gaia.NetworkRuleSetPolicy{basicPolicy()},
+			c: Case{Direction: Outgoing, Protocol: "udp", Port: 53,
+				Peer: []string{"externalnetwork:name=all"}},
+			want:     Allow,
+			wantRule: "allow-dns",
+		},
+		{
+			name:     "outgoing icmp echo",
+			policies: []*gaia.NetworkRuleSetPolicy{basicPolicy()},
+			c: Case{Direction: Outgoing, Protocol: "icmp", Port: 8,
+				Peer: []string{"externalnetwork:name=all"}},
+			want:     Allow,
+			wantRule: "allow-dns",
+		},
+		{
+			name:     "outgoing to a PU without rule",
+			policies: []*gaia.NetworkRuleSetPolicy{basicPolicy()},
+			c: Case{Direction: Outgoing, Protocol: "tcp", Port: 80,
+				Peer: []string{"$identity=processingunit"}},
+			want: Reject,
+		},
+		{
+			name:     "observed rules ignored",
+			policies: []*gaia.NetworkRuleSetPolicy{observed},
+			c: Case{Direction: Incoming, Protocol: "tcp", Port: 22,
+				Peer: []string{"externalnetwork:name=ssh"}},
+			want: Reject,
+		},
+		{
+			name: "no policy",
+			c: Case{Direction: Incoming, Protocol: "tcp", Port: 22,
+				Peer: []string{"externalnetwork:name=ssh"}},
+			want: Reject,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rule := Evaluate(tt.policies, &tt.c)
+			if got != tt.want || rule != tt.wantRule {
+				t.Errorf("Evaluate() = %s, %q, want %s, %q", got, rule, tt.want, tt.wantRule)
+			}
+		})
+	}
+}
+
+// A fakeRenderer renders the policies whose subject matches the tags of the PU in its namespace.
+type fakeRenderer struct {
+	policies map[string][]*gaia.NetworkRuleSetPolicy
+	// rendered are the namespaces and types of the rendered PUs.
+	rendered []string
+}
+
+func (f *fakeRenderer) RenderPolicies(name, ns string, tags []string,
+	puType gaia.ProcessingUnitTypeValue) (*gaia.RenderedPolicy, error) {
+
+	if _, ok := f.policies[ns]; !ok {
+		return nil, fmt.Errorf("namespace %s not found", ns)
+	}
+	f.rendered = append(f.rendered, ns+" "+string(puType))
+
+	rp := gaia.NewRenderedPolicy()
+	for _, np := range f.policies[ns] {
+		if Match(np.Subject, tags) {
+			rp.RuleSetPolicies = append(rp.RuleSetPolicies, np)
+		}
+	}
+
+	return rp, nil
+}
+
+func TestCheck(t *testing.T) {
+
+	np := basicPolicy()
+	np.Subject = [][]string{{"$namespace=/base/ns"}}
+	r := &fakeRenderer{policies: map[string][]*gaia.NetworkRuleSetPolicy{
+		"/base/ns": {np},
+		"/other":   {np},
+	}}
+	ssh := func(expect Verdict) *Case {
+		return &Case{Name: "ssh", Direction: Incoming, Protocol: "tcp", Port: 22,
+			Peer: []string{"externalnetwork:name=ssh"}, Expect: expect}
+	}
+
+	tests := []struct {
+		name         string
+		table        Table
+		wantFailed   int
+		wantRendered []string
+		wantErr      bool
+	}{
+		{
+			name: "relative namespace matched",
+			table: Table{Tests: []*Test{{Name: "basic",
+				PU:    PU{Name: "pu", Namespace: "ns", Type: gaia.ProcessingUnitTypeHost},
+				Cases: []*Case{ssh(Allow)}}}},
+			wantRendered: []string{"/base/ns Host"},
+		},
+		{
+			name: "absolute namespace not matched",
+			table: Table{Tests: []*Test{{Name: "other",
+				PU:    PU{Name: "pu", Namespace: "/other"},
+				Cases: []*Case{ssh(Allow), ssh(Reject)}}}},
+			wantFailed:   1,
+			wantRendered: []string{"/other Docker"},
+		},
+		{
+			name: "render error",
+			table: Table{Tests: []*Test{{Name: "missing",
+				PU: PU{Name: "pu", Namespace: "missing"}, Cases: []*Case{ssh(Allow)}}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r.rendered = nil
+			if err := tt.table.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			results, err := Check(r, "/base", &tt.table)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := Failed(results); got != tt.wantFailed {
+				t.Errorf("got %d failed cases, want %d:\n%s", got, tt.wantFailed,
+					Summary(results))
+			}
+			if fmt.Sprint(r.rendered) != fmt.Sprint(tt.wantRendered) {
+				t.Errorf("rendered %v, want %v", r.rendered, tt.wantRendered)
+			}
+		})
+	}
+}
+
+func TestOracle(t *testing.T) {
+
+	ptp := gaia.NewNetworkRuleSetPolicy()
+	ptp.Name = "ptp"
+	ptp.Subject = [][]string{{"$identity=processingunit"}}
+	ptp.IncomingRules = []*gaia.NetworkRule{
+		testsetup.CreateNewNetworkRule("in", gaia.NetworkRuleActionAllow,
+			[][]string{{"$identity=processingunit"}}, []string{"tcp", "udp"}),
+	}
+	ptp.OutgoingRules = []*gaia.NetworkRule{
+		testsetup.CreateNewNetworkRule("out", gaia.NetworkRuleActionAllow,
+			[][]string{{"$identity=processingunit"}}, []string{"tcp", "udp"}),
+	}
+
+	// The propagated policy of the parent namespace rejects udp towards the db PUs.
+	db := gaia.NewNetworkRuleSetPolicy()
+	db.Name = "db"
+	db.Propagate = true
+	db.Subject = [][]string{{"@usr:app=db"}}
+	db.IncomingRules = []*gaia.NetworkRule{
+		testsetup.CreateNewNetworkRule("reject-udp", gaia.NetworkRuleActionReject,
+			[][]string{{"$identity=processingunit"}}, []string{"udp"}),
+	}
+	policies := []*NamespacedPolicy{
+		{Namespace: "/base/ns", Policy: ptp},
+		{Namespace: "/base", Policy: db},
+	}
+
+	pu := func(name, app string) *gaia.ProcessingUnit {
+		p := gaia.NewProcessingUnit()
+		p.Name, p.Type, p.Metadata = name, gaia.ProcessingUnitTypeDocker, []string{"@usr:app=" + app}
+		return p
+	}
+	web, api, store := pu("web", "web"), pu("api", "api"), pu("store", "db")
+
+	tests := []struct {
+		name     string
+		ns       string
+		src, dst *gaia.ProcessingUnit
+		protocol int
+		want     bool
+	}{
+		{name: "tcp between PUs", ns: "/base/ns", src: web, dst: api, protocol: 6, want: true},
+		{name: "udp between PUs", ns: "/base/ns", src: api, dst: web, protocol: 17, want: true},
+		{name: "icmp not allowed", ns: "/base/ns", src: web, dst: api, protocol: 1},
+		{name: "propagated reject", ns: "/base/ns", src: web, dst: store, protocol: 17},
+		{name: "tcp to the db", ns: "/base/ns", src: web, dst: store, protocol: 6, want: true},
+		{name: "namespace without policy", ns: "/other", src: web, dst: api, protocol: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewOracle(tt.ns, policies).Accept(tt.src, tt.dst, tt.protocol, 8080)
+			if err != nil {
+				t.Fatalf("Accept() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Accept() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
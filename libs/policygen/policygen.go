@@ -0,0 +1,219 @@
+// Package policygen generates realistic network rule set policy sets: a number of policies per
+// namespace of a tree, a controlled ratio of which match the PUs of a simulator plan, with
+// overlapping subjects, several rules per policy and propagation from the top of the tree. The
+// policy resolution cost of a backend depends on the policies actually matching its PUs, which the
+// random policies of testsetup never do.
+package policygen
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"time"
+
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/simulator-test-harness/libs/plan"
+	"go.aporeto.io/simulator-test-harness/libs/testsetup"
+)
+
+// DefaultName is the prefix of the policy names used when Config.Name is empty.
+const DefaultName = "policygen"
+
+// A Config is the configuration of a generated policy set.
+type Config struct {
+	// Name is the prefix of the names of the policies. Defaults to DefaultName.
+	Name string `yaml:"name"`
+	// Policies is the number of policies per namespace.
+	Policies int `yaml:"policies"`
+	// MatchRatio is the ratio of the policies whose subject matches PUs of the plan, the others
+	// matching nothing.
+	MatchRatio float64 `yaml:"match-ratio"`
+	// Overlap is the number of matching policies sharing each subject, i.e. matching the same
+	// PUs. Defaults to 1, for disjoint subjects.
+	Overlap int `yaml:"overlap"`
+	// Rules is the number of incoming and of outgoing rules per policy.
+	Rules int `yaml:"rules"`
+	// PropagationDepth is the number of levels of the tree, from its root, whose policies are
+	// propagated to the children namespaces. 0 disables propagation.
+	PropagationDepth int `yaml:"propagation-depth"`
+}
+
+// Validate checks that the parameters of c are valid.
+func (c *Config) Validate() error {
+
+	if c.Policies < 0 || c.Rules < 0 || c.Overlap < 0 || c.PropagationDepth < 0 {
+		return fmt.Errorf("policies, rules, overlap and propagation-depth must not be negative")
+	}
+	if c.MatchRatio < 0 || c.MatchRatio > 1 {
+		return fmt.Errorf("match-ratio must be in [0, 1]")
+	}
+
+	return nil
+}
+
+// An Option configures the generation of a policy set.
+type Option func(*options)
+
+type options struct {
+	rand *rand.Rand
+}
+
+// OptionSeed seeds the random generator used for the policy generation, making it reproducible.
+func OptionSeed(seed int64) Option {
+
+	return func(o *options) {
+		o.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// A Policy is a generated policy and the namespace it is created in.
+type Policy struct {
+	// Namespace is the namespace of the policy.
+	Namespace string `yaml:"namespace"`
+	// Depth is the level of the namespace in the tree, the root being 0.
+	Depth int `yaml:"depth"`
+	// Matching is set if the subject of the policy matches PUs of the plan.
+	Matching bool                       `yaml:"matching"`
+	Policy   *gaia.NetworkRuleSetPolicy `yaml:"policy"`
+}
+
+// PUTags returns the distinct metadata of the PUs of pl, in order, which the matching policies
+// select.
+func PUTags(pl *plan.PlanLayout) []string {
+
+	var tags []string
+	seen := map[string]bool{}
+	for _, node := range pl.Plan.Nodes {
+		if node.ProcessingUnit == nil {
+			continue
+		}
+		for _, tag := range node.ProcessingUnit.Metadata {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags
+}
+
+// Generate generates the policies of c for every namespace of tree, created in the namespace ns,
+// the matching ones selecting the PU tags.
+func Generate(c *Config, ns string, tree *testsetup.NSTree, tags []string,
+	opts ...Option) ([]*Policy, error) {
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+	if c.MatchRatio > 0 && len(tags) == 0 {
+		return nil, fmt.Errorf("no PU tags to match")
+	}
+
+	o := &options{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	g := &generator{c: *c, o: o, tags: tags}
+	if g.c.Name == "" {
+		g.c.Name = DefaultName
+	}
+	if g.c.Overlap == 0 {
+		g.c.Overlap = 1
+	}
+	g.walk(ns, tree, 0)
+
+	return g.policies, nil
+}
+
+// A generator is the state of the generation of a policy set.
+type generator struct {
+	c    Config
+	o    *options
+	tags []string
+	// total and matching are the numbers of policies and of matching policies generated so far.
+	total, matching int
+	policies        []*Policy
+}
+
+// walk generates the policies of the namespace nst, created in ns at level depth, and of its
+// children.
+func (g *generator) walk(ns string, nst *testsetup.NSTree, depth int) {
+
+	namespace := path.Join(ns, nst.Name)
+	for i := 0; i < g.c.Policies; i++ {
+		g.policies = append(g.policies, g.policy(namespace, depth))
+	}
+
+	for i := range nst.Children {
+		g.walk(namespace, &nst.Children[i], depth+1)
+	}
+}
+
+// policy returns the next policy, in namespace at level depth. The matching policies are spread
+// evenly among all the policies, so that every namespace gets its share.
+func (g *generator) policy(namespace string, depth int) *Policy {
+
+	name := fmt.Sprintf("%s-%d", g.c.Name, g.total+1)
+	g.total++
+	matching := int(float64(g.total)*g.c.MatchRatio) > int(float64(g.total-1)*g.c.MatchRatio)
+
+	np := gaia.NewNetworkRuleSetPolicy()
+	np.Name = name
+	np.AssociatedTags = []string{"creator=simulator-test-harness", "policygen=" + g.c.Name}
+	np.Propagate = depth < g.c.PropagationDepth
+
+	object := func(r int) [][]string {
+		return [][]string{{"matchedby=" + fmt.Sprintf("%s-%d", name, r)}}
+	}
+	if matching {
+		// Every Overlap consecutive matching policies share a subject.
+		tag := g.tags[(g.matching/g.c.Overlap)%len(g.tags)]
+		np.Subject = [][]string{{"$identity=processingunit", tag}}
+		object = func(int) [][]string {
+			return [][]string{{g.tags[g.o.rand.Intn(len(g.tags))]}}
+		}
+		g.matching++
+	} else {
+		np.Subject = [][]string{{"matchedby=" + name}}
+	}
+
+	for r := 0; r < g.c.Rules; r++ {
+		np.IncomingRules = append(np.IncomingRules, g.rule(fmt.Sprintf("%s-in-%d", name, r),
+			object(r)))
+		np.OutgoingRules = append(np.OutgoingRules, g.rule(fmt.Sprintf("%s-out-%d", name, r),
+			object(r)))
+	}
+
+	return &Policy{Namespace: namespace, Depth: depth, Matching: matching, Policy: np}
+}
+
+// rule returns a rule with a random action and port towards object.
+func (g *generator) rule(name string, object [][]string) *gaia.NetworkRule {
+
+	actions := []gaia.NetworkRuleActionValue{
+		gaia.NetworkRuleActionAllow,
+		gaia.NetworkRuleActionReject,
+	}
+	protocols := []string{"tcp", "udp"}
+	port := fmt.Sprintf("%s/%d", protocols[g.o.rand.Intn(len(protocols))],
+		1025+g.o.rand.Intn(64_000))
+
+	return testsetup.CreateNewNetworkRule(name, actions[g.o.rand.Intn(len(actions))], object,
+		[]string{port})
+}
+
+// Apply creates the policies in the backend of c, the namespaces existing.
+func Apply(c *testsetup.Client, policies []*Policy) error {
+
+	for _, p := range policies {
+		np := p.Policy
+		if _, err := c.CreateNetworkPolicy(np.Name, p.Namespace, np.AssociatedTags, np.Subject,
+			np.Propagate, np.IncomingRules, np.OutgoingRules); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
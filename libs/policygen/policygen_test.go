@@ -0,0 +1,134 @@
+package policygen
+
+import (
+	"reflect"
+	"testing"
+
+	"go.aporeto.io/simulator-test-harness/libs/plan"
+	"go.aporeto.io/simulator-test-harness/libs/testsetup"
+)
+
+// testTree returns a tree of a root with 2 children, the first one with a child.
+func testTree() *testsetup.NSTree {
+
+	return &testsetup.NSTree{
+		Name: "root",
+		Children: []testsetup.NSTree{
+			{Name: "c1", Children: []testsetup.NSTree{{Name: "g1"}}},
+			{Name: "c2"},
+		},
+	}
+}
+
+func TestGenerate(t *testing.T) {
+
+	pl, err := plan.Generate(&plan.Config{Name: "test", PUs: 4}, plan.OptionSeed(1))
+	if err != nil {
+		t.Fatalf("plan.Generate() error = %v", err)
+	}
+	tags := PUTags(pl)
+	if len(tags) != 12 {
+		t.Fatalf("got %d PU tags, want 12", len(tags))
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		// wantMatching is the number of matching policies per namespace, in walk order.
+		wantMatching []int
+		// wantSubjects is the number of distinct subjects of the matching policies.
+		wantSubjects int
+		// wantPropagated is the number of propagated policies.
+		wantPropagated int
+		wantErr        bool
+	}{
+		{
+			name:           "no match",
+			config:         Config{Policies: 5, Rules: 2},
+			wantMatching:   []int{0, 0, 0, 0},
+			wantSubjects:   0,
+			wantPropagated: 0,
+		},
+		{
+			name:           "half matching with overlap",
+			config:         Config{Policies: 4, MatchRatio: 0.5, Overlap: 2, Rules: 1},
+			wantMatching:   []int{2, 2, 2, 2},
+			wantSubjects:   4,
+			wantPropagated: 0,
+		},
+		{
+			name:           "all matching propagated from the root",
+			config:         Config{Policies: 3, MatchRatio: 1, Rules: 3, PropagationDepth: 1},
+			wantMatching:   []int{3, 3, 3, 3},
+			wantSubjects:   12,
+			wantPropagated: 3,
+		},
+		{
+			name:           "propagated from two levels",
+			config:         Config{Policies: 1, MatchRatio: 1, PropagationDepth: 2},
+			wantMatching:   []int{1, 1, 1, 1},
+			wantSubjects:   4,
+			wantPropagated: 3,
+		},
+		{
+			name:    "invalid match ratio",
+			config:  Config{Policies: 1, MatchRatio: 2},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policies, err := Generate(&tt.config, "/base", testTree(), tags, OptionSeed(42))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Generate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			namespaces := []string{"/base/root", "/base/root/c1", "/base/root/c1/g1",
+				"/base/root/c2"}
+			matching := make([]int, len(namespaces))
+			subjects := map[string]bool{}
+			propagated := 0
+			for _, p := range policies {
+				for i, ns := range namespaces {
+					if p.Namespace == ns && p.Matching {
+						matching[i]++
+					}
+				}
+				if p.Matching {
+					subjects[p.Policy.Subject[0][1]] = true
+				}
+				if p.Policy.Propagate {
+					propagated++
+				}
+				if len(p.Policy.IncomingRules) != tt.config.Rules ||
+					len(p.Policy.OutgoingRules) != tt.config.Rules {
+					t.Errorf("policy %s has %d/%d rules, want %d", p.Policy.Name,
+						len(p.Policy.IncomingRules), len(p.Policy.OutgoingRules), tt.config.Rules)
+				}
+			}
+
+			if len(policies) != tt.config.Policies*len(namespaces) {
+				t.Errorf("got %d policies, want %d", len(policies),
+					tt.config.Policies*len(namespaces))
+			}
+			if !reflect.DeepEqual(matching, tt.wantMatching) {
+				t.Errorf("got %v matching policies, want %v", matching, tt.wantMatching)
+			}
+			if len(subjects) != tt.wantSubjects {
+				t.Errorf("got %d distinct subjects, want %d", len(subjects), tt.wantSubjects)
+			}
+			if propagated != tt.wantPropagated {
+				t.Errorf("got %d propagated policies, want %d", propagated, tt.wantPropagated)
+			}
+
+			again, _ := Generate(&tt.config, "/base", testTree(), tags, OptionSeed(42))
+			if !reflect.DeepEqual(again, policies) {
+				t.Errorf("generation is not reproducible with a seed")
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package rollout
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.aporeto.io/simulator-test-harness/common"
+)
+
+// Wait checks the health of t until it is healthy, at most c.Checks times with an exponential
+// backoff between the checks, and returns the report of the last check. It only fails if ctx is
+// done.
+func Wait(ctx context.Context, c *Config, p *Probes, t Target) (*Report, error) {
+
+	r := &Report{}
+	backoff := c.Backoff
+	for {
+		s := p.Signals(ctx, t)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		r.Checks++
+		r.Signals = *s
+		r.Violations = c.Violations(t, s)
+		r.Healthy = len(r.Violations) == 0
+		r.Time = time.Now()
+
+		if r.Healthy {
+			common.Log.Infof("%s healthy: %d/%d enforcers connected, API error rate %.2f%%, "+
+				"latency %v, flow lag %v, %d failed pods", t.Name, s.Connected, t.Enforcers,
+				100*s.ErrorRate, s.Latency, s.FlowLag, len(s.FailedPods))
+			return r, nil
+		}
+		if r.Checks >= c.Checks {
+			common.Log.Warnf("%s unhealthy after %d checks: %s", t.Name, r.Checks,
+				strings.Join(r.Violations, ", "))
+			return r, nil
+		}
+
+		common.Log.Infof("%s unhealthy (check %d/%d), retrying in %v: %s", t.Name,
+			r.Checks, c.Checks, backoff, strings.Join(r.Violations, ", "))
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+)
+
+// An EnforcerCounter counts the enforcers of a target.
+type EnforcerCounter interface {
+	// CountEnforcers returns the number of enforcers with tag registered in namespace (searched
+	// recursively), and the number of those connected.
+	CountEnforcers(ctx context.Context, namespace, tag string) (registered, connected int, err error)
+}
+
+// A MetricsSource measures the API error rate and latency.
+type MetricsSource interface {
+	// Metrics returns the ratio of failed API requests and the API latency for target t.
+	Metrics(ctx context.Context, t Target) (errorRate float64, latency time.Duration, err error)
+}
+
+// A FlowLagSource measures the flow ingestion lag.
+type FlowLagSource interface {
+	// FlowLag returns the flow ingestion lag of the backend.
+	FlowLag(ctx context.Context) (time.Duration, error)
+}
+
+// A PodLister lists the failed pods of the run, e.g. a *k8s.Client.
+type PodLister interface {
+	// FailedPods returns the names of the failed pods matching selector (all the pods of the
+	// namespace if empty).
+	FailedPods(ctx context.Context, namespace, selector string) ([]string, error)
+}
+
+// A Probes is the sources of the health signals. Nil sources are skipped.
+type Probes struct {
+	Enforcers EnforcerCounter
+	Metrics   MetricsSource
+	FlowLag   FlowLagSource
+	Pods      PodLister
+}
+
+// Signals returns the health signals of t. The errors of the probes are reported in the signals.
+func (p *Probes) Signals(ctx context.Context, t Target) *Signals {
+
+	var s Signals
+
+	if p.Enforcers != nil {
+		for _, tag := range t.Tags {
+			registered, connected, err := p.Enforcers.CountEnforcers(ctx, t.Namespace, tag)
+			if err != nil {
+				s.Errors = append(s.Errors, fmt.Sprintf("count enforcers %s: %v", tag, err))
+				continue
+			}
+			s.Enforcers += registered
+			s.Connected += connected
+		}
+	}
+
+	if p.Metrics != nil {
+		var err error
+		s.ErrorRate, s.Latency, err = p.Metrics.Metrics(ctx, t)
+		if err != nil {
+			s.Errors = append(s.Errors, fmt.Sprintf("metrics: %v", err))
+		}
+	}
+
+	if p.FlowLag != nil {
+		var err error
+		if s.FlowLag, err = p.FlowLag.FlowLag(ctx); err != nil {
+			s.Errors = append(s.Errors, fmt.Sprintf("flow lag: %v", err))
+		}
+	}
+
+	if p.Pods != nil {
+		var err error
+		s.FailedPods, err = p.Pods.FailedPods(ctx, t.K8sNamespace, "")
+		if err != nil {
+			s.Errors = append(s.Errors, fmt.Sprintf("list failed pods: %v", err))
+		}
+	}
+
+	return &s
+}
+
+// An APIProbe probes the health of the backend through its API. It is both an EnforcerCounter and
+// a MetricsSource, measuring the error rate and latency of its own requests.
+type APIProbe struct {
+	m       manipulate.Manipulator
+	samples int
+	timeout time.Duration
+}
+
+// NewAPIProbe returns an APIProbe using m, measuring the metrics over samples requests each
+// failing after timeout.
+func NewAPIProbe(m manipulate.Manipulator, samples int, timeout time.Duration) *APIProbe {
+
+	return &APIProbe{
+		m:       m,
+		samples: samples,
+		timeout: timeout,
+	}
+}
+
+// CountEnforcers implements EnforcerCounter.
+func (p *APIProbe) CountEnforcers(
+	ctx context.Context,
+	namespace, tag string,
+) (int, int, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var enforcers gaia.EnforcersList
+	mctx := manipulate.NewContext(ctx,
+		manipulate.ContextOptionNamespace(namespace),
+		manipulate.ContextOptionRecursive(true),
+		manipulate.ContextOptionFilter(
+			elemental.NewFilterComposer().WithKey("associatedtags").Contains(tag).Done(),
+		),
+	)
+	if err := p.m.RetrieveMany(mctx, &enforcers); err != nil {
+		return 0, 0, err
+	}
+
+	connected := 0
+	for _, e := range enforcers {
+		if e.OperationalStatus == gaia.EnforcerOperationalStatusConnected {
+			connected++
+		}
+	}
+
+	return len(enforcers), connected, nil
+}
+
+// Metrics implements MetricsSource, counting the enforcers of the namespace of t. The latency is
+// the 99th percentile of the latencies of the requests, timed out requests counting as timeout.
+func (p *APIProbe) Metrics(ctx context.Context, t Target) (float64, time.Duration, error) {
+
+	if p.samples < 1 {
+		return 0, 0, fmt.Errorf("no samples")
+	}
+
+	failed := 0
+	latencies := make([]time.Duration, 0, p.samples)
+	for i := 0; i < p.samples; i++ {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+
+		sctx, cancel := context.WithTimeout(ctx, p.timeout)
+		start := time.Now()
+		mctx := manipulate.NewContext(sctx,
+			manipulate.ContextOptionNamespace(t.Namespace),
+			manipulate.ContextOptionRecursive(true),
+		)
+		if _, err := p.m.Count(mctx, gaia.EnforcerIdentity); err != nil {
+			failed++
+		}
+		latencies = append(latencies, time.Since(start))
+		cancel()
+	}
+
+	return float64(failed) / float64(p.samples), percentile(latencies, 99), nil
+}
+
+// percentile returns the nearest-rank pth percentile of latencies, which must not be empty.
+func percentile(latencies []time.Duration, p int) time.Duration {
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	rank := (p*len(latencies) + 99) / 100
+
+	return latencies[rank-1]
+}
@@ -0,0 +1,262 @@
+package rollout
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.aporeto.io/simulator-test-harness/libs/backend"
+)
+
+const (
+	// DefaultErrorRateQuery is the default PromQL query of the API error rate.
+	DefaultErrorRateQuery = `sum(rate(http_requests_total{code=~"5.."}[1m])) / ` +
+		`sum(rate(http_requests_total[1m]))`
+	// DefaultLatencyQuery is the default PromQL query of the API latency, in seconds.
+	DefaultLatencyQuery = `histogram_quantile(0.99, ` +
+		`sum(rate(http_request_duration_seconds_bucket[1m])) by (le))`
+)
+
+// A Prometheus is a MetricsSource querying the Prometheus of a backend monitoring stack, through
+// its Grafana data source proxy.
+type Prometheus struct {
+	client         *http.Client
+	queryURL       string
+	errorRateQuery string
+	latencyQuery   string
+	flowLagQuery   string
+}
+
+// A PrometheusOption is an option of NewPrometheus.
+type PrometheusOption func(*Prometheus)
+
+// OptionErrorRateQuery sets the PromQL query of the API error rate.
+func OptionErrorRateQuery(query string) PrometheusOption {
+
+	return func(p *Prometheus) {
+		p.errorRateQuery = query
+	}
+}
+
+// OptionLatencyQuery sets the PromQL query of the API latency, in seconds.
+func OptionLatencyQuery(query string) PrometheusOption {
+
+	return func(p *Prometheus) {
+		p.latencyQuery = query
+	}
+}
+
+// OptionFlowLagQuery sets the PromQL query of the flow ingestion lag, in seconds. The flow lag is
+// only measured if set.
+func OptionFlowLagQuery(query string) PrometheusOption {
+
+	return func(p *Prometheus) {
+		p.flowLagQuery = query
+	}
+}
+
+// OptionHTTPClient sets the HTTP client of the queries, instead of a client authenticated with the
+// monitoring certificate.
+func OptionHTTPClient(client *http.Client) PrometheusOption {
+
+	return func(p *Prometheus) {
+		p.client = client
+	}
+}
+
+// NewPrometheus returns a Prometheus querying the monitoring stack detailed in md. The monitoring
+// certificate must be a PEM file holding the certificate and its key, encrypted with the
+// certificate password if any.
+func NewPrometheus(md *backend.MonitoringDetails, opts ...PrometheusOption) (*Prometheus, error) {
+
+	if md.URL == "" {
+		return nil, fmt.Errorf("no monitoring URL")
+	}
+
+	p := &Prometheus{
+		queryURL: fmt.Sprintf("%s/api/datasources/proxy/%d/api/v1/query",
+			strings.TrimSuffix(md.URL, "/"), md.DatasourceID),
+		errorRateQuery: DefaultErrorRateQuery,
+		latencyQuery:   DefaultLatencyQuery,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.client == nil {
+		cert, err := loadCertificate(md.Cert.Path, md.Cert.Password)
+		if err != nil {
+			return nil, err
+		}
+		p.client = &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					Certificates:       []tls.Certificate{cert},
+					InsecureSkipVerify: true, // NOTE: Skipping verification like the API client
+				},
+			},
+		}
+	}
+
+	return p, nil
+}
+
+// loadCertificate loads the PEM certificate and key in certFile, decrypting the key with password
+// if encrypted.
+func loadCertificate(certFile, password string) (tls.Certificate, error) {
+
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("read %s: %v", certFile, err)
+	}
+
+	var blocks []byte
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if x509.IsEncryptedPEMBlock(block) {
+			der, err := x509.DecryptPEMBlock(block, []byte(password))
+			if err != nil {
+				return tls.Certificate{}, fmt.Errorf("decrypt %s key: %v", certFile, err)
+			}
+			block = &pem.Block{Type: block.Type, Bytes: der}
+		}
+		blocks = append(blocks, pem.EncodeToMemory(block)...)
+	}
+
+	cert, err := tls.X509KeyPair(blocks, blocks)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("parse %s: %v", certFile, err)
+	}
+
+	return cert, nil
+}
+
+// Metrics implements MetricsSource.
+func (p *Prometheus) Metrics(ctx context.Context, t Target) (float64, time.Duration, error) {
+
+	errorRate, err := p.Query(ctx, p.errorRateQuery)
+	if err != nil {
+		return 0, 0, fmt.Errorf("query error rate: %v", err)
+	}
+
+	latency, err := p.Query(ctx, p.latencyQuery)
+	if err != nil {
+		return 0, 0, fmt.Errorf("query latency: %v", err)
+	}
+
+	return errorRate, time.Duration(latency * float64(time.Second)), nil
+}
+
+// FlowLag implements FlowLagSource.
+func (p *Prometheus) FlowLag(ctx context.Context) (time.Duration, error) {
+
+	if p.flowLagQuery == "" {
+		return 0, fmt.Errorf("no flow lag query")
+	}
+
+	lag, err := p.Query(ctx, p.flowLagQuery)
+	if err != nil {
+		return 0, fmt.Errorf("query flow lag: %v", err)
+	}
+
+	return time.Duration(lag * float64(time.Second)), nil
+}
+
+// HasFlowLag reports whether p measures the flow ingestion lag.
+func (p *Prometheus) HasFlowLag() bool {
+
+	return p.flowLagQuery != ""
+}
+
+// A queryResponse is the response of the Prometheus instant query API.
+type queryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// Query returns the value of the instant query, which must return a scalar or a vector of at
+// most one sample. An empty vector (e.g. no requests) is 0, as is NaN.
+func (p *Prometheus) Query(ctx context.Context, query string) (float64, error) {
+
+	u := p.queryURL + "?" + url.Values{"query": {query}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, fmt.Errorf("create request: %v", err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("read response: %v", err)
+	}
+
+	var qr queryResponse
+	if err := json.Unmarshal(body, &qr); err != nil {
+		return 0, fmt.Errorf("unmarshal response (HTTP %d): %v", resp.StatusCode, err)
+	}
+	if qr.Status != "success" {
+		return 0, fmt.Errorf("query failed (HTTP %d): %s", resp.StatusCode, qr.Error)
+	}
+
+	var value []interface{}
+	switch qr.Data.ResultType {
+	case "scalar":
+		if err := json.Unmarshal(qr.Data.Result, &value); err != nil {
+			return 0, fmt.Errorf("unmarshal scalar: %v", err)
+		}
+	case "vector":
+		var vector []struct {
+			Value []interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(qr.Data.Result, &vector); err != nil {
+			return 0, fmt.Errorf("unmarshal vector: %v", err)
+		}
+		switch len(vector) {
+		case 0:
+			return 0, nil
+		case 1:
+			value = vector[0].Value
+		default:
+			return 0, fmt.Errorf("query returned %d samples, want 1", len(vector))
+		}
+	default:
+		return 0, fmt.Errorf("unsupported result type %q", qr.Data.ResultType)
+	}
+
+	if len(value) != 2 {
+		return 0, fmt.Errorf("invalid sample %v", value)
+	}
+	s, ok := value[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid sample value %v", value[1])
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse sample value: %v", err)
+	}
+	if math.IsNaN(v) { // e.g. 0/0
+		return 0, nil
+	}
+
+	return v, nil
+}
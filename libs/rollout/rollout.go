@@ -0,0 +1,166 @@
+// Package rollout gates the batches of a scale run on the health of the backend: the enforcers of
+// the last batch must be connected, the API error rate and latency must be within limits and the
+// failed pods below a threshold. Unhealthy batches are re-checked with an exponential backoff, and
+// the next batches are shrunk, so that a run stops at the breaking point of the backend instead
+// of pushing through it. The same checks are the oracle of a breaking-point Search.
+package rollout
+
+import (
+	"fmt"
+	"time"
+)
+
+// A Config is the health limits and the backoff of a rollout.
+type Config struct {
+	// MinConnected is the minimum ratio of the enforcers of a batch that must be connected.
+	MinConnected float64 `yaml:"minConnected"`
+	// MaxErrorRate is the maximum ratio of failed API requests.
+	MaxErrorRate float64 `yaml:"maxErrorRate"`
+	// MaxLatency is the maximum API latency.
+	MaxLatency time.Duration `yaml:"maxLatency"`
+	// MaxFlowLag is the maximum flow ingestion lag, if measured.
+	MaxFlowLag time.Duration `yaml:"maxFlowLag"`
+	// MaxFailedPods is the maximum number of failed pods in the kubernetes namespace of the run.
+	MaxFailedPods int `yaml:"maxFailedPods"`
+	// Checks is the number of health checks of a batch before declaring it unhealthy.
+	Checks int `yaml:"checks"`
+	// Backoff is the delay before the second health check, doubled after every check.
+	Backoff time.Duration `yaml:"backoff"`
+	// MaxBackoff is the maximum delay between two health checks.
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	// MinPods is the minimum number of pods of a batch. A run stops when a batch of MinPods is
+	// unhealthy.
+	MinPods int `yaml:"minPods"`
+}
+
+// DefaultConfig returns the default rollout configuration.
+func DefaultConfig() Config {
+
+	return Config{
+		MinConnected:  0.95,
+		MaxErrorRate:  0.01,
+		MaxLatency:    2 * time.Second,
+		MaxFlowLag:    time.Minute,
+		MaxFailedPods: 0,
+		Checks:        5,
+		Backoff:       30 * time.Second,
+		MaxBackoff:    5 * time.Minute,
+		MinPods:       1,
+	}
+}
+
+// Validate checks that the parameters of c are valid.
+func (c *Config) Validate() error {
+
+	if c.MinConnected < 0 || c.MinConnected > 1 {
+		return fmt.Errorf("minConnected must be in [0, 1]")
+	}
+	if c.MaxErrorRate < 0 || c.MaxErrorRate > 1 {
+		return fmt.Errorf("maxErrorRate must be in [0, 1]")
+	}
+	if c.MaxLatency <= 0 || c.MaxFlowLag <= 0 || c.MaxFailedPods < 0 {
+		return fmt.Errorf("maxLatency and maxFlowLag must be positive, maxFailedPods not negative")
+	}
+	if c.Checks < 1 || c.MinPods < 1 {
+		return fmt.Errorf("checks and minPods must be positive")
+	}
+	if c.Backoff < 0 || c.MaxBackoff < c.Backoff {
+		return fmt.Errorf("invalid backoff %v (max %v)", c.Backoff, c.MaxBackoff)
+	}
+
+	return nil
+}
+
+// NextPods returns the number of pods of the batch following a batch of pods, at most max: the
+// batch size is halved after an unhealthy batch, and doubled back after a healthy one.
+func (c *Config) NextPods(pods, max int, healthy bool) int {
+
+	next := pods * 2
+	if !healthy {
+		next = pods / 2
+	}
+
+	if next < c.MinPods {
+		next = c.MinPods
+	}
+	if next > max {
+		next = max
+	}
+
+	return next
+}
+
+// A Target is the enforcers whose health is checked, e.g. the enforcers of a batch.
+type Target struct {
+	// Name describes the target in the logs.
+	Name string
+	// Namespace is the Aporeto namespace of the enforcers, searched recursively.
+	Namespace string
+	// Tags are the tags of the enforcers, one per group of enforcers (e.g. batch).
+	Tags []string
+	// Enforcers is the number of enforcers expected.
+	Enforcers int
+	// K8sNamespace is the kubernetes namespace of the pods of the run.
+	K8sNamespace string
+}
+
+// A Signals is a snapshot of the health signals of a target.
+type Signals struct {
+	// Enforcers is the number of enforcers of the target registered.
+	Enforcers int `yaml:"enforcers"`
+	// Connected is the number of enforcers of the target connected.
+	Connected int `yaml:"connected"`
+	// ErrorRate is the ratio of failed API requests.
+	ErrorRate float64 `yaml:"errorRate"`
+	// Latency is the API latency.
+	Latency time.Duration `yaml:"latency"`
+	// FlowLag is the flow ingestion lag, if measured.
+	FlowLag time.Duration `yaml:"flowLag,omitempty"`
+	// FailedPods are the names of the failed pods.
+	FailedPods []string `yaml:"failedPods,omitempty"`
+	// Errors are the errors of the probes, which count as violations.
+	Errors []string `yaml:"errors,omitempty"`
+}
+
+// Violations returns the limits of c violated by s for target t, if any.
+func (c *Config) Violations(t Target, s *Signals) []string {
+
+	violations := append([]string(nil), s.Errors...)
+
+	if min := c.MinConnected * float64(t.Enforcers); float64(s.Connected) < min {
+		violations = append(violations, fmt.Sprintf("%d/%d enforcers connected, want %.0f",
+			s.Connected, t.Enforcers, min))
+	}
+	if s.ErrorRate > c.MaxErrorRate {
+		violations = append(violations, fmt.Sprintf("API error rate %.2f%% over %.2f%%",
+			100*s.ErrorRate, 100*c.MaxErrorRate))
+	}
+	if s.Latency > c.MaxLatency {
+		violations = append(violations, fmt.Sprintf("API latency %v over %v", s.Latency,
+			c.MaxLatency))
+	}
+	if s.FlowLag > c.MaxFlowLag {
+		violations = append(violations, fmt.Sprintf("flow ingestion lag %v over %v", s.FlowLag,
+			c.MaxFlowLag))
+	}
+	if len(s.FailedPods) > c.MaxFailedPods {
+		violations = append(violations, fmt.Sprintf("%d failed pods over %d", len(s.FailedPods),
+			c.MaxFailedPods))
+	}
+
+	return violations
+}
+
+// A Report is the outcome of the health checks of a batch.
+type Report struct {
+	// Healthy is set if the last check passed.
+	Healthy bool `yaml:"healthy"`
+	// Checks is the number of checks performed.
+	Checks int `yaml:"checks"`
+	// Signals are the signals of the last check.
+	Signals Signals `yaml:"signals"`
+	// Violations are the limits violated at the last check.
+	Violations []string `yaml:"violations,omitempty"`
+	// Time is the time of the last check.
+	Time time.Time `yaml:"time"`
+}
@@ -0,0 +1,272 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.aporeto.io/simulator-test-harness/libs/backend"
+)
+
+func TestViolations(t *testing.T) {
+
+	c := DefaultConfig()
+	target := Target{Enforcers: 100}
+	tests := []struct {
+		name    string
+		signals Signals
+		want    int
+	}{
+		{
+			name:    "healthy",
+			signals: Signals{Enforcers: 100, Connected: 95, Latency: time.Second},
+		},
+		{
+			name:    "disconnected",
+			signals: Signals{Enforcers: 100, Connected: 94},
+			want:    1,
+		},
+		{
+			name:    "api errors and latency",
+			signals: Signals{Connected: 100, ErrorRate: 0.02, Latency: 3 * time.Second},
+			want:    2,
+		},
+		{
+			name:    "flow lag",
+			signals: Signals{Connected: 100, FlowLag: 2 * time.Minute},
+			want:    1,
+		},
+		{
+			name:    "failed pods",
+			signals: Signals{Connected: 100, FailedPods: []string{"sim-pods-1"}},
+			want:    1,
+		},
+		{
+			name:    "probe errors",
+			signals: Signals{Connected: 100, Errors: []string{"count enforcers: timeout"}},
+			want:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Violations(target, &tt.signals); len(got) != tt.want {
+				t.Errorf("Violations() = %v, want %d violations", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextPods(t *testing.T) {
+
+	c := DefaultConfig()
+	c.MinPods = 2
+	tests := []struct {
+		pods    int
+		healthy bool
+		want    int
+	}{
+		{pods: 30, healthy: true, want: 30},
+		{pods: 30, healthy: false, want: 15},
+		{pods: 15, healthy: true, want: 30},
+		{pods: 10, healthy: true, want: 20},
+		{pods: 3, healthy: false, want: 2},
+		{pods: 2, healthy: false, want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d %v", tt.pods, tt.healthy), func(t *testing.T) {
+			if got := c.NextPods(tt.pods, 30, tt.healthy); got != tt.want {
+				t.Errorf("NextPods() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// A fakeSources is a source of signals connecting one more enforcer on every count.
+type fakeSources struct {
+	connected int
+	latency   time.Duration
+}
+
+func (f *fakeSources) CountEnforcers(ctx context.Context, namespace, tag string) (int, int, error) {
+
+	f.connected++
+	return 10, f.connected, nil
+}
+
+func (f *fakeSources) Metrics(ctx context.Context, t Target) (float64, time.Duration, error) {
+
+	return 0, f.latency, nil
+}
+
+func (f *fakeSources) FailedPods(ctx context.Context, namespace, sel string) ([]string, error) {
+
+	return nil, nil
+}
+
+func TestWait(t *testing.T) {
+
+	ctx := context.Background()
+	c := DefaultConfig()
+	c.MinConnected, c.Checks = 1, 5
+	c.Backoff, c.MaxBackoff = time.Millisecond, 2*time.Millisecond
+	target := Target{Name: "batch 1", Tags: []string{"simbase=ab12c-1"}, Enforcers: 3}
+
+	f := &fakeSources{}
+	p := &Probes{Enforcers: f, Metrics: f, Pods: f}
+	r, err := Wait(ctx, &c, p, target)
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if !r.Healthy || r.Checks != 3 || r.Signals.Connected != 3 {
+		t.Errorf("Wait() = %+v, want healthy after 3 checks", r)
+	}
+
+	f = &fakeSources{latency: time.Minute}
+	p = &Probes{Enforcers: f, Metrics: f}
+	if r, err = Wait(ctx, &c, p, target); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if r.Healthy || r.Checks != 5 || len(r.Violations) != 1 {
+		t.Errorf("Wait() = %+v, want unhealthy after 5 checks", r)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := Wait(cctx, &c, p, target); err == nil {
+		t.Errorf("Wait() succeeded with a cancelled context")
+	}
+}
+
+func TestSearch(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		max        int
+		breaking   int
+		wantLevels []int
+		wantPassed int
+		wantFailed int
+	}{
+		{
+			name:       "bisect",
+			max:        100,
+			breaking:   23,
+			wantLevels: []int{1, 2, 4, 8, 16, 32, 24, 20, 22, 23},
+			wantPassed: 22,
+			wantFailed: 23,
+		},
+		{
+			name:       "never breaks",
+			max:        10,
+			breaking:   11,
+			wantLevels: []int{1, 2, 4, 8, 10},
+			wantPassed: 10,
+		},
+		{
+			name:       "breaks at max",
+			max:        10,
+			breaking:   10,
+			wantLevels: []int{1, 2, 4, 8, 10, 9},
+			wantPassed: 9,
+			wantFailed: 10,
+		},
+		{
+			name:       "breaks at once",
+			max:        10,
+			breaking:   1,
+			wantLevels: []int{1},
+			wantFailed: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSearch(tt.max)
+			var levels []int
+			for level, ok := s.Next(); ok; level, ok = s.Next() {
+				levels = append(levels, level)
+				s.Record(level, 10*level, &Report{Healthy: level < tt.breaking})
+			}
+
+			if !reflect.DeepEqual(levels, tt.wantLevels) {
+				t.Errorf("searched levels %v, want %v", levels, tt.wantLevels)
+			}
+			if s.Passed != tt.wantPassed || s.Failed != tt.wantFailed {
+				t.Errorf("got passed %d and failed %d, want %d and %d", s.Passed, s.Failed,
+					tt.wantPassed, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+
+	responses := map[string]string{
+		"errors": `{"status":"success","data":{"resultType":"vector",` +
+			`"result":[{"metric":{},"value":[1600000000,"0.05"]}]}}`,
+		"latency": `{"status":"success","data":{"resultType":"scalar",` +
+			`"result":[1600000000,"0.25"]}}`,
+		"empty": `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+		"nan": `{"status":"success","data":{"resultType":"vector",` +
+			`"result":[{"metric":{},"value":[1600000000,"NaN"]}]}}`,
+		"many": `{"status":"success","data":{"resultType":"vector",` +
+			`"result":[{"value":[1,"1"]},{"value":[1,"2"]}]}}`,
+		"bad": `{"status":"error","error":"parse error"}`,
+		"lag": `{"status":"success","data":{"resultType":"scalar","result":[1600000000,"90"]}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/datasources/proxy/3/api/v1/query" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, responses[r.URL.Query().Get("query")])
+	}))
+	defer server.Close()
+
+	md := &backend.MonitoringDetails{URL: server.URL + "/", DatasourceID: 3}
+	p, err := NewPrometheus(md, OptionHTTPClient(server.Client()),
+		OptionErrorRateQuery("errors"), OptionLatencyQuery("latency"), OptionFlowLagQuery("lag"))
+	if err != nil {
+		t.Fatalf("NewPrometheus() error = %v", err)
+	}
+
+	ctx := context.Background()
+	errorRate, latency, err := p.Metrics(ctx, Target{})
+	if err != nil {
+		t.Fatalf("Metrics() error = %v", err)
+	}
+	if errorRate != 0.05 || latency != 250*time.Millisecond {
+		t.Errorf("Metrics() = %v, %v", errorRate, latency)
+	}
+
+	if lag, err := p.FlowLag(ctx); err != nil || lag != 90*time.Second {
+		t.Errorf("FlowLag() = %v, %v", lag, err)
+	}
+
+	got := map[string]float64{}
+	var failed []string
+	for _, query := range []string{"empty", "nan", "many", "bad"} {
+		v, err := p.Query(ctx, query)
+		if err != nil {
+			failed = append(failed, query)
+			continue
+		}
+		got[query] = v
+	}
+	if !reflect.DeepEqual(got, map[string]float64{"empty": 0, "nan": 0}) {
+		t.Errorf("Query() = %v", got)
+	}
+	if !reflect.DeepEqual(failed, []string{"many", "bad"}) {
+		t.Errorf("Query() failed for %v", failed)
+	}
+
+	if _, err := NewPrometheus(&backend.MonitoringDetails{}); err == nil {
+		t.Errorf("NewPrometheus() succeeded without URL")
+	}
+}
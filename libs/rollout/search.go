@@ -0,0 +1,124 @@
+package rollout
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+// A Level is a level checked by a breaking-point search.
+type Level struct {
+	// Level is the level checked, e.g. a number of batches.
+	Level int `yaml:"level"`
+	// Enforcers is the number of enforcers deployed at the level.
+	Enforcers int `yaml:"enforcers"`
+	// Report is the report of the health checks of the level.
+	Report *Report `yaml:"report"`
+}
+
+// A Search is a breaking-point search over the levels 1 to Max: the level is ramped up
+// geometrically until a level fails, then the highest passing level is bisected. The levels are
+// expected to be monotonic, i.e. every level above a failing level fails.
+type Search struct {
+	// Max is the highest level searched.
+	Max int `yaml:"max"`
+	// Passed is the highest passing level, 0 if none passed.
+	Passed int `yaml:"passed"`
+	// Failed is the lowest failing level, 0 if none failed.
+	Failed int `yaml:"failed"`
+	// Levels are the levels checked, in order.
+	Levels []*Level `yaml:"levels"`
+}
+
+// NewSearch returns a search over the levels 1 to max.
+func NewSearch(max int) *Search {
+
+	return &Search{Max: max}
+}
+
+// Next returns the next level to check, or false once the search is done: the highest passing
+// and lowest failing levels are adjacent, level 1 failed or level Max passed.
+func (s *Search) Next() (int, bool) {
+
+	switch {
+	case s.Failed == 0 && s.Passed >= s.Max:
+		return 0, false
+	case s.Failed == 0:
+		next := 2 * s.Passed
+		if next == 0 {
+			next = 1
+		}
+		if next > s.Max {
+			next = s.Max
+		}
+		return next, true
+	case s.Failed-s.Passed <= 1:
+		return 0, false
+	default:
+		return (s.Passed + s.Failed) / 2, true
+	}
+}
+
+// Record records the health report of level.
+func (s *Search) Record(level, enforcers int, r *Report) {
+
+	s.Levels = append(s.Levels, &Level{Level: level, Enforcers: enforcers, Report: r})
+
+	switch {
+	case r.Healthy && level > s.Passed:
+		s.Passed = level
+	case !r.Healthy && (s.Failed == 0 || level < s.Failed):
+		s.Failed = level
+	}
+}
+
+// level returns the last record of level, if any.
+func (s *Search) level(level int) *Level {
+
+	for i := len(s.Levels) - 1; i >= 0; i-- {
+		if s.Levels[i].Level == level {
+			return s.Levels[i]
+		}
+	}
+
+	return nil
+}
+
+// Summary returns a human readable summary of s: the highest passing and the first failing
+// levels, with the evidence of every level checked.
+func (s *Search) Summary() string {
+
+	var b strings.Builder
+
+	describe := func(what string, level int) {
+		l := s.level(level)
+		if l == nil {
+			fmt.Fprintf(&b, "%s: none\n", what)
+			return
+		}
+		fmt.Fprintf(&b, "%s: level %d, %d enforcers", what, level, l.Enforcers)
+		if len(l.Report.Violations) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(l.Report.Violations, ", "))
+		}
+		b.WriteString("\n")
+	}
+	describe("Highest passing", s.Passed)
+	describe("First failing", s.Failed)
+
+	b.WriteString("\n")
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LEVEL\tENFORCERS\tRESULT\tCONNECTED\tERRORS\tLATENCY\tFLOW LAG\tFAILED PODS")
+	for _, l := range s.Levels {
+		result := "pass"
+		if !l.Report.Healthy {
+			result = "fail"
+		}
+		sig := l.Report.Signals
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d/%d\t%.2f%%\t%v\t%v\t%d\n", l.Level, l.Enforcers, result,
+			sig.Connected, l.Enforcers, 100*sig.ErrorRate, sig.Latency, sig.FlowLag,
+			len(sig.FailedPods))
+	}
+	w.Flush()
+
+	return b.String()
+}
@@ -0,0 +1,232 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"go.aporeto.io/simulator-test-harness/common"
+)
+
+// A Split is how the enforcers of a multi-backend run are deployed against its backends.
+type Split string
+
+const (
+	// SplitEven splits the pods of the enforcers evenly between the backends.
+	SplitEven Split = "even"
+	// SplitMirror deploys all the enforcers against every backend, i.e. the same plan.
+	SplitMirror Split = "mirror"
+)
+
+// Splits are all the supported splits.
+var Splits = []Split{SplitEven, SplitMirror}
+
+// SplitConfigs returns the configurations of the runs of c against n backends.
+func SplitConfigs(c Config, split Split, n int) ([]Config, error) {
+
+	if n < 1 {
+		return nil, fmt.Errorf("no backend")
+	}
+	if c.Simulators < 1 {
+		return nil, fmt.Errorf("no simulators per pod")
+	}
+
+	configs := make([]Config, n)
+	switch split {
+	case SplitMirror:
+		for i := range configs {
+			configs[i] = c
+		}
+	case SplitEven:
+		// Split whole pods, a run deploying its enforcers by pods anyway.
+		pods := (c.Enforcers + c.Simulators - 1) / c.Simulators
+		if pods < n {
+			return nil, fmt.Errorf("%d pods cannot be split between %d backends", pods, n)
+		}
+		for i := range configs {
+			configs[i] = c
+			configs[i].Enforcers = pods / n * c.Simulators
+			if i < pods%n {
+				configs[i].Enforcers += c.Simulators
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown split %q, expected one of %v", split, Splits)
+	}
+
+	return configs, nil
+}
+
+// A Leg is the run of a multi-backend run against one of its backends.
+type Leg struct {
+	// Backend is the name of the backend.
+	Backend string
+	Runner  *Runner
+	State   *State
+}
+
+// RunAll runs, or resumes, the runs of legs concurrently until all of them are done, and returns
+// the errors of the failed runs. The runs are independent: a run failing (e.g. because its
+// backend reached its breaking point) does not stop the others.
+func RunAll(ctx context.Context, legs []*Leg) error {
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(legs))
+	for i, l := range legs {
+		wg.Add(1)
+		go func(i int, l *Leg) {
+			defer wg.Done()
+			common.Log.Infof("Running %s against backend %s", l.State.RunID, l.Backend)
+			if err := l.Runner.Run(ctx, l.State); err != nil {
+				errs[i] = fmt.Errorf("backend %s: %v", l.Backend, err)
+			}
+		}(i, l)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Compare returns the side-by-side report of the runs of legs: one column per backend, with the
+// progress of its run, the worst health signals of its batches and its enforcer distribution.
+func Compare(legs []*Leg) string {
+
+	var b strings.Builder
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	row := func(name string, value func(s *State) string) {
+		cells := []string{name}
+		for _, l := range legs {
+			cells = append(cells, value(l.State))
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+
+	header := []string{"BACKEND"}
+	for _, l := range legs {
+		header = append(header, l.Backend)
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	row("Run", func(s *State) string { return s.RunID })
+	row("Status", status)
+	row("Enforcers", func(s *State) string {
+		return fmt.Sprintf("%d/%d", s.Deployed(), s.Config.Enforcers)
+	})
+	row("Batches", func(s *State) string {
+		return fmt.Sprintf("%d (%d shrunk)", s.CompletedBatches(), s.shrunkBatches())
+	})
+	row("Duration", func(s *State) string {
+		if s.End == nil {
+			return "-"
+		}
+		return s.End.Sub(s.Start).Round(time.Second).String()
+	})
+	row("Unhealthy checks", func(s *State) string {
+		return fmt.Sprintf("%d", s.worst().unhealthy)
+	})
+	row("Min connected", func(s *State) string {
+		wst := s.worst()
+		if wst.checked == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", 100*wst.connected)
+	})
+	row("Max API errors", func(s *State) string {
+		return fmt.Sprintf("%.2f%%", 100*s.worst().errorRate)
+	})
+	row("Max API latency", func(s *State) string { return s.worst().latency.String() })
+	row("Max flow lag", func(s *State) string { return s.worst().flowLag.String() })
+	row("Max failed pods", func(s *State) string {
+		return fmt.Sprintf("%d", s.worst().failedPods)
+	})
+	row("Distribution", func(s *State) string {
+		switch d := s.Distribution; {
+		case d == nil:
+			return "-"
+		case d.Balanced():
+			return "balanced"
+		default:
+			return fmt.Sprintf("%d misplaced, %d unmapped", d.Misplaced, d.Unmapped)
+		}
+	})
+	w.Flush()
+
+	return b.String()
+}
+
+// status returns the status of the run of s.
+func status(s *State) string {
+
+	switch {
+	case s.End != nil && s.Deployed() >= s.Config.Enforcers:
+		return "completed"
+	case s.End != nil:
+		return "ended"
+	default:
+		return "stopped"
+	}
+}
+
+// shrunkBatches returns the number of completed batches smaller than the batch size.
+func (s *State) shrunkBatches() int {
+
+	shrunk := 0
+	for _, b := range s.Batches {
+		if b.Status == BatchCompleted && b.Pods < s.Config.Pods {
+			shrunk++
+		}
+	}
+
+	return shrunk
+}
+
+// A worstSignals is the worst health signals of the batches of a run.
+type worstSignals struct {
+	checked    int
+	unhealthy  int
+	connected  float64
+	errorRate  float64
+	latency    time.Duration
+	flowLag    time.Duration
+	failedPods int
+}
+
+// worst returns the worst health signals of the checked batches of s.
+func (s *State) worst() worstSignals {
+
+	wst := worstSignals{connected: 1}
+	for _, b := range s.Batches {
+		if b.Health == nil {
+			continue
+		}
+		wst.checked++
+		if !b.Health.Healthy {
+			wst.unhealthy++
+		}
+
+		sig := b.Health.Signals
+		if expected := b.Pods * s.Config.Simulators; expected > 0 {
+			if c := float64(sig.Connected) / float64(expected); c < wst.connected {
+				wst.connected = c
+			}
+		}
+		if sig.ErrorRate > wst.errorRate {
+			wst.errorRate = sig.ErrorRate
+		}
+		if sig.Latency > wst.latency {
+			wst.latency = sig.Latency
+		}
+		if sig.FlowLag > wst.flowLag {
+			wst.flowLag = sig.FlowLag
+		}
+		if len(sig.FailedPods) > wst.failedPods {
+			wst.failedPods = len(sig.FailedPods)
+		}
+	}
+
+	return wst
+}
@@ -0,0 +1,496 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/simulator-test-harness/common"
+	"go.aporeto.io/simulator-test-harness/libs/chart"
+	"go.aporeto.io/simulator-test-harness/libs/k8s"
+	"go.aporeto.io/simulator-test-harness/libs/rollout"
+	"go.aporeto.io/simulator-test-harness/libs/tagging"
+)
+
+const (
+	// SecretName is the name of the secret holding the enforcer application credentials.
+	SecretName = "enforcerd"
+	// ImagePullSecretName is the name of the secret used for pulling the images.
+	ImagePullSecretName = "apo-secret"
+)
+
+// A Backend is the control plane operations of a run.
+type Backend interface {
+	// Prepare creates namespace and its namespaces child namespaces, applies the template with
+	// prefix to the leaf namespaces if set, creates the host services hss mapped to the enforcers,
+	// and returns the namespaces created. The mapping policies of the enforcers are created batch
+	// by batch with Map.
+	Prepare(namespace string, namespaces int, template, prefix string,
+		hss []*gaia.HostService) ([]string, error)
+	// CreateAppCred creates the enforcer application credential name in namespace, and returns
+	// the content of its credentials file.
+	CreateAppCred(name, namespace string) ([]byte, error)
+	// Enforcers returns the enforcers tagged with tag registered in namespace or its children.
+	Enforcers(namespace, tag string) ([]tagging.Registration, error)
+	// AttachProfile creates the enforcer profile name in namespace, with the settings of the
+	// built-in profile or profile file profile, mapped to the enforcers tagged with tag.
+	AttachProfile(namespace, name, profile, tag string) error
+	// Map creates the mapping policies of mappings from namespace towards its child namespaces.
+	Map(namespace string, mappings []tagging.Mapping) error
+	// Unmap deletes the mapping policies of the enforcers tagged with tags from namespace.
+	Unmap(namespace string, tags []string) error
+}
+
+// A Runner runs the batches of a run, persisting its state to StateFile after every step. If
+// Probes is set, every batch is gated on the health of the previous one.
+type Runner struct {
+	Backend   Backend
+	K8s       *k8s.Client
+	Probes    *rollout.Probes
+	StateFile string
+}
+
+// An UnhealthyError is the error of a run stopped because a batch of the minimum size is
+// unhealthy, i.e. the breaking point of the backend is reached.
+type UnhealthyError struct {
+	Batch    int
+	Deployed int
+	Report   *rollout.Report
+}
+
+// Error implements error.
+func (e *UnhealthyError) Error() string {
+
+	return fmt.Sprintf("batch %d unhealthy at the minimum batch size with %d enforcers "+
+		"deployed: %s", e.Batch, e.Deployed, strings.Join(e.Report.Violations, ", "))
+}
+
+// randomID returns a random identifier usable in kubernetes names.
+func randomID(length uint) string {
+
+	return strings.ToLower(common.RandomString(length))
+}
+
+// NewState returns the state of a new run of c, with a random run ID and prefixes.
+func NewState(c Config) (*State, error) {
+
+	if c.Rollout == (rollout.Config{}) {
+		c.Rollout = rollout.DefaultConfig()
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+	if c.Capacity == 0 {
+		c.Capacity = c.BatchSize()
+	}
+	if c.Mapping == "" {
+		c.Mapping = tagging.DefaultStrategy(c.BatchSize(), c.Capacity)
+	}
+
+	id := randomID(5)
+	s := &State{
+		RunID:     id,
+		Config:    c,
+		Start:     time.Now(),
+		Namespace: c.Prefix + "-" + id,
+		TagPrefix: randomID(5),
+		Secret:    SecretName,
+	}
+
+	// Fail before touching anything if the batches cannot be rendered or mapped.
+	if _, err := chart.Render(s.values(s.newBatch(1, c.Pods))); err != nil {
+		return nil, fmt.Errorf("render batch: %v", err)
+	}
+	if c.PrepareBackend {
+		if _, _, err := s.Scheme().Mappings(c.Plan()); err != nil {
+			return nil, fmt.Errorf("map enforcers: %v", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Scheme returns the tagging and mapping scheme of the enforcers of s.
+func (s *State) Scheme() tagging.Scheme {
+
+	return tagging.Scheme{Strategy: s.Config.Mapping, Prefix: s.TagPrefix}
+}
+
+// childNamespaces returns the number of child namespaces of the run namespace of s, i.e. the
+// namespaces targeted by the mappings of its plan.
+func (s *State) childNamespaces() (int, error) {
+
+	_, children, err := s.Scheme().Mappings(s.Config.Plan())
+
+	return children, err
+}
+
+// newBatch returns the batch index of pods, with a random deployment name.
+func (s *State) newBatch(index, pods int) *Batch {
+
+	scheme := s.Scheme()
+	b := &Batch{
+		Index:             index,
+		Deployment:        fmt.Sprintf("%s-pods-%s", s.Namespace, randomID(6)),
+		Pods:              pods,
+		EnforcerTagPrefix: scheme.SimulatorPrefix(index),
+		EnforcerTag:       scheme.BatchTag(index),
+		Status:            BatchApplying,
+		Updated:           time.Now(),
+	}
+	if profiles := s.Config.EnforcerProfiles; len(profiles) > 0 {
+		b.Profile = profiles[(index-1)%len(profiles)]
+	}
+
+	return b
+}
+
+// nextPods returns the number of pods of the batch following last: the batch size of the
+// configuration after a batch without health report, shrunk or grown back according to the health
+// of last otherwise, and at most the pods needed to deploy the remaining enforcers.
+func (s *State) nextPods(last *Batch) int {
+
+	pods := s.Config.Pods
+	if last != nil && last.Health != nil {
+		pods = s.Config.Rollout.NextPods(last.Pods, s.Config.Pods, last.Health.Healthy)
+	}
+
+	remaining := s.Config.Enforcers - s.Deployed()
+	if needed := (remaining + s.Config.Simulators - 1) / s.Config.Simulators; pods > needed {
+		pods = needed
+	}
+
+	return pods
+}
+
+// values returns the chart values of b.
+func (s *State) values(b *Batch) *chart.Values {
+
+	v := s.Config.Values
+	v.Pods = b.Pods
+	v.SimulatorsPerPod = s.Config.Simulators
+	v.DepName = b.Deployment
+	v.K8sNS = s.Namespace
+	v.K8sSecret = s.Secret
+	v.EnforcerTagPrefix = b.EnforcerTagPrefix
+	v.EnforcerTag = b.EnforcerTag
+	v.PodTagPrefix = ""
+	if s.Config.Mapping == tagging.StrategyPerPod {
+		v.PodTagPrefix = b.EnforcerTagPrefix
+	}
+
+	return &v
+}
+
+// Run runs, or resumes, the run of s until all its batches are completed. The run of a search
+// state is resumed as a search.
+func (r *Runner) Run(ctx context.Context, s *State) error {
+
+	if s.Search != nil {
+		return r.Search(ctx, s)
+	}
+	if s.End != nil {
+		common.Log.Infof("Run %s already completed at %v", s.RunID, *s.End)
+		return nil
+	}
+
+	namespace, err := r.setup(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var last *Batch
+		if n := len(s.Batches); n > 0 {
+			last = s.Batches[n-1]
+		}
+
+		if last != nil && last.Status == BatchCompleted {
+			if err := r.gate(ctx, s, namespace, last); err != nil {
+				return err
+			}
+		}
+		if s.Deployed() >= s.Config.Enforcers {
+			break
+		}
+
+		if last == nil || last.Status == BatchCompleted {
+			last = s.newBatch(len(s.Batches)+1, s.nextPods(last))
+			s.Batches = append(s.Batches, last)
+			if err := r.save(s); err != nil {
+				return err
+			}
+		}
+
+		if err := r.runBatch(ctx, s, last); err != nil {
+			return fmt.Errorf("batch %d: %v", last.Index, err)
+		}
+	}
+
+	end := time.Now()
+	s.End = &end
+	if err := r.save(s); err != nil {
+		return err
+	}
+
+	common.Log.Infof("Run %s completed in %v", s.RunID, end.Sub(s.Start))
+	if s.Config.PrepareBackend {
+		if _, err := r.Verify(s, s.Config.Rebalance); err != nil {
+			return fmt.Errorf("verify distribution: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// setup persists s, prepares the backend and applies the secrets of s unless already done, and
+// returns the Aporeto namespace of the run.
+func (r *Runner) setup(ctx context.Context, s *State) (string, error) {
+
+	// Persist the state first, so that the prefixes are never lost.
+	if err := r.save(s); err != nil {
+		return "", err
+	}
+
+	namespace := path.Join(s.Config.Namespace, s.Namespace)
+	common.Log.Infof("Running %s on %s: %d batches completed, %d/%d enforcers deployed",
+		s.RunID, namespace, s.CompletedBatches(), s.Deployed(), s.Config.Enforcers)
+
+	if !s.Prepared {
+		if s.Config.PrepareBackend {
+			var hss []*gaia.HostService
+			for _, hs := range s.Config.Values.HostServices {
+				hss = append(hss, hs.Object())
+			}
+			children, err := s.childNamespaces()
+			if err != nil {
+				return "", fmt.Errorf("map enforcers: %v", err)
+			}
+			namespaces, err := r.Backend.Prepare(namespace, children, s.Config.Template,
+				s.Config.TemplatePrefix, hss)
+			if err != nil {
+				return "", fmt.Errorf("prepare backend: %v", err)
+			}
+			s.Namespaces = namespaces
+		}
+		s.Prepared = true
+		if err := r.save(s); err != nil {
+			return "", err
+		}
+	}
+
+	if !s.SecretApplied {
+		if err := r.applySecrets(ctx, s, namespace); err != nil {
+			return "", err
+		}
+		s.SecretApplied = true
+		if err := r.save(s); err != nil {
+			return "", err
+		}
+	}
+
+	return namespace, nil
+}
+
+// applySecrets creates the kubernetes namespace of s, the enforcer application credential and its
+// secret, and the image pull secret if any.
+// NOTE: If interrupted after the application credential creation, the run creates another one when
+// resumed, as the credentials are only persisted in the secret.
+func (r *Runner) applySecrets(ctx context.Context, s *State, namespace string) error {
+
+	labels := map[string]string{"simulator-run": s.RunID}
+	if err := r.K8s.CreateNamespace(ctx, s.Namespace, labels); err != nil {
+		return err
+	}
+
+	creds, err := r.Backend.CreateAppCred(s.Secret, namespace)
+	if err != nil {
+		return fmt.Errorf("create enforcer appcred: %v", err)
+	}
+	s.AppCreds = append(s.AppCreds, path.Join(namespace, s.Secret))
+
+	if err := r.K8s.ApplyCredsSecret(ctx, s.Namespace, s.Secret, creds); err != nil {
+		return err
+	}
+
+	if s.Config.ImagePullSecret == "" {
+		return nil
+	}
+
+	dockerConfig, err := os.ReadFile(s.Config.ImagePullSecret)
+	if err != nil {
+		return fmt.Errorf("read %s: %v", s.Config.ImagePullSecret, err)
+	}
+	if err := r.K8s.ApplyImagePullSecret(ctx, s.Namespace, ImagePullSecretName,
+		dockerConfig); err != nil {
+		return err
+	}
+
+	return r.K8s.PatchServiceAccountPullSecret(ctx, s.Namespace, "default", ImagePullSecretName)
+}
+
+// runBatch maps the enforcers of b and applies its deployment, unless already done, and waits for
+// its rollout.
+func (r *Runner) runBatch(ctx context.Context, s *State, b *Batch) error {
+
+	namespace := path.Join(s.Config.Namespace, s.Namespace)
+	if s.Config.PrepareBackend {
+		if err := r.mapBatch(s, namespace, b); err != nil {
+			return fmt.Errorf("map enforcers of batch %d: %v", b.Index, err)
+		}
+	}
+
+	if b.Profile != "" && !b.ProfileAttached {
+		if err := r.Backend.AttachProfile(namespace, b.Deployment, b.Profile,
+			b.EnforcerTag); err != nil {
+			return fmt.Errorf("attach enforcer profile of batch %d: %v", b.Index, err)
+		}
+		b.ProfileAttached = true
+		if err := r.save(s); err != nil {
+			return err
+		}
+	}
+
+	if b.Status == BatchApplying {
+		exists, err := r.K8s.DeploymentExists(ctx, s.Namespace, b.Deployment)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			common.Log.Infof("Deployment %s of batch %d already applied", b.Deployment, b.Index)
+		} else {
+			m, err := chart.Render(s.values(b))
+			if err != nil {
+				return fmt.Errorf("render: %v", err)
+			}
+			if err := chart.Apply(ctx, r.K8s.Clientset(), m); err != nil {
+				return err
+			}
+		}
+
+		b.Status, b.Updated = BatchApplied, time.Now()
+		if err := r.save(s); err != nil {
+			return err
+		}
+	}
+
+	if b.Status == BatchApplied {
+		// The pods only start their simulators once labelled with their slot, if tagged per pod.
+		if slots := s.Scheme().PodSlots(b.Index, b.Pods); slots != nil {
+			if err := r.K8s.AssignPodSlots(ctx, s.Namespace, s.values(b).Selector(),
+				tagging.PodTagKey, slots); err != nil {
+				return err
+			}
+		}
+		if err := r.K8s.RolloutStatus(ctx, s.Namespace, b.Deployment); err != nil {
+			return err
+		}
+
+		b.Status, b.Updated = BatchCompleted, time.Now()
+		if err := r.save(s); err != nil {
+			return err
+		}
+	}
+
+	common.Log.Infof("Batch %d completed, %d/%d enforcers deployed", b.Index, s.Deployed(),
+		s.Config.Enforcers)
+	return nil
+}
+
+// mapBatch creates the mapping policies of the enforcers of b from namespace towards its child
+// namespaces, placed after the mappings of the previous batches according to the actual pods of b,
+// and records them in s. The tags already mapped, e.g. of a batch created again by a search, are
+// not mapped again.
+func (r *Runner) mapBatch(s *State, namespace string, b *Batch) error {
+
+	children, err := s.childNamespaces()
+	if err != nil {
+		return err
+	}
+
+	load := make([]int, children)
+	mapped := map[string]bool{}
+	for _, m := range s.Mappings {
+		load[m.Namespace] += m.Enforcers
+		mapped[m.Tag] = true
+	}
+
+	previous := append([]int(nil), load...)
+	mappings, err := s.Scheme().BatchMappings(b.Index, b.Pods, s.Config.Simulators,
+		s.Config.Capacity, load)
+	if err != nil {
+		return err
+	}
+
+	var missing []tagging.Mapping
+	for _, m := range mappings {
+		if !mapped[m.Tag] {
+			missing = append(missing, m)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	for i, l := range load {
+		if l > s.Config.Capacity && l > previous[i] {
+			common.Log.Warnf("Batch %d maps %d enforcers to namespace %d, over its capacity %d",
+				b.Index, l, i, s.Config.Capacity)
+		}
+	}
+
+	if err := r.Backend.Map(namespace, missing); err != nil {
+		return err
+	}
+	s.Mappings = append(s.Mappings, missing...)
+
+	return r.save(s)
+}
+
+// gate checks the health of the completed batch b, unless already checked. It fails with an
+// *UnhealthyError if b is unhealthy at the minimum batch size, in which case b is checked again
+// when the run is resumed.
+func (r *Runner) gate(ctx context.Context, s *State, namespace string, b *Batch) error {
+
+	minPods := s.Config.Rollout.MinPods
+	if r.Probes == nil || (b.Health != nil && (b.Health.Healthy || b.Pods > minPods)) {
+		return nil
+	}
+
+	target := rollout.Target{
+		Name:         fmt.Sprintf("Batch %d", b.Index),
+		Namespace:    namespace,
+		Tags:         []string{b.EnforcerTag},
+		Enforcers:    b.Pods * s.Config.Simulators,
+		K8sNamespace: s.Namespace,
+	}
+	report, err := rollout.Wait(ctx, &s.Config.Rollout, r.Probes, target)
+	if err != nil {
+		return fmt.Errorf("batch %d health: %v", b.Index, err)
+	}
+
+	b.Health = report
+	if err := r.save(s); err != nil {
+		return err
+	}
+
+	if !report.Healthy && b.Pods <= minPods {
+		return &UnhealthyError{Batch: b.Index, Deployed: s.Deployed(), Report: report}
+	}
+
+	return nil
+}
+
+// save persists s to the state file of r.
+func (r *Runner) save(s *State) error {
+
+	if err := s.Save(r.StateFile); err != nil {
+		return fmt.Errorf("save run state: %v", err)
+	}
+
+	return nil
+}
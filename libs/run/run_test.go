@@ -0,0 +1,710 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/simulator-test-harness/libs/chart"
+	"go.aporeto.io/simulator-test-harness/libs/k8s"
+	"go.aporeto.io/simulator-test-harness/libs/rollout"
+	"go.aporeto.io/simulator-test-harness/libs/tagging"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type fakeBackend struct {
+	prepared int
+	appcreds int
+	// registrations are the enforcers registered, by tag.
+	registrations map[string][]tagging.Registration
+	mapped        []tagging.Mapping
+	// profiles are the profiles attached, as <tag>:<profile>.
+	profiles []string
+}
+
+func (b *fakeBackend) Prepare(
+	namespace string,
+	namespaces int,
+	template, prefix string,
+	hss []*gaia.HostService,
+) ([]string, error) {
+
+	b.prepared++
+	return []string{namespace}, nil
+}
+
+func (b *fakeBackend) CreateAppCred(name, namespace string) ([]byte, error) {
+
+	b.appcreds++
+	return []byte("{}"), nil
+}
+
+func (b *fakeBackend) Enforcers(namespace, tag string) ([]tagging.Registration, error) {
+
+	return b.registrations[tag], nil
+}
+
+func (b *fakeBackend) AttachProfile(namespace, name, profile, tag string) error {
+
+	b.profiles = append(b.profiles, tag+":"+profile)
+	return nil
+}
+
+func (b *fakeBackend) Map(namespace string, mappings []tagging.Mapping) error {
+
+	b.mapped = append(b.mapped, mappings...)
+	return nil
+}
+
+func (b *fakeBackend) Unmap(namespace string, tags []string) error {
+
+	unmapped := map[string]bool{}
+	for _, tag := range tags {
+		unmapped[tag] = true
+	}
+
+	var mapped []tagging.Mapping
+	for _, m := range b.mapped {
+		if !unmapped[m.Tag] {
+			mapped = append(mapped, m)
+		}
+	}
+	b.mapped = mapped
+	return nil
+}
+
+// fakeCluster returns a fake clientset rolling out the created deployments, unless stall returns
+// true for them.
+func fakeCluster(stall func(name string) bool) *fake.Clientset {
+
+	cs := fake.NewSimpleClientset()
+	cs.PrependReactor("create", "deployments",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			dep := action.(k8stesting.CreateAction).GetObject().(*appsv1.Deployment)
+			if !stall(dep.Name) {
+				rollOut(dep)
+			}
+			return false, nil, nil
+		},
+	)
+
+	return cs
+}
+
+func rollOut(dep *appsv1.Deployment) {
+
+	r := *dep.Spec.Replicas
+	dep.Status = appsv1.DeploymentStatus{Replicas: r, UpdatedReplicas: r, AvailableReplicas: r}
+}
+
+func deploymentCreations(cs *fake.Clientset) int {
+
+	count := 0
+	for _, action := range cs.Actions() {
+		if action.Matches("create", "deployments") {
+			count++
+		}
+	}
+
+	return count
+}
+
+func testConfig() Config {
+
+	v := chart.DefaultValues()
+	v.Image = chart.Image{Name: "enforcerd", Tag: "test"}
+	v.SimulatorImage = chart.Image{Name: "plan-gen", Tag: "test"}
+
+	return Config{
+		Namespace:      "/base",
+		Prefix:         "simulator",
+		Enforcers:      25,
+		Pods:           2,
+		Simulators:     5,
+		PrepareBackend: true,
+		Values:         *v,
+	}
+}
+
+func TestRunAndResume(t *testing.T) {
+
+	ctx := context.Background()
+	stateFile := filepath.Join(t.TempDir(), "state.yaml")
+	backend := &fakeBackend{}
+
+	c := testConfig()
+	c.EnforcerProfiles = []string{"minimal", "large"}
+	s, err := NewState(c)
+	if err != nil {
+		t.Fatalf("NewState() error = %v", err)
+	}
+	if s.Config.Batches() != 3 || s.Config.Capacity != 10 {
+		t.Fatalf("got %d batches and capacity %d", s.Config.Batches(), s.Config.Capacity)
+	}
+
+	// The second batch never rolls out, which interrupts the run.
+	stalled, created := true, 0
+	cs := fakeCluster(func(name string) bool {
+		created++
+		return stalled && created == 2
+	})
+	kc := k8s.NewClient(cs, k8s.OptionPollInterval(time.Millisecond),
+		k8s.OptionWaitTimeout(20*time.Millisecond))
+	r := &Runner{Backend: backend, K8s: kc, StateFile: stateFile}
+	if err := r.Run(ctx, s); err == nil {
+		t.Fatalf("Run() succeeded with a stalled batch")
+	}
+
+	saved, err := LoadState(stateFile)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if saved.CompletedBatches() != 1 || saved.Batches[1].Status != BatchApplied {
+		t.Fatalf("unexpected batches after interruption: %+v %+v", *saved.Batches[0],
+			*saved.Batches[1])
+	}
+
+	// Once rolled out, resuming must not apply the second batch again.
+	stalled = false
+	deps := cs.AppsV1().Deployments(saved.Namespace)
+	dep, err := deps.Get(ctx, saved.Batches[1].Deployment, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollOut(dep)
+	if _, err := deps.UpdateStatus(ctx, dep, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Run(ctx, saved); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	resumed, err := LoadState(stateFile)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if resumed.End == nil || resumed.CompletedBatches() != 3 {
+		t.Errorf("run not completed: %+v", resumed)
+	}
+	if resumed.TagPrefix != s.TagPrefix || resumed.Namespace != s.Namespace {
+		t.Errorf("prefixes changed on resume")
+	}
+	if got := deploymentCreations(cs); got != 3 {
+		t.Errorf("got %d deployment creations, want 3", got)
+	}
+	if backend.prepared != 1 || backend.appcreds != 1 {
+		t.Errorf("backend prepared %d times, %d appcreds created", backend.prepared,
+			backend.appcreds)
+	}
+	wantProfiles := []string{
+		s.Scheme().BatchTag(1) + ":minimal",
+		s.Scheme().BatchTag(2) + ":large",
+		s.Scheme().BatchTag(3) + ":minimal",
+	}
+	if !reflect.DeepEqual(backend.profiles, wantProfiles) {
+		t.Errorf("got profiles %v, want %v", backend.profiles, wantProfiles)
+	}
+
+	// A batch interrupted while being applied is not applied again if its deployment exists.
+	resumed.End = nil
+	resumed.Batches[2].Status = BatchApplying
+	if err := r.Run(ctx, resumed); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := deploymentCreations(cs); got != 3 {
+		t.Errorf("got %d deployment creations, want 3", got)
+	}
+}
+
+func TestRunPerPod(t *testing.T) {
+
+	ctx := context.Background()
+
+	c := testConfig()
+	c.Mapping = tagging.StrategyPerPod
+	s, err := NewState(c)
+	if err != nil {
+		t.Fatalf("NewState() error = %v", err)
+	}
+
+	// The pods of the 3 batches are created unassigned, as by their deployments.
+	cs := fakeCluster(func(string) bool { return false })
+	scheme := s.Scheme()
+	for i, pods := range []int{2, 2, 1} {
+		v := s.values(s.newBatch(i+1, pods))
+		for p := 0; p < pods; p++ {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: fmt.Sprintf("pod-%d-%d", i+1, p),
+					Labels: map[string]string{
+						"nsim":            "simulator-" + v.EnforcerTagPrefix,
+						"app":             "simulator",
+						tagging.PodTagKey: v.PodTagPrefix + "-" + chart.PodSlotUnassigned,
+					},
+				},
+				Status: corev1.PodStatus{Phase: corev1.PodPending},
+			}
+			if _, err := cs.CoreV1().Pods(s.Namespace).Create(ctx, pod,
+				metav1.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	r := &Runner{
+		Backend:   &fakeBackend{},
+		K8s:       k8s.NewClient(cs, k8s.OptionPollInterval(time.Millisecond)),
+		StateFile: filepath.Join(t.TempDir(), "state.yaml"),
+	}
+	if err := r.Run(ctx, s); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	pods, err := cs.CoreV1().Pods(s.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, pod := range pods.Items {
+		got[pod.Name] = pod.Labels[tagging.PodTagKey]
+	}
+	want := map[string]string{
+		"pod-1-0": scheme.PodSlot(1, 0),
+		"pod-1-1": scheme.PodSlot(1, 1),
+		"pod-2-0": scheme.PodSlot(2, 0),
+		"pod-2-1": scheme.PodSlot(2, 1),
+		"pod-3-0": scheme.PodSlot(3, 0),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pod slots = %v, want %v", got, want)
+	}
+}
+
+// An unhealthyEnforcers is an EnforcerCounter reporting the enforcers with a tag suffix in it as
+// disconnected.
+type unhealthyEnforcers []string
+
+func (u unhealthyEnforcers) CountEnforcers(
+	ctx context.Context,
+	namespace, tag string,
+) (int, int, error) {
+
+	for _, suffix := range u {
+		if strings.HasSuffix(tag, suffix) {
+			return 5, 0, nil
+		}
+	}
+
+	return 5, 5, nil
+}
+
+func TestRunHealthGate(t *testing.T) {
+
+	ctx := context.Background()
+	stateFile := filepath.Join(t.TempDir(), "state.yaml")
+
+	c := testConfig()
+	c.Enforcers = 50
+	c.Rollout = rollout.DefaultConfig()
+	c.Rollout.Checks, c.Rollout.Backoff, c.Rollout.MinConnected = 1, 0, 0.5
+	s, err := NewState(c)
+	if err != nil {
+		t.Fatalf("NewState() error = %v", err)
+	}
+
+	backend := &fakeBackend{}
+	kc := k8s.NewClient(fakeCluster(func(string) bool { return false }),
+		k8s.OptionPollInterval(time.Millisecond))
+	r := &Runner{
+		Backend:   backend,
+		K8s:       kc,
+		Probes:    &rollout.Probes{Enforcers: unhealthyEnforcers{"-2", "-3"}},
+		StateFile: stateFile,
+	}
+
+	// Batch 2 is unhealthy, so batch 3 is shrunk to 1 pod. Batch 3 is unhealthy at the minimum
+	// size, which stops the run.
+	err = r.Run(ctx, s)
+	var uerr *UnhealthyError
+	if !errors.As(err, &uerr) || uerr.Batch != 3 || uerr.Deployed != 25 {
+		t.Fatalf("Run() error = %v, want batch 3 unhealthy with 25 enforcers", err)
+	}
+
+	var pods []int
+	for _, b := range s.Batches {
+		pods = append(pods, b.Pods)
+	}
+	if want := []int{2, 2, 1}; !reflect.DeepEqual(pods, want) {
+		t.Errorf("got batches of %v pods, want %v", pods, want)
+	}
+
+	// Once the backend recovers, the batch is checked again and the size grows back.
+	r.Probes.Enforcers = unhealthyEnforcers{}
+	if err := r.Run(ctx, s); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	pods = nil
+	for _, b := range s.Batches {
+		pods = append(pods, b.Pods)
+	}
+	if want := []int{2, 2, 1, 2, 2, 1}; !reflect.DeepEqual(pods, want) {
+		t.Errorf("got batches of %v pods, want %v", pods, want)
+	}
+	if s.End == nil || s.Deployed() != 50 {
+		t.Errorf("run not completed, %d enforcers deployed", s.Deployed())
+	}
+
+	// Every batch is mapped once, with its actual size: the shrunk batches 3 and 6 share the
+	// namespace left by the 5 planned batches.
+	scheme := s.Scheme()
+	want := []tagging.Mapping{
+		{Tag: scheme.BatchTag(1), Namespace: 0, Enforcers: 10},
+		{Tag: scheme.BatchTag(2), Namespace: 1, Enforcers: 10},
+		{Tag: scheme.BatchTag(3), Namespace: 2, Enforcers: 5},
+		{Tag: scheme.BatchTag(4), Namespace: 3, Enforcers: 10},
+		{Tag: scheme.BatchTag(5), Namespace: 4, Enforcers: 10},
+		{Tag: scheme.BatchTag(6), Namespace: 2, Enforcers: 5},
+	}
+	if !reflect.DeepEqual(backend.mapped, want) {
+		t.Errorf("got mappings %+v, want %+v", backend.mapped, want)
+	}
+	saved, err := LoadState(stateFile)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if !reflect.DeepEqual(saved.Mappings, want) {
+		t.Errorf("got mappings %+v persisted, want %+v", saved.Mappings, want)
+	}
+}
+
+// A loadLatency is a MetricsSource whose latency grows with the number of enforcers.
+type loadLatency struct{}
+
+func (loadLatency) Metrics(ctx context.Context, t rollout.Target) (float64, time.Duration, error) {
+
+	return 0, time.Duration(t.Enforcers) * time.Millisecond, nil
+}
+
+func TestSearch(t *testing.T) {
+
+	ctx := context.Background()
+	stateFile := filepath.Join(t.TempDir(), "state.yaml")
+
+	c := testConfig()
+	c.Enforcers = 100
+	c.Rollout = rollout.DefaultConfig()
+	c.Rollout.Checks, c.Rollout.Backoff, c.Rollout.MinConnected = 1, 0, 0
+	c.Rollout.MaxLatency = 55 * time.Millisecond
+	s, err := NewSearchState(c)
+	if err != nil {
+		t.Fatalf("NewSearchState() error = %v", err)
+	}
+
+	cs := fakeCluster(func(string) bool { return false })
+	r := &Runner{
+		Backend:   &fakeBackend{},
+		K8s:       k8s.NewClient(cs, k8s.OptionPollInterval(time.Millisecond)),
+		Probes:    &rollout.Probes{Metrics: loadLatency{}},
+		StateFile: stateFile,
+	}
+	if err := r.Run(ctx, s); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	saved, err := LoadState(stateFile)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if saved.End == nil || saved.Search.Passed != 5 || saved.Search.Failed != 6 {
+		t.Fatalf("got search %+v, want 5 passed and 6 failed", saved.Search)
+	}
+
+	var levels []int
+	for _, l := range saved.Search.Levels {
+		levels = append(levels, l.Level)
+	}
+	if want := []int{1, 2, 4, 8, 6, 5}; !reflect.DeepEqual(levels, want) {
+		t.Errorf("searched levels %v, want %v", levels, want)
+	}
+
+	deps, err := cs.AppsV1().Deployments(saved.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps.Items) != 5 || len(saved.Batches) != 5 || saved.Deployed() != 50 {
+		t.Errorf("got %d deployments and %d batches, want 5", len(deps.Items),
+			len(saved.Batches))
+	}
+	if summary := saved.Search.Summary(); !strings.Contains(summary, "Highest passing: level 5") {
+		t.Errorf("unexpected summary:\n%s", summary)
+	}
+}
+
+func TestVerify(t *testing.T) {
+
+	c := testConfig()
+	c.Mapping, c.Capacity = tagging.StrategyPerBatch, 15
+	s, err := NewState(c)
+	if err != nil {
+		t.Fatalf("NewState() error = %v", err)
+	}
+	namespace := path.Join(s.Config.Namespace, s.Namespace)
+	scheme := s.Scheme()
+
+	// Batch 1 lands in its namespace, batch 2 in the namespace of batch 1 where the shrunk batch 3
+	// is mapped too, batch 3 in the namespace of batch 2, and batch 4, whose mapping is missing,
+	// stays in the run namespace.
+	s.Mappings = []tagging.Mapping{
+		{Tag: scheme.BatchTag(1), Namespace: 0, Enforcers: 10},
+		{Tag: scheme.BatchTag(2), Namespace: 1, Enforcers: 10},
+		{Tag: scheme.BatchTag(3), Namespace: 0, Enforcers: 5},
+	}
+	backend := &fakeBackend{
+		registrations: map[string][]tagging.Registration{},
+		mapped:        append([]tagging.Mapping(nil), s.Mappings...),
+	}
+	for i, land := range []int{0, 0, 1, -1} {
+		b := s.newBatch(i+1, 2)
+		if i >= 2 {
+			b.Pods = 1
+		}
+		b.Status = BatchCompleted
+		s.Batches = append(s.Batches, b)
+
+		ns := namespace
+		if land >= 0 {
+			ns = tagging.ChildNamespace(namespace, land)
+		}
+		for j := 0; j < b.Pods*s.Config.Simulators; j++ {
+			backend.registrations[b.EnforcerTag] = append(backend.registrations[b.EnforcerTag],
+				tagging.Registration{Namespace: ns, Tags: scheme.Tags(b.Index, 0, j%5)})
+		}
+	}
+
+	r := &Runner{Backend: backend, StateFile: filepath.Join(t.TempDir(), "state.yaml")}
+	report, err := r.Verify(s, true)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	if report.Balanced() || report.Misplaced != 15 || report.Unmapped != 5 {
+		t.Errorf("got %d misplaced and %d unmapped, want 15 and 5", report.Misplaced,
+			report.Unmapped)
+	}
+	if want := []string{tagging.ChildNamespace(namespace, 0)}; !reflect.DeepEqual(
+		report.Overloaded, want) {
+		t.Errorf("got overloaded namespaces %v, want %v", report.Overloaded, want)
+	}
+
+	// Batch 2 keeps its mapping, as its namespace has room, the mapping of batch 3 is replaced as
+	// its namespace is full, and batch 4 gets a new one: every tag is mapped once.
+	corrections := []tagging.Mapping{
+		{Tag: scheme.BatchTag(3), Namespace: 2, Enforcers: 5},
+		{Tag: scheme.BatchTag(4), Namespace: 2, Enforcers: 5},
+	}
+	if !reflect.DeepEqual(report.Corrections, corrections) || !report.Corrected {
+		t.Errorf("got corrections %+v, want %+v", report.Corrections, corrections)
+	}
+	want := []tagging.Mapping{
+		{Tag: scheme.BatchTag(1), Namespace: 0, Enforcers: 10},
+		{Tag: scheme.BatchTag(2), Namespace: 1, Enforcers: 10},
+		{Tag: scheme.BatchTag(3), Namespace: 2, Enforcers: 5},
+		{Tag: scheme.BatchTag(4), Namespace: 2, Enforcers: 5},
+	}
+	if !reflect.DeepEqual(backend.mapped, want) {
+		t.Errorf("got mappings %+v, want %+v", backend.mapped, want)
+	}
+	if got := s.currentMappings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got current mappings %+v, want %+v", got, want)
+	}
+
+	// The corrections already applied are not applied again, even after a verification without
+	// rebalancing, and the enforcers of the corrected tags are misplaced until restarted.
+	for _, rebalance := range []bool{false, true} {
+		if _, err := r.Verify(s, rebalance); err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+	}
+	if !reflect.DeepEqual(backend.mapped, want) {
+		t.Errorf("got mappings %+v, want %+v", backend.mapped, want)
+	}
+
+	saved, err := LoadState(r.StateFile)
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if saved.Distribution == nil || saved.Distribution.Misplaced != 20 ||
+		saved.Distribution.Unmapped != 0 {
+		t.Errorf("distribution not persisted: %+v", saved.Distribution)
+	}
+	if !reflect.DeepEqual(saved.Rebalanced, corrections) {
+		t.Errorf("got rebalanced %+v, want %+v", saved.Rebalanced, corrections)
+	}
+}
+
+func TestSplitConfigs(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		enforcers int
+		split     Split
+		backends  int
+		want      []int
+		wantErr   bool
+	}{
+		{
+			name:      "even",
+			enforcers: 25,
+			split:     SplitEven,
+			backends:  2,
+			want:      []int{15, 10},
+		},
+		{
+			name:      "mirror",
+			enforcers: 25,
+			split:     SplitMirror,
+			backends:  3,
+			want:      []int{25, 25, 25},
+		},
+		{
+			name:      "fewer pods than backends",
+			enforcers: 5,
+			split:     SplitEven,
+			backends:  2,
+			wantErr:   true,
+		},
+		{
+			name:      "unknown split",
+			enforcers: 25,
+			split:     "random",
+			backends:  2,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			c.Enforcers = tt.enforcers
+			configs, err := SplitConfigs(c, tt.split, tt.backends)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SplitConfigs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var got []int
+			for _, c := range configs {
+				got = append(got, c.Enforcers)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got enforcers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunAll(t *testing.T) {
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	configs, err := SplitConfigs(testConfig(), SplitEven, 2)
+	if err != nil {
+		t.Fatalf("SplitConfigs() error = %v", err)
+	}
+
+	// The batches of the candidate never roll out.
+	var legs []*Leg
+	for i, name := range []string{"release", "candidate"} {
+		s, err := NewState(configs[i])
+		if err != nil {
+			t.Fatalf("NewState() error = %v", err)
+		}
+		stall := i == 1
+		kc := k8s.NewClient(fakeCluster(func(string) bool { return stall }),
+			k8s.OptionPollInterval(time.Millisecond), k8s.OptionWaitTimeout(20*time.Millisecond))
+		legs = append(legs, &Leg{
+			Backend: name,
+			Runner: &Runner{Backend: &fakeBackend{}, K8s: kc,
+				StateFile: filepath.Join(dir, name+".yaml")},
+			State: s,
+		})
+	}
+
+	err = RunAll(ctx, legs)
+	if err == nil || !strings.Contains(err.Error(), "backend candidate") ||
+		strings.Contains(err.Error(), "backend release") {
+		t.Fatalf("RunAll() error = %v, want the candidate run failed", err)
+	}
+	if legs[0].State.End == nil || legs[0].State.Deployed() != 15 {
+		t.Errorf("release run not completed, %d enforcers deployed", legs[0].State.Deployed())
+	}
+
+	report := Compare(legs)
+	for _, want := range []string{
+		"BACKEND           release       candidate",
+		"Status            completed     stopped",
+		"Enforcers         15/15         0/10",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report does not contain %q:\n%s", want, report)
+		}
+	}
+}
+
+func TestNewState(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		config  func(c *Config)
+		wantErr bool
+	}{
+		{
+			name:   "valid",
+			config: func(c *Config) {},
+		},
+		{
+			name:    "no enforcers",
+			config:  func(c *Config) { c.Enforcers = 0 },
+			wantErr: true,
+		},
+		{
+			name:    "no namespace",
+			config:  func(c *Config) { c.Namespace = "" },
+			wantErr: true,
+		},
+		{
+			name:    "invalid rollout",
+			config:  func(c *Config) { c.Rollout = rollout.Config{MinConnected: 2} },
+			wantErr: true,
+		},
+		{
+			name:    "no images",
+			config:  func(c *Config) { c.Values.Image = chart.Image{} },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			tt.config(&c)
+			if _, err := NewState(c); (err != nil) != tt.wantErr {
+				t.Errorf("NewState() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package run
+
+import (
+	"context"
+	"fmt"
+
+	"go.aporeto.io/simulator-test-harness/common"
+	"go.aporeto.io/simulator-test-harness/libs/rollout"
+)
+
+// NewSearchState returns the state of a new breaking-point search run of c: searching the highest
+// number of batches, up to the batches of c, for which the backend meets the health limits of c.
+func NewSearchState(c Config) (*State, error) {
+
+	s, err := NewState(c)
+	if err != nil {
+		return nil, err
+	}
+	s.Search = rollout.NewSearch(s.Config.Batches())
+
+	return s, nil
+}
+
+// Search runs, or resumes, the breaking-point search of s. Every level is reached by applying or
+// deleting full size batches, and its health is checked like a batch of a run, the probes being
+// the oracle of the search. The search is complete once the highest passing and lowest failing
+// levels are found; the evidence of every level is kept in the state.
+func (r *Runner) Search(ctx context.Context, s *State) error {
+
+	if r.Probes == nil {
+		return fmt.Errorf("a search requires health probes")
+	}
+	if s.End != nil {
+		common.Log.Infof("Search %s already completed at %v:\n%s", s.RunID, *s.End,
+			s.Search.Summary())
+		return nil
+	}
+
+	namespace, err := r.setup(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	for {
+		level, ok := s.Search.Next()
+		if !ok {
+			break
+		}
+
+		common.Log.Infof("Checking level %d (%d enforcers): %d passed, %d failed", level,
+			level*s.Config.BatchSize(), s.Search.Passed, s.Search.Failed)
+		if err := r.scaleTo(ctx, s, level); err != nil {
+			return fmt.Errorf("scale to level %d: %v", level, err)
+		}
+
+		target := rollout.Target{
+			Name:         fmt.Sprintf("Level %d", level),
+			Namespace:    namespace,
+			Enforcers:    s.Deployed(),
+			K8sNamespace: s.Namespace,
+		}
+		for _, b := range s.Batches {
+			target.Tags = append(target.Tags, b.EnforcerTag)
+		}
+
+		report, err := rollout.Wait(ctx, &s.Config.Rollout, r.Probes, target)
+		if err != nil {
+			return fmt.Errorf("level %d health: %v", level, err)
+		}
+		s.Search.Record(level, target.Enforcers, report)
+		if err := r.save(s); err != nil {
+			return err
+		}
+	}
+
+	end := s.Search.Levels[len(s.Search.Levels)-1].Report.Time
+	s.End = &end
+	if err := r.save(s); err != nil {
+		return err
+	}
+
+	common.Log.Infof("Search %s completed in %v:\n%s", s.RunID, end.Sub(s.Start),
+		s.Search.Summary())
+	return nil
+}
+
+// scaleTo deletes the batches of s above level, newest first, then applies the missing batches
+// up to level.
+func (r *Runner) scaleTo(ctx context.Context, s *State, level int) error {
+
+	for n := len(s.Batches); n > level; n-- {
+		b := s.Batches[n-1]
+		if err := r.K8s.DeleteDeployment(ctx, s.Namespace, b.Deployment); err != nil {
+			return err
+		}
+		s.Batches = s.Batches[:n-1]
+		if err := r.save(s); err != nil {
+			return err
+		}
+	}
+
+	for {
+		n := len(s.Batches)
+		if n == level && s.Batches[n-1].Status == BatchCompleted {
+			return nil
+		}
+
+		if n == 0 || s.Batches[n-1].Status == BatchCompleted {
+			s.Batches = append(s.Batches, s.newBatch(n+1, s.Config.Pods))
+			if err := r.save(s); err != nil {
+				return err
+			}
+		}
+
+		b := s.Batches[len(s.Batches)-1]
+		if err := r.runBatch(ctx, s, b); err != nil {
+			return fmt.Errorf("batch %d: %v", b.Index, err)
+		}
+	}
+}
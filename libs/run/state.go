@@ -0,0 +1,267 @@
+// Package run implements resumable simulator scale runs: the enforcers are deployed in batches, and
+// the progress of the run is persisted in a state file after every step, so that an interrupted run
+// can be resumed without losing its random prefixes nor applying a batch twice.
+package run
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.aporeto.io/simulator-test-harness/libs/chart"
+	"go.aporeto.io/simulator-test-harness/libs/rollout"
+	"go.aporeto.io/simulator-test-harness/libs/tagging"
+	"gopkg.in/yaml.v3"
+)
+
+// A Config is the parameters of a run.
+type Config struct {
+	// Namespace is the Aporeto base namespace, under which the run namespaces are created.
+	Namespace string `yaml:"namespace"`
+	// Prefix is the prefix of the kubernetes and Aporeto namespaces of the run.
+	Prefix string `yaml:"prefix"`
+	// Enforcers is the total number of enforcers to deploy.
+	Enforcers int `yaml:"enforcers"`
+	// Pods is the number of pods of each batch, unless shrunk by the health checks.
+	Pods int `yaml:"pods"`
+	// Simulators is the number of simulators per pod.
+	Simulators int `yaml:"simulators"`
+	// Capacity is the maximum number of enforcers per Aporeto namespace. Defaults to the batch
+	// size.
+	Capacity int `yaml:"capacity"`
+	// PrepareBackend creates the namespaces of the run, and the mapping policies of every batch.
+	PrepareBackend bool `yaml:"prepareBackend"`
+	// Mapping is the strategy mapping the enforcers to the namespaces. Defaults to
+	// tagging.DefaultStrategy.
+	Mapping tagging.Strategy `yaml:"mapping"`
+	// Template is the built-in template, or the path to the template file, applied to the
+	// namespaces created when preparing the backend, if any (see testsetup.LoadTemplate).
+	Template string `yaml:"template,omitempty"`
+	// TemplatePrefix is the value of the {{prefix}} placeholder of the template.
+	TemplatePrefix string `yaml:"templatePrefix,omitempty"`
+	// EnforcerProfiles are the enforcer profiles, built-in or profile files, cycled through the
+	// batches and attached to their enforcers (see testsetup.LoadProfile).
+	EnforcerProfiles []string `yaml:"enforcerProfiles,omitempty"`
+	// Rebalance creates extra mapping policies correcting the imbalances found when verifying the
+	// distribution of the enforcers at the end of the run.
+	Rebalance bool `yaml:"rebalance"`
+	// ImagePullSecret is the path to a docker configuration logged in the private registry of the
+	// images, if any.
+	ImagePullSecret string `yaml:"imagePullSecret,omitempty"`
+	// Values are the chart values of every batch. The batch specific values (e.g. pods, names and
+	// tags) are overwritten.
+	Values chart.Values `yaml:"values"`
+	// Rollout is the health limits gating the batches. Defaults to rollout.DefaultConfig.
+	Rollout rollout.Config `yaml:"rollout"`
+}
+
+// BatchSize returns the number of enforcers of each batch.
+func (c *Config) BatchSize() int {
+
+	return c.Pods * c.Simulators
+}
+
+// Batches returns the number of batches of the run, if none is shrunk.
+func (c *Config) Batches() int {
+
+	return (c.Enforcers + c.BatchSize() - 1) / c.BatchSize()
+}
+
+// Plan returns the layout of the enforcers of the run, as mapped to the namespaces.
+func (c *Config) Plan() tagging.Plan {
+
+	return tagging.Plan{
+		Enforcers:  c.Enforcers,
+		Pods:       c.Pods,
+		Simulators: c.Simulators,
+		Capacity:   c.Capacity,
+	}
+}
+
+// Validate checks that the parameters of c are valid.
+func (c *Config) Validate() error {
+
+	if c.Namespace == "" || c.Prefix == "" {
+		return fmt.Errorf("namespace and prefix must be set")
+	}
+	if c.Enforcers < 1 || c.Pods < 1 || c.Simulators < 1 {
+		return fmt.Errorf("enforcers, pods and simulators must be positive")
+	}
+	if c.Capacity < 0 {
+		return fmt.Errorf("invalid capacity %d", c.Capacity)
+	}
+	if c.Mapping != "" {
+		if err := c.Mapping.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.Rollout.Validate(); err != nil {
+		return fmt.Errorf("invalid rollout: %v", err)
+	}
+
+	return nil
+}
+
+// A BatchStatus is the progress of a batch.
+type BatchStatus string
+
+const (
+	// BatchApplying is the status of a batch whose deployment may or may not have been applied.
+	BatchApplying BatchStatus = "applying"
+	// BatchApplied is the status of a batch whose deployment has been applied.
+	BatchApplied BatchStatus = "applied"
+	// BatchCompleted is the status of a batch whose deployment has been rolled out. Its health is
+	// checked before the next batch.
+	BatchCompleted BatchStatus = "completed"
+)
+
+// A Batch is a batch of enforcers, deployed as a single deployment.
+type Batch struct {
+	// Index is the index of the batch, starting from 1.
+	Index int `yaml:"index"`
+	// Deployment is the name of the deployment of the batch.
+	Deployment string `yaml:"deployment"`
+	// Pods is the number of pods of the batch.
+	Pods int `yaml:"pods"`
+	// EnforcerTagPrefix is the prefix of the nsim tag of the enforcers of the batch.
+	EnforcerTagPrefix string `yaml:"enforcerTagPrefix"`
+	// EnforcerTag is the tag shared by the enforcers of the batch.
+	EnforcerTag string `yaml:"enforcerTag"`
+	// Profile is the enforcer profile of the batch, if any.
+	Profile string `yaml:"profile,omitempty"`
+	// ProfileAttached is set once the enforcer profile is attached to the enforcers of the batch.
+	ProfileAttached bool        `yaml:"profileAttached,omitempty"`
+	Status          BatchStatus `yaml:"status"`
+	// Updated is the time of the last status update.
+	Updated time.Time `yaml:"updated"`
+	// Health is the report of the health checks of the batch, once completed.
+	Health *rollout.Report `yaml:"health,omitempty"`
+}
+
+// A State is the persisted state of a run.
+type State struct {
+	// RunID is the unique identifier of the run.
+	RunID  string    `yaml:"runID"`
+	Config Config    `yaml:"config"`
+	Start  time.Time `yaml:"start"`
+	// Namespace is the name of the run namespace, both in kubernetes and under the Aporeto base
+	// namespace.
+	Namespace string `yaml:"namespace"`
+	// TagPrefix is the prefix of the enforcer tags of the run.
+	TagPrefix string `yaml:"tagPrefix"`
+	// Prepared is set once the backend is prepared.
+	Prepared bool `yaml:"prepared"`
+	// Namespaces are the Aporeto namespaces created for the run.
+	Namespaces []string `yaml:"namespaces"`
+	// AppCreds are the enforcer application credentials created for the run.
+	AppCreds []string `yaml:"appCreds"`
+	// Secret is the name of the kubernetes secret holding the enforcer application credentials.
+	Secret string `yaml:"secret"`
+	// SecretApplied is set once the kubernetes namespace and secrets are created.
+	SecretApplied bool     `yaml:"secretApplied"`
+	Batches       []*Batch `yaml:"batches"`
+	// Search is the breaking-point search of a search run, whose levels are numbers of batches.
+	Search *rollout.Search `yaml:"search,omitempty"`
+	// Mappings are the mapping policies created for the batches of the run, as they are created,
+	// each tag being mapped once.
+	Mappings []tagging.Mapping `yaml:"mappings,omitempty"`
+	// Distribution is the last verification of the distribution of the enforcers to the
+	// namespaces.
+	Distribution *tagging.Report `yaml:"distribution,omitempty"`
+	// Rebalanced are all the corrections applied by the verifications of the run, each replacing
+	// the mapping of its tag, if any, and each tag being corrected at most once.
+	Rebalanced []tagging.Mapping `yaml:"rebalanced,omitempty"`
+	// End is the end time of the run, once all the batches are completed or the search is done.
+	End *time.Time `yaml:"end,omitempty"`
+}
+
+// currentMappings returns the mapping policies of the run, one per tag: the mappings of the
+// batches, replaced by the corrections of the verifications.
+func (s *State) currentMappings() []tagging.Mapping {
+
+	rebalanced := map[string]bool{}
+	for _, m := range s.Rebalanced {
+		rebalanced[m.Tag] = true
+	}
+
+	var mappings []tagging.Mapping
+	for _, m := range s.Mappings {
+		if !rebalanced[m.Tag] {
+			mappings = append(mappings, m)
+		}
+	}
+
+	return append(mappings, s.Rebalanced...)
+}
+
+// CompletedBatches returns the number of batches completed.
+func (s *State) CompletedBatches() int {
+
+	completed := 0
+	for _, b := range s.Batches {
+		if b.Status == BatchCompleted {
+			completed++
+		}
+	}
+
+	return completed
+}
+
+// Deployed returns the number of enforcers of the completed batches.
+func (s *State) Deployed() int {
+
+	deployed := 0
+	for _, b := range s.Batches {
+		if b.Status == BatchCompleted {
+			deployed += b.Pods * s.Config.Simulators
+		}
+	}
+
+	return deployed
+}
+
+// LoadState parses the run state stored in stateFile.
+func LoadState(stateFile string) (*State, error) {
+
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %v", stateFile, err)
+	}
+
+	var s State
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %v", stateFile, err)
+	}
+
+	return &s, nil
+}
+
+// Save writes s to stateFile. The file is replaced atomically, so that an interrupted save never
+// leaves a truncated state behind.
+func (s *State) Save(stateFile string) error {
+
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshal run state: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(stateFile), filepath.Base(stateFile)+".*")
+	if err != nil {
+		return fmt.Errorf("create temporary state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close %s: %v", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), stateFile); err != nil {
+		return fmt.Errorf("replace %s: %v", stateFile, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package run
+
+import (
+	"fmt"
+	"path"
+
+	"go.aporeto.io/simulator-test-harness/common"
+	"go.aporeto.io/simulator-test-harness/libs/tagging"
+)
+
+// Verify counts the enforcers of the completed batches of s per namespace through the backend, and
+// compares them to the distribution of the current mappings of s, i.e. of its batches as replaced
+// by its corrections. If rebalance is set, the corrections of the imbalances are applied, except
+// for the tags already corrected by a previous verification, and recorded in s.Rebalanced: the
+// mapping policies of the misplaced tags are replaced, and the unmapped tags get new ones. The
+// report is persisted in s.
+func (r *Runner) Verify(s *State, rebalance bool) (*tagging.Report, error) {
+
+	namespace := path.Join(s.Config.Namespace, s.Namespace)
+
+	var regs []tagging.Registration
+	for _, b := range s.Batches {
+		if b.Status != BatchCompleted {
+			continue
+		}
+		batch, err := r.Backend.Enforcers(namespace, b.EnforcerTag)
+		if err != nil {
+			return nil, fmt.Errorf("batch %d enforcers: %v", b.Index, err)
+		}
+		regs = append(regs, batch...)
+	}
+
+	children, err := s.childNamespaces()
+	if err != nil {
+		return nil, err
+	}
+	current := s.currentMappings()
+	report, err := s.Scheme().Verify(namespace, current, children, s.Config.Capacity, regs)
+	if err != nil {
+		return nil, err
+	}
+
+	if rebalance && len(report.Corrections) > 0 {
+		applied, mapped := map[string]bool{}, map[string]bool{}
+		for _, m := range s.Rebalanced {
+			applied[m.Tag] = true
+		}
+		for _, m := range current {
+			mapped[m.Tag] = true
+		}
+
+		var mappings []tagging.Mapping
+		var replaced []string
+		for _, m := range report.Corrections {
+			if applied[m.Tag] {
+				continue
+			}
+			mappings = append(mappings, m)
+			if mapped[m.Tag] {
+				replaced = append(replaced, m.Tag)
+			}
+		}
+		if len(replaced) > 0 {
+			if err := r.Backend.Unmap(namespace, replaced); err != nil {
+				return nil, fmt.Errorf("rebalance: %v", err)
+			}
+		}
+		if len(mappings) > 0 {
+			if err := r.Backend.Map(namespace, mappings); err != nil {
+				return nil, fmt.Errorf("rebalance: %v", err)
+			}
+			s.Rebalanced = append(s.Rebalanced, mappings...)
+		}
+		report.Corrected = true
+	}
+
+	s.Distribution = report
+	if err := r.save(s); err != nil {
+		return nil, err
+	}
+
+	if report.Balanced() {
+		common.Log.Infof("Distribution of run %s is balanced:\n%s", s.RunID, report.Summary())
+	} else {
+		common.Log.Warnf("Distribution of run %s is imbalanced:\n%s", s.RunID, report.Summary())
+	}
+
+	return report, nil
+}
@@ -0,0 +1,323 @@
+// Package tagging defines the tagging and mapping schemes of the simulated enforcers: a single
+// Scheme produces both the tags rendered in the simulator charts and the subjects of the namespace
+// mapping policies matching them, so that the two sides cannot drift apart and leave enforcers
+// unmapped in the base namespace.
+package tagging
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"go.aporeto.io/simulator-test-harness/libs/chart"
+)
+
+// A Strategy is a strategy mapping the simulated enforcers to namespaces.
+type Strategy string
+
+const (
+	// StrategyPerSimulator maps the enforcers of every simulator slot of a batch (i.e. the
+	// simulator sim of every pod of the batch), filling the namespaces up to their capacity.
+	StrategyPerSimulator Strategy = "per-simulator"
+	// StrategyPerBatch maps all the enforcers of a batch to the same namespace, filling the
+	// namespaces up to their capacity.
+	StrategyPerBatch Strategy = "per-batch"
+	// StrategyPerPod maps the enforcers of every pod slot of a batch together, filling the
+	// namespaces up to their capacity. The pods only get their slot once running (see PodSlots).
+	StrategyPerPod Strategy = "per-pod"
+	// StrategyHashed maps the enforcers of every simulator slot to a namespace picked by hashing
+	// its tag, spreading the enforcers evenly on average regardless of the batch sizes.
+	StrategyHashed Strategy = "hashed"
+)
+
+// Strategies are all the supported strategies.
+var Strategies = []Strategy{
+	StrategyPerSimulator,
+	StrategyPerBatch,
+	StrategyPerPod,
+	StrategyHashed,
+}
+
+const (
+	// SimulatorTagKey is the key of the tag of each simulator slot.
+	SimulatorTagKey = "nsim"
+	// BatchTagKey is the key of the tag shared by the enforcers of a batch.
+	BatchTagKey = "simbase"
+	// PodTagKey is the key of the tag of each pod slot, and of the pod label it is read from.
+	PodTagKey = chart.PodTagLabel
+)
+
+// Validate checks that s is a known strategy.
+func (s Strategy) Validate() error {
+
+	for _, st := range Strategies {
+		if s == st {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown mapping strategy %q, expected one of %v", s, Strategies)
+}
+
+// DefaultStrategy returns the strategy used when none is given: all the enforcers of a batch are
+// mapped together if the batches fill the namespaces exactly, and per simulator slot otherwise.
+func DefaultStrategy(batchSize, capacity int) Strategy {
+
+	if batchSize == capacity {
+		return StrategyPerBatch
+	}
+
+	return StrategyPerSimulator
+}
+
+// A Scheme is the tagging and mapping scheme of the enforcers of a run. Every simulator sim (from
+// 0) of the pods of batch (from 1) is tagged with:
+//
+//	nsim=<prefix>-<batch>-<sim> simbase=<prefix>-<batch>
+//
+// and, with StrategyPerPod, with the tag of the slot pod (from 0) of its pod in the batch:
+//
+//	npod=<prefix>-<batch>-<pod>
+type Scheme struct {
+	Strategy Strategy
+	// Prefix is the unique prefix of the tags of the run.
+	Prefix string
+}
+
+// Validate checks that the strategy of s is known and its prefix set.
+func (s Scheme) Validate() error {
+
+	if s.Prefix == "" {
+		return fmt.Errorf("no tag prefix")
+	}
+
+	return s.Strategy.Validate()
+}
+
+// SimulatorTag returns the tag of the simulator sim of the pods of batch.
+func (s Scheme) SimulatorTag(batch, sim int) string {
+
+	return fmt.Sprintf("%s=%s-%d", SimulatorTagKey, s.SimulatorPrefix(batch), sim)
+}
+
+// BatchTag returns the tag shared by the enforcers of batch.
+func (s Scheme) BatchTag(batch int) string {
+
+	return fmt.Sprintf("%s=%s-%d", BatchTagKey, s.Prefix, batch)
+}
+
+// PodTag returns the tag of the enforcers of the pod slot pod of batch.
+func (s Scheme) PodTag(batch, pod int) string {
+
+	return fmt.Sprintf("%s=%s", PodTagKey, s.PodSlot(batch, pod))
+}
+
+// PodSlot returns the value of the pod label of the pod slot pod of batch.
+func (s Scheme) PodSlot(batch, pod int) string {
+
+	return fmt.Sprintf("%s-%d", s.SimulatorPrefix(batch), pod)
+}
+
+// PodSlots returns the values of the pod label of the pods slots of batch, assigned to its pods
+// once running (see k8s.Client.AssignPodSlots), or nil if the strategy of s does not tag the pods.
+func (s Scheme) PodSlots(batch, pods int) []string {
+
+	if s.Strategy != StrategyPerPod {
+		return nil
+	}
+
+	slots := make([]string, pods)
+	for pod := range slots {
+		slots[pod] = s.PodSlot(batch, pod)
+	}
+
+	return slots
+}
+
+// Tags returns the tags of the simulator sim of the pod slot pod of batch, as rendered by the
+// chart once the slot is assigned.
+func (s Scheme) Tags(batch, pod, sim int) []string {
+
+	tags := []string{s.SimulatorTag(batch, sim), s.BatchTag(batch)}
+	if s.Strategy == StrategyPerPod {
+		tags = append(tags, s.PodTag(batch, pod))
+	}
+
+	return tags
+}
+
+// Apply sets the tags of the enforcers of batch in the chart values v.
+func (s Scheme) Apply(v *chart.Values, batch int) {
+
+	v.EnforcerTagPrefix = s.SimulatorPrefix(batch)
+	v.EnforcerTag = s.BatchTag(batch)
+	v.PodTagPrefix = ""
+	if s.Strategy == StrategyPerPod {
+		v.PodTagPrefix = s.SimulatorPrefix(batch)
+	}
+}
+
+// SimulatorPrefix returns the prefix of the simulator tags of batch, i.e. the enforcerTagPrefix
+// of its chart values.
+func (s Scheme) SimulatorPrefix(batch int) string {
+
+	return fmt.Sprintf("%s-%d", s.Prefix, batch)
+}
+
+// A Plan is the layout of the enforcers of a run.
+type Plan struct {
+	// Enforcers is the total number of enforcers.
+	Enforcers int
+	// Pods is the number of pods per batch.
+	Pods int
+	// Simulators is the number of simulators per pod.
+	Simulators int
+	// Capacity is the number of enforcers per namespace.
+	Capacity int
+}
+
+// Batches returns the number of batches needed to deploy the enforcers of p.
+func (p Plan) Batches() int {
+
+	size := p.Pods * p.Simulators
+
+	return (p.Enforcers + size - 1) / size
+}
+
+// A Mapping is a namespace mapping policy of the enforcers tagged with Tag.
+type Mapping struct {
+	// Tag is the tag of the enforcers mapped.
+	Tag string `yaml:"tag"`
+	// Namespace is the index of the child namespace targeted.
+	Namespace int `yaml:"namespace"`
+	// Enforcers is the number of enforcers mapped.
+	Enforcers int `yaml:"enforcers"`
+}
+
+// Subject returns the subject of the mapping policy of m.
+func (m Mapping) Subject() [][]string {
+
+	return [][]string{{"$identity=enforcer", m.Tag}}
+}
+
+// Mappings returns the mappings of the enforcers of p, and the number of child namespaces they
+// target.
+func (s Scheme) Mappings(p Plan) ([]Mapping, int, error) {
+
+	if err := s.Validate(); err != nil {
+		return nil, 0, err
+	}
+	if p.Enforcers < 1 || p.Pods < 1 || p.Simulators < 1 || p.Capacity < 1 {
+		return nil, 0, fmt.Errorf("invalid plan %+v", p)
+	}
+
+	var units []Mapping
+	for batch := 1; batch <= p.Batches(); batch++ {
+		units = append(units, s.units(batch, p.Pods, p.Simulators)...)
+	}
+
+	if s.Strategy == StrategyHashed {
+		namespaces := (p.Enforcers + p.Capacity - 1) / p.Capacity
+		for i := range units {
+			units[i].Namespace = hashNamespace(units[i].Tag, namespaces)
+		}
+		return units, namespaces, nil
+	}
+
+	ns, used := 0, 0
+	for i := range units {
+		if units[i].Enforcers > p.Capacity {
+			return nil, 0, fmt.Errorf("%s: %d enforcers per mapping exceed the capacity %d",
+				s.Strategy, units[i].Enforcers, p.Capacity)
+		}
+		if used+units[i].Enforcers > p.Capacity {
+			ns, used = ns+1, 0
+		}
+		units[i].Namespace = ns
+		used += units[i].Enforcers
+	}
+
+	return units, ns + 1, nil
+}
+
+// BatchMappings returns the mappings of the enforcers of the pods of batch, running simulators
+// simulators each, placed in the child namespaces holding load enforcers: in the first namespace
+// with room for them out of capacity, or the least loaded one if none has room left (e.g. after
+// shrunk batches), and adds them to load. Hashed mappings keep their hashed namespace.
+func (s Scheme) BatchMappings(
+	batch, pods, simulators, capacity int,
+	load []int,
+) ([]Mapping, error) {
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if pods < 1 || simulators < 1 || capacity < 1 || len(load) == 0 {
+		return nil, fmt.Errorf("invalid batch %d of %d pods of %d simulators in %d namespaces "+
+			"of %d enforcers", batch, pods, simulators, len(load), capacity)
+	}
+
+	units := s.units(batch, pods, simulators)
+	for i := range units {
+		if units[i].Enforcers > capacity {
+			return nil, fmt.Errorf("%s: %d enforcers per mapping exceed the capacity %d",
+				s.Strategy, units[i].Enforcers, capacity)
+		}
+
+		ns := -1
+		if s.Strategy == StrategyHashed {
+			ns = hashNamespace(units[i].Tag, len(load))
+		} else {
+			for j, l := range load {
+				if l+units[i].Enforcers <= capacity {
+					ns = j
+					break
+				}
+			}
+		}
+		if ns < 0 {
+			ns = 0
+			for j, l := range load {
+				if l < load[ns] {
+					ns = j
+				}
+			}
+		}
+
+		units[i].Namespace = ns
+		load[ns] += units[i].Enforcers
+	}
+
+	return units, nil
+}
+
+// units returns the mappings of the enforcers of the pods of batch, running simulators simulators
+// each, before their placement in a namespace.
+func (s Scheme) units(batch, pods, simulators int) []Mapping {
+
+	switch s.Strategy {
+	case StrategyPerBatch:
+		return []Mapping{{Tag: s.BatchTag(batch), Enforcers: pods * simulators}}
+	case StrategyPerPod:
+		units := make([]Mapping, 0, pods)
+		for pod := 0; pod < pods; pod++ {
+			units = append(units, Mapping{Tag: s.PodTag(batch, pod), Enforcers: simulators})
+		}
+		return units
+	}
+
+	units := make([]Mapping, 0, simulators)
+	for sim := 0; sim < simulators; sim++ {
+		units = append(units, Mapping{Tag: s.SimulatorTag(batch, sim), Enforcers: pods})
+	}
+
+	return units
+}
+
+// hashNamespace returns the namespace, out of namespaces, of the hashed mapping of tag.
+func hashNamespace(tag string, namespaces int) int {
+
+	h := fnv.New32a()
+	h.Write([]byte(tag))
+
+	return int(h.Sum32() % uint32(namespaces))
+}
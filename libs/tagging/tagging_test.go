@@ -0,0 +1,333 @@
+package tagging
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.aporeto.io/simulator-test-harness/libs/chart"
+)
+
+func TestMappings(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		strategy Strategy
+		plan     Plan
+		// want are the namespaces of the mappings, in order.
+		want           []int
+		wantNamespaces int
+		wantErr        bool
+	}{
+		{
+			name:           "per batch",
+			strategy:       StrategyPerBatch,
+			plan:           Plan{Enforcers: 60, Pods: 2, Simulators: 10, Capacity: 20},
+			want:           []int{0, 1, 2},
+			wantNamespaces: 3,
+		},
+		{
+			name:           "per batch packed",
+			strategy:       StrategyPerBatch,
+			plan:           Plan{Enforcers: 50, Pods: 2, Simulators: 10, Capacity: 45},
+			want:           []int{0, 0, 1},
+			wantNamespaces: 2,
+		},
+		{
+			name:     "per batch over capacity",
+			strategy: StrategyPerBatch,
+			plan:     Plan{Enforcers: 60, Pods: 2, Simulators: 10, Capacity: 10},
+			wantErr:  true,
+		},
+		{
+			name:           "per simulator",
+			strategy:       StrategyPerSimulator,
+			plan:           Plan{Enforcers: 12, Pods: 2, Simulators: 3, Capacity: 4},
+			want:           []int{0, 0, 1, 1, 2, 2},
+			wantNamespaces: 3,
+		},
+		{
+			name:           "per simulator unaligned",
+			strategy:       StrategyPerSimulator,
+			plan:           Plan{Enforcers: 12, Pods: 2, Simulators: 3, Capacity: 5},
+			want:           []int{0, 0, 1, 1, 2, 2},
+			wantNamespaces: 3,
+		},
+		{
+			name:           "per pod",
+			strategy:       StrategyPerPod,
+			plan:           Plan{Enforcers: 12, Pods: 2, Simulators: 3, Capacity: 7},
+			want:           []int{0, 0, 1, 1},
+			wantNamespaces: 2,
+		},
+		{
+			name:     "per pod over capacity",
+			strategy: StrategyPerPod,
+			plan:     Plan{Enforcers: 12, Pods: 2, Simulators: 3, Capacity: 2},
+			wantErr:  true,
+		},
+		{
+			name:     "unknown strategy",
+			strategy: "per-node",
+			plan:     Plan{Enforcers: 12, Pods: 2, Simulators: 3, Capacity: 4},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Scheme{Strategy: tt.strategy, Prefix: "ab12c"}
+			mappings, namespaces, err := s.Mappings(tt.plan)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Mappings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if namespaces != tt.wantNamespaces {
+				t.Errorf("got %d namespaces, want %d", namespaces, tt.wantNamespaces)
+			}
+			if len(mappings) != len(tt.want) {
+				t.Fatalf("got %d mappings, want %d", len(mappings), len(tt.want))
+			}
+			for i, m := range mappings {
+				if m.Namespace != tt.want[i] {
+					t.Errorf("mapping %d (%s) targets namespace %d, want %d", i, m.Tag,
+						m.Namespace, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHashedMappings(t *testing.T) {
+
+	s := Scheme{Strategy: StrategyHashed, Prefix: "ab12c"}
+	p := Plan{Enforcers: 10000, Pods: 10, Simulators: 10, Capacity: 1000}
+	mappings, namespaces, err := s.Mappings(p)
+	if err != nil {
+		t.Fatalf("Mappings() error = %v", err)
+	}
+	if namespaces != 10 {
+		t.Fatalf("got %d namespaces, want 10", namespaces)
+	}
+
+	enforcers := make([]int, namespaces)
+	for _, m := range mappings {
+		enforcers[m.Namespace] += m.Enforcers
+	}
+	for ns, n := range enforcers {
+		if n == 0 || n > 2*p.Capacity {
+			t.Errorf("namespace %d got %d enforcers, want about %d", ns, n, p.Capacity)
+		}
+	}
+
+	again, _, _ := s.Mappings(p)
+	for i := range mappings {
+		if again[i] != mappings[i] {
+			t.Fatalf("mapping %d is not deterministic", i)
+		}
+	}
+}
+
+func TestBatchMappings(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		strategy   Strategy
+		pods       int
+		simulators int
+		capacity   int
+		load       []int
+		// want are the namespaces of the mappings, in order.
+		want     []int
+		wantLoad []int
+		wantErr  bool
+	}{
+		{
+			name:       "per batch shrunk",
+			strategy:   StrategyPerBatch,
+			pods:       1,
+			simulators: 5,
+			capacity:   10,
+			load:       []int{10, 10, 0},
+			want:       []int{2},
+			wantLoad:   []int{10, 10, 5},
+		},
+		{
+			name:       "per batch after a shrunk batch",
+			strategy:   StrategyPerBatch,
+			pods:       1,
+			simulators: 5,
+			capacity:   10,
+			load:       []int{10, 5, 0},
+			want:       []int{1},
+			wantLoad:   []int{10, 10, 0},
+		},
+		{
+			name:       "per batch full",
+			strategy:   StrategyPerBatch,
+			pods:       2,
+			simulators: 5,
+			capacity:   10,
+			load:       []int{10, 5},
+			want:       []int{1},
+			wantLoad:   []int{10, 15},
+		},
+		{
+			name:       "per simulator",
+			strategy:   StrategyPerSimulator,
+			pods:       2,
+			simulators: 3,
+			capacity:   4,
+			load:       []int{4, 0},
+			want:       []int{1, 1, 0},
+			wantLoad:   []int{6, 4},
+		},
+		{
+			name:       "per pod",
+			strategy:   StrategyPerPod,
+			pods:       2,
+			simulators: 3,
+			capacity:   7,
+			load:       []int{4, 0},
+			want:       []int{0, 1},
+			wantLoad:   []int{7, 3},
+		},
+		{
+			name:       "per pod over capacity",
+			strategy:   StrategyPerPod,
+			pods:       2,
+			simulators: 3,
+			capacity:   2,
+			load:       []int{0},
+			wantErr:    true,
+		},
+		{
+			name:       "no namespaces",
+			strategy:   StrategyPerBatch,
+			pods:       2,
+			simulators: 3,
+			capacity:   10,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Scheme{Strategy: tt.strategy, Prefix: "ab12c"}
+			mappings, err := s.BatchMappings(3, tt.pods, tt.simulators, tt.capacity, tt.load)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BatchMappings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(mappings) != len(tt.want) {
+				t.Fatalf("got %d mappings, want %d", len(mappings), len(tt.want))
+			}
+			for i, m := range mappings {
+				if m.Namespace != tt.want[i] {
+					t.Errorf("mapping %d (%s) targets namespace %d, want %d", i, m.Tag,
+						m.Namespace, tt.want[i])
+				}
+			}
+			if !reflect.DeepEqual(tt.load, tt.wantLoad) {
+				t.Errorf("got load %v, want %v", tt.load, tt.wantLoad)
+			}
+		})
+	}
+}
+
+// TestBatchMappingsPlan checks that the batches of a plan mapped one by one get the mappings of
+// the plan.
+func TestBatchMappingsPlan(t *testing.T) {
+
+	p := Plan{Enforcers: 50, Pods: 2, Simulators: 5, Capacity: 15}
+
+	for _, strategy := range Strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			s := Scheme{Strategy: strategy, Prefix: "ab12c"}
+			want, namespaces, err := s.Mappings(p)
+			if err != nil {
+				t.Fatalf("Mappings() error = %v", err)
+			}
+
+			var got []Mapping
+			load := make([]int, namespaces)
+			for batch := 1; batch <= p.Batches(); batch++ {
+				mappings, err := s.BatchMappings(batch, p.Pods, p.Simulators, p.Capacity, load)
+				if err != nil {
+					t.Fatalf("BatchMappings() error = %v", err)
+				}
+				got = append(got, mappings...)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got mappings %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// TestRenderedTagsMapped checks that the tags of every simulator rendered by the chart are matched
+// by exactly one mapping of the plan.
+func TestRenderedTagsMapped(t *testing.T) {
+
+	p := Plan{Enforcers: 40, Pods: 2, Simulators: 5, Capacity: 20}
+
+	for _, strategy := range Strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			s := Scheme{Strategy: strategy, Prefix: "ab12c"}
+			mappings, _, err := s.Mappings(p)
+			if err != nil {
+				t.Fatalf("Mappings() error = %v", err)
+			}
+
+			for batch := 1; batch <= p.Batches(); batch++ {
+				v := chart.DefaultValues()
+				v.DepName, v.K8sNS, v.K8sSecret = "sim-pods", "sim", "enforcerd"
+				v.Image = chart.Image{Name: "enforcerd", Tag: "v1"}
+				v.SimulatorImage = chart.Image{Name: "plan-gen", Tag: "v1"}
+				v.Pods, v.SimulatorsPerPod = p.Pods, p.Simulators
+				s.Apply(v, batch)
+
+				m, err := chart.Render(v)
+				if err != nil {
+					t.Fatalf("Render() error = %v", err)
+				}
+				// The pods are tagged with the slots assigned to them, if any.
+				slots := s.PodSlots(batch, p.Pods)
+				if slots == nil {
+					slots = make([]string, p.Pods)
+				}
+				for pod, slot := range slots {
+					for sim, c := range m.Deployment.Spec.Template.Spec.Containers {
+						var tags string
+						for _, e := range c.Env {
+							if e.Name == "ENFORCERD_TAG" {
+								tags = strings.ReplaceAll(e.Value, "$(POD_TAG)", slot)
+							}
+						}
+						if want := strings.Join(s.Tags(batch, pod, sim), " "); tags != want {
+							t.Fatalf("batch %d pod %d simulator %d: rendered tags %q, want %q",
+								batch, pod, sim, tags, want)
+						}
+
+						matched := 0
+						for _, mp := range mappings {
+							if strings.Contains(" "+tags+" ", " "+mp.Tag+" ") {
+								matched++
+							}
+						}
+						if matched != 1 {
+							t.Errorf("batch %d pod %d simulator %d (%s): matched by %d mappings",
+								batch, pod, sim, tags, matched)
+						}
+					}
+				}
+			}
+		})
+	}
+}
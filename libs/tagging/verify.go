@@ -0,0 +1,216 @@
+package tagging
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ChildNamespace returns the child namespace i of base, targeted by the mappings of namespace i.
+func ChildNamespace(base string, i int) string {
+
+	return path.Join(base, fmt.Sprintf("%s-%d", path.Base(base), i))
+}
+
+// A Registration is an enforcer registered in the backend, as observed through the API.
+type Registration struct {
+	// Namespace is the namespace of the enforcer.
+	Namespace string
+	// Tags are the tags of the enforcer.
+	Tags []string
+}
+
+// A NamespaceCount is the number of enforcers of a namespace.
+type NamespaceCount struct {
+	Namespace string `yaml:"namespace"`
+	// Expected is the number of enforcers mapped to the namespace.
+	Expected int `yaml:"expected"`
+	// Actual is the number of enforcers registered in the namespace.
+	Actual int `yaml:"actual"`
+}
+
+// A Report is the verification of the distribution of the enforcers to the namespaces.
+type Report struct {
+	Time time.Time `yaml:"time"`
+	// Enforcers is the number of enforcers verified.
+	Enforcers int `yaml:"enforcers"`
+	// Namespaces are the expected and actual enforcers of every namespace, sorted.
+	Namespaces []NamespaceCount `yaml:"namespaces"`
+	// Misplaced is the number of mapped enforcers registered outside their target namespace.
+	Misplaced int `yaml:"misplaced"`
+	// Unmapped is the number of enforcers matched by no mapping, i.e. left in the base namespace.
+	Unmapped int `yaml:"unmapped"`
+	// Overloaded are the child namespaces holding more enforcers than their capacity.
+	Overloaded []string `yaml:"overloaded,omitempty"`
+	// Corrections are the mappings redirecting the tags of the unmapped enforcers, and replacing
+	// the mappings of the misplaced enforcers whose target namespace is full, towards the least
+	// loaded namespaces with spare capacity. They only apply to the enforcers registering
+	// afterwards (e.g. restarted pods): the registered enforcers keep their namespace.
+	Corrections []Mapping `yaml:"corrections,omitempty"`
+	// Corrected is set once the corrections are applied.
+	Corrected bool `yaml:"corrected"`
+}
+
+// Balanced returns true if every enforcer is registered in its target namespace, and no namespace
+// holds more enforcers than its capacity.
+func (r *Report) Balanced() bool {
+
+	return r.Misplaced == 0 && r.Unmapped == 0 && len(r.Overloaded) == 0
+}
+
+// Verify compares the namespaces in which the enforcers of regs registered to the namespaces of
+// mappings, among the namespaces child namespaces of base of capacity enforcers each, and
+// returns the report of the imbalances.
+func (s Scheme) Verify(
+	base string,
+	mappings []Mapping,
+	namespaces, capacity int,
+	regs []Registration,
+) (*Report, error) {
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	targets := map[string]int{}
+	for _, m := range mappings {
+		targets[m.Tag] = m.Namespace
+	}
+
+	r := &Report{Time: time.Now(), Enforcers: len(regs)}
+	expected, actual := map[string]int{base: 0}, map[string]int{}
+	for i := 0; i < namespaces; i++ {
+		expected[ChildNamespace(base, i)] = 0
+	}
+
+	// The enforcers to redirect, by unit tag, and their target namespace index (-1 if unmapped).
+	misplaced := map[string]int{}
+	redirect := map[string]int{}
+	for _, reg := range regs {
+		target, mapped := -1, false
+		for _, tag := range reg.Tags {
+			if target, mapped = targets[tag]; mapped {
+				break
+			}
+		}
+
+		want := base
+		if mapped {
+			want = ChildNamespace(base, target)
+		} else {
+			target = -1
+			r.Unmapped++
+		}
+		expected[want]++
+		actual[reg.Namespace]++
+
+		if reg.Namespace == want && mapped {
+			continue
+		}
+		if mapped {
+			r.Misplaced++
+		}
+		if tag := s.unitTag(reg.Tags); tag != "" {
+			misplaced[tag]++
+			redirect[tag] = target
+		}
+	}
+
+	for ns := range actual {
+		if _, ok := expected[ns]; !ok {
+			expected[ns] = 0
+		}
+	}
+	for ns, n := range expected {
+		r.Namespaces = append(r.Namespaces, NamespaceCount{Namespace: ns, Expected: n,
+			Actual: actual[ns]})
+	}
+	sort.Slice(r.Namespaces, func(i, j int) bool {
+		return r.Namespaces[i].Namespace < r.Namespaces[j].Namespace
+	})
+
+	load := make([]int, namespaces)
+	for i := range load {
+		load[i] = actual[ChildNamespace(base, i)]
+		if load[i] > capacity {
+			r.Overloaded = append(r.Overloaded, ChildNamespace(base, i))
+		}
+	}
+	sort.Strings(r.Overloaded)
+
+	tags := make([]string, 0, len(misplaced))
+	for tag := range misplaced {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		n, target := misplaced[tag], redirect[tag]
+		if target >= 0 && load[target]+n <= capacity {
+			// The mapping of the tag already targets a namespace with room for its enforcers.
+			load[target] += n
+			continue
+		}
+		if target = leastLoaded(load, n, capacity); target < 0 {
+			continue
+		}
+		load[target] += n
+		r.Corrections = append(r.Corrections, Mapping{Tag: tag, Namespace: target, Enforcers: n})
+	}
+
+	return r, nil
+}
+
+// unitTag returns the tag of tags mapped by the strategy of s, if any.
+func (s Scheme) unitTag(tags []string) string {
+
+	prefix := SimulatorTagKey + "=" + s.Prefix + "-"
+	switch s.Strategy {
+	case StrategyPerBatch:
+		prefix = BatchTagKey + "=" + s.Prefix + "-"
+	case StrategyPerPod:
+		prefix = PodTagKey + "=" + s.Prefix + "-"
+	}
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, prefix) {
+			return tag
+		}
+	}
+
+	return ""
+}
+
+// leastLoaded returns the index of the least loaded namespace with room for n enforcers, or -1.
+func leastLoaded(load []int, n, capacity int) int {
+
+	least := -1
+	for i, l := range load {
+		if l+n <= capacity && (least < 0 || l < load[least]) {
+			least = i
+		}
+	}
+
+	return least
+}
+
+// Summary returns a human readable summary of r.
+func (r *Report) Summary() string {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%d enforcers: %d misplaced, %d unmapped, %d namespaces over capacity\n",
+		r.Enforcers, r.Misplaced, r.Unmapped, len(r.Overloaded))
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tEXPECTED\tACTUAL")
+	for _, ns := range r.Namespaces {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", ns.Namespace, ns.Expected, ns.Actual)
+	}
+	w.Flush()
+	for _, m := range r.Corrections {
+		fmt.Fprintf(&b, "Correction: %d enforcers with %s to namespace %d\n", m.Enforcers, m.Tag,
+			m.Namespace)
+	}
+
+	return b.String()
+}
@@ -4,21 +4,30 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"encoding/json"
 
 	"go.aporeto.io/addedeffect/appcreds"
 	"go.aporeto.io/gaia"
+	midgardclient "go.aporeto.io/midgard-lib/client"
 )
 
 // CreateEnforcerAppCredential creates an enforcer application credential for namespace ns,
 // identified by name.
 func (c *Client) CreateEnforcerAppCredential(name, ns string) (*gaia.AppCredential, error) {
 
-	roles := []string{
-		"@auth:role=enforcer",
-		"@auth:role=enforcer.runtime",
-	}
+	return c.CreateAppCredential(name, ns, []string{
+		AuthorizedIdentityEnforcer,
+		AuthorizedIdentityEnforcerRuntime,
+	})
+}
+
+// CreateAppCredential creates an application credential for namespace ns, identified by name and
+// given roles (e.g. AuthorizedIdentityNamespaceViewer).
+func (c *Client) CreateAppCredential(name, ns string, roles []string) (*gaia.AppCredential,
+	error) {
+
 	ctx := context.Background()
 	if c.ac.Timeout > 0 {
 		var cancel context.CancelFunc
@@ -28,6 +37,44 @@ func (c *Client) CreateEnforcerAppCredential(name, ns string) (*gaia.AppCredenti
 	return appcreds.New(ctx, c.ac.Manipulator, ns, name, roles, nil)
 }
 
+// DeleteAppCredential revokes the application credential appcred by removing it from namespace
+// ns.
+func (c *Client) DeleteAppCredential(appcred *gaia.AppCredential, ns string) error {
+
+	if err := c.ac.DeleteInNS(ns, appcred); err != nil {
+		return fmt.Errorf("delete appcred %s from ns %s: %v", appcred.Name, ns, err)
+	}
+
+	return nil
+}
+
+// IssueToken issues a token valid for validity from the certificate of the application credential
+// appcred, as the enforcers and apoctl do.
+func (c *Client) IssueToken(appcred *gaia.AppCredential, validity time.Duration) (string, error) {
+
+	if appcred.Credentials == nil {
+		return "", fmt.Errorf("appcred %s has no credentials", appcred.Name)
+	}
+	tlsConfig, err := midgardclient.CredsToTLSConfig(appcred.Credentials)
+	if err != nil {
+		return "", fmt.Errorf("tls config of appcred %s: %v", appcred.Name, err)
+	}
+
+	ctx := context.Background()
+	if c.ac.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.ac.Timeout)
+		defer cancel()
+	}
+	client := midgardclient.NewClientWithTLS(appcred.Credentials.APIURL, tlsConfig)
+	token, err := client.IssueFromCertificate(ctx, validity)
+	if err != nil {
+		return "", fmt.Errorf("issue token from appcred %s: %v", appcred.Name, err)
+	}
+
+	return token, nil
+}
+
 // AppCredsToJSON writes appcreds to a file in JSON format.
 func AppCredsToJSON(appcred *gaia.AppCredential, filename string) error {
 
@@ -36,7 +83,7 @@ func AppCredsToJSON(appcred *gaia.AppCredential, filename string) error {
 		return fmt.Errorf("encode appcred: %v", err)
 	}
 	if err = os.WriteFile(filename, byteData, 0644); err != nil {
-		return fmt.Errorf("write %s: %v", appcred, err)
+		return fmt.Errorf("write %s: %v", filename, err)
 	}
 	return nil
 }
@@ -68,6 +68,14 @@ func NewClient(bd *backend.Details, opts ...maniphttp.Option) (*Client, error) {
 	}, nil
 }
 
+// NewClientWithManipulator creates a new Client using the manipulator m, without timeout.
+func NewClientWithManipulator(m manipulate.Manipulator) *Client {
+
+	return &Client{
+		ac: utils.APIClient{Manipulator: m},
+	}
+}
+
 // randomPort returns a random port number (i.e. int in [1, 65535]).
 func randomPort() int {
 	return common.Roulette((1<<16)-1) + 1
@@ -0,0 +1,276 @@
+package testsetup
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"go.aporeto.io/gaia"
+	"gopkg.in/yaml.v3"
+)
+
+// Sizes of the settings of the large enforcer profile, each processed by the enforcers at startup.
+const (
+	largeExcludedNetworks  = 256
+	largeTargetNetworks    = 1024
+	largeTargetUDPNetworks = 256
+	largeTrustedCAs        = 16
+	largeIgnoreExpressions = 64
+)
+
+// profiles are the built-in enforcer profile variations, returning the settings of the profile.
+var profiles = map[string]func() (*gaia.EnforcerProfile, error){
+	// minimal is the profile without settings, as created by apoctl.
+	"minimal": func() (*gaia.EnforcerProfile, error) {
+		return gaia.NewEnforcerProfile(), nil
+	},
+	// standard is a typical profile: the private networks, one trusted CA and a few ignored PUs.
+	"standard": func() (*gaia.EnforcerProfile, error) {
+		cas, err := trustedCAs(1)
+		if err != nil {
+			return nil, err
+		}
+		ep := gaia.NewEnforcerProfile()
+		ep.ExcludedNetworks = []string{"127.0.0.0/8", "169.254.0.0/16"}
+		ep.TargetNetworks = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}
+		ep.TargetUDPNetworks = []string{"10.0.0.0/8"}
+		ep.TrustedCAs = cas
+		ep.IgnoreExpression = [][]string{
+			{"@app:k8s:namespace=kube-system"},
+			{"@usr:ignored=true"},
+		}
+		return ep, nil
+	},
+	// large is a profile with many networks, trusted CAs and ignore expressions.
+	"large": func() (*gaia.EnforcerProfile, error) {
+		cas, err := trustedCAs(largeTrustedCAs)
+		if err != nil {
+			return nil, err
+		}
+		ep := gaia.NewEnforcerProfile()
+		ep.ExcludedNetworks = subnets("100.64", largeExcludedNetworks)
+		ep.TargetNetworks = subnets("10", largeTargetNetworks)
+		ep.TargetUDPNetworks = subnets("10", largeTargetUDPNetworks)
+		ep.TrustedCAs = cas
+		for i := 0; i < largeIgnoreExpressions; i++ {
+			ep.IgnoreExpression = append(ep.IgnoreExpression, []string{
+				"$identity=processingunit", fmt.Sprintf("@usr:ignore-group=%d", i)})
+		}
+		return ep, nil
+	},
+}
+
+// Profiles returns the names of the built-in enforcer profiles, from the cheapest to the most
+// expensive enforcer startup.
+func Profiles() []string {
+
+	return []string{"minimal", "standard", "large"}
+}
+
+// LoadProfile returns the settings of the built-in enforcer profile name, or of the profile
+// stored in the file name: a gaia.EnforcerProfile written with its lowercase field names, e.g.
+// excludednetworks, targetnetworks, trustedcas or ignoreexpression.
+func LoadProfile(name string) (*gaia.EnforcerProfile, error) {
+
+	if profile, ok := profiles[name]; ok {
+		ep, err := profile()
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %v", name, err)
+		}
+		return ep, nil
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s is neither a built-in profile %v nor a file: %v",
+			name, Profiles(), err)
+	}
+
+	ep := gaia.NewEnforcerProfile()
+	if err := yaml.Unmarshal(data, ep); err != nil {
+		return nil, fmt.Errorf("profile %s: %v", name, err)
+	}
+
+	return ep, nil
+}
+
+// subnets returns n /24 subnets of the /8 or /16 network prefix, e.g. "10" or "100.64".
+func subnets(prefix string, n int) []string {
+
+	nets := make([]string, n)
+	for i := range nets {
+		if strings.Count(prefix, ".") == 0 {
+			nets[i] = fmt.Sprintf("%s.%d.%d.0/24", prefix, i/256%256, i%256)
+		} else {
+			nets[i] = fmt.Sprintf("%s.%d.0/24", prefix, i%256)
+		}
+	}
+
+	return nets
+}
+
+// trustedCAs returns n self-signed CA certificates, PEM encoded.
+func trustedCAs(n int) ([]string, error) {
+
+	cas := make([]string, n)
+	for i := range cas {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generate CA key: %v", err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(int64(i + 1)),
+			Subject:               pkix.Name{CommonName: fmt.Sprintf("simulator-ca-%d", i)},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().AddDate(1, 0, 0),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			return nil, fmt.Errorf("create CA certificate: %v", err)
+		}
+		cas[i] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	}
+
+	return cas, nil
+}
+
+// A ProfileSelection selects the enforcer profile of each rail or batch.
+type ProfileSelection struct {
+	// Rails are the profiles of the rails, by name.
+	Rails map[string]string
+	// Batches are the profiles cycled through the batches.
+	Batches []string
+}
+
+// ParseProfileSelection parses the comma separated profiles of s: <rail>=<profile> entries select
+// the profile of a rail (e.g. public=large), and the others are cycled through the batches (e.g.
+// minimal,large), a single one selecting the profile of all the batches. The profiles are checked
+// with LoadProfile.
+func ParseProfileSelection(s string) (*ProfileSelection, error) {
+
+	sel := &ProfileSelection{Rails: map[string]string{}}
+	loaded := map[string]bool{}
+	for _, entry := range strings.Split(s, ",") {
+		rail, profile, isRail := strings.Cut(strings.TrimSpace(entry), "=")
+		if !isRail {
+			profile = rail
+		}
+		if profile == "" {
+			return nil, fmt.Errorf("empty profile in %q", s)
+		}
+		if !loaded[profile] {
+			if _, err := LoadProfile(profile); err != nil {
+				return nil, err
+			}
+			loaded[profile] = true
+		}
+		if isRail {
+			sel.Rails[rail] = profile
+		} else {
+			sel.Batches = append(sel.Batches, profile)
+		}
+	}
+
+	return sel, nil
+}
+
+// Select returns the profile of rail, or else the profile of the batch index (starting from 1),
+// or an empty string if s selects none.
+func (s *ProfileSelection) Select(rail string, batch int) string {
+
+	if profile, ok := s.Rails[rail]; ok {
+		return profile
+	}
+	if len(s.Batches) == 0 || batch < 1 {
+		return ""
+	}
+
+	return s.Batches[(batch-1)%len(s.Batches)]
+}
+
+// CreateEnforcerProfile creates an enforcer profile with the settings of settings (e.g. loaded
+// with LoadProfile) in namespace ns, identified by name and tagged with tags (user tags).
+func (c *Client) CreateEnforcerProfile(name, ns string, tags []string,
+	settings *gaia.EnforcerProfile, propagate bool) (*gaia.EnforcerProfile, error) {
+
+	ep := settings.DeepCopy()
+	ep.Name = name
+	ep.AssociatedTags = tags
+	ep.Propagate = propagate
+
+	if err := c.ac.CreateInNS(ns, ep); err != nil {
+		return nil, fmt.Errorf("create enforcer profile %s: %v", name, err)
+	}
+
+	return ep, nil
+}
+
+// DeleteEnforcerProfile removes the enforcer profile ep from namespace ns.
+func (c *Client) DeleteEnforcerProfile(ep *gaia.EnforcerProfile, ns string) error {
+
+	if err := c.ac.DeleteInNS(ns, ep); err != nil {
+		return fmt.Errorf("delete enforcer profile %s from ns %s: %v", ep.Name, ns, err)
+	}
+
+	return nil
+}
+
+// CreateEPMappingPolicy creates an enforcer profile mapping policy in namespace ns, mapping the
+// enforcer profiles matching object to the enforcers matching subject.
+func (c *Client) CreateEPMappingPolicy(name, ns string, subject, object [][]string,
+	propagate bool) (*gaia.EnforcerProfileMappingPolicy, error) {
+
+	epmp := gaia.NewEnforcerProfileMappingPolicy()
+	epmp.Name = name
+	epmp.Subject = subject
+	epmp.Object = object
+	epmp.Propagate = propagate
+
+	if err := c.ac.CreateInNS(ns, epmp); err != nil {
+		return nil, fmt.Errorf("create enforcer profile mapping policy %s: %v", name, err)
+	}
+
+	return epmp, nil
+}
+
+// DeleteEPMappingPolicy removes the enforcer profile mapping policy epmp from namespace ns.
+func (c *Client) DeleteEPMappingPolicy(epmp *gaia.EnforcerProfileMappingPolicy, ns string) error {
+
+	if err := c.ac.DeleteInNS(ns, epmp); err != nil {
+		return fmt.Errorf("delete enforcer profile mapping policy %s from ns %s: %v",
+			epmp.Name, ns, err)
+	}
+
+	return nil
+}
+
+// AttachEnforcerProfile creates an enforcer profile named name with settings in namespace ns,
+// tagged enforcerprofile=<name>, and its mapping policy towards the enforcers matching enforcers.
+// Both are propagated to the children namespaces, where the enforcers usually are.
+func (c *Client) AttachEnforcerProfile(name, ns string, settings *gaia.EnforcerProfile,
+	enforcers [][]string) (*gaia.EnforcerProfile, error) {
+
+	tag := "enforcerprofile=" + name
+	ep, err := c.CreateEnforcerProfile(name, ns, []string{tag}, settings, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := c.CreateEPMappingPolicy(name, ns, enforcers, [][]string{{tag}},
+		true); err != nil {
+		return nil, err
+	}
+
+	return ep, nil
+}
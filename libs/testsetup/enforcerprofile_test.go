@@ -0,0 +1,113 @@
+package testsetup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+
+	// The built-in profiles are sorted by size.
+	size := -1
+	for _, name := range Profiles() {
+		ep, err := LoadProfile(name)
+		if err != nil {
+			t.Fatalf("LoadProfile(%s) error = %v", name, err)
+		}
+		s := len(ep.ExcludedNetworks) + len(ep.TargetNetworks) + len(ep.TargetUDPNetworks) +
+			len(ep.TrustedCAs) + len(ep.IgnoreExpression)
+		if s <= size {
+			t.Errorf("profile %s has %d settings, not more than the previous one", name, s)
+		}
+		size = s
+	}
+
+	file := filepath.Join(t.TempDir(), "profile.yaml")
+	data := "excludednetworks: [192.0.2.0/24]\nignoreexpression: [[\"@usr:ignored=true\"]]\n"
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatalf("write %s: %v", file, err)
+	}
+	ep, err := LoadProfile(file)
+	if err != nil {
+		t.Fatalf("LoadProfile(%s) error = %v", file, err)
+	}
+	if len(ep.ExcludedNetworks) != 1 || len(ep.IgnoreExpression) != 1 {
+		t.Errorf("LoadProfile(%s) = %+v", file, ep)
+	}
+}
+
+func TestProfileSelection(t *testing.T) {
+
+	type selected struct {
+		rail  string
+		batch int
+		want  string
+	}
+
+	tests := []struct {
+		name     string
+		s        string
+		selected []selected
+		wantErr  bool
+	}{
+		{
+			name: "single profile",
+			s:    "standard",
+			selected: []selected{
+				{rail: "public", batch: 1, want: "standard"},
+				{batch: 3, want: "standard"},
+			},
+		},
+		{
+			name: "profiles per rail",
+			s:    "public=large, private=minimal",
+			selected: []selected{
+				{rail: "public", batch: 1, want: "large"},
+				{rail: "private", batch: 2, want: "minimal"},
+				{rail: "protected", batch: 1},
+			},
+		},
+		{
+			name: "profiles cycled through the batches",
+			s:    "minimal,large,public=standard",
+			selected: []selected{
+				{batch: 1, want: "minimal"},
+				{batch: 2, want: "large"},
+				{batch: 3, want: "minimal"},
+				{rail: "public", batch: 2, want: "standard"},
+			},
+		},
+		{
+			name:    "unknown profile",
+			s:       "public=huge",
+			wantErr: true,
+		},
+		{
+			name:    "empty profile",
+			s:       "public=",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := ParseProfileSelection(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseProfileSelection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var got, want []string
+			for _, s := range tt.selected {
+				got = append(got, sel.Select(s.rail, s.batch))
+				want = append(want, s.want)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Select() = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -25,6 +25,26 @@ func (c *Client) CreateExternalNetwork(name, ns string, tags, networks []string,
 	return en, nil
 }
 
+// UpdateExternalNetwork updates the external network en in namespace ns.
+func (c *Client) UpdateExternalNetwork(en *gaia.ExternalNetwork, ns string) error {
+
+	if err := c.ac.UpdateInNS(ns, en); err != nil {
+		return fmt.Errorf("update external network %s in ns %s: %v", en.Name, ns, err)
+	}
+
+	return nil
+}
+
+// DeleteExternalNetwork removes the external network en from namespace ns.
+func (c *Client) DeleteExternalNetwork(en *gaia.ExternalNetwork, ns string) error {
+
+	if err := c.ac.DeleteInNS(ns, en); err != nil {
+		return fmt.Errorf("delete external network %s from ns %s: %v", en.Name, ns, err)
+	}
+
+	return nil
+}
+
 // CreateRandExternalNetwork creates a random (intended to match nothing) external network in
 // namespace ns. Will create nTags random associated tags.
 func (c *Client) CreateRandExternalNetwork(ns string, propagate bool,
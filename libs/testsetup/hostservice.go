@@ -0,0 +1,65 @@
+package testsetup
+
+import (
+	"fmt"
+
+	"go.aporeto.io/gaia"
+)
+
+// CreateHostService creates a host service in namespace ns, identified by name, tagged with tags
+// (user tags) and exposing services (e.g. tcp/22). In host mode, the service protects the whole
+// host instead of the listed services.
+func (c *Client) CreateHostService(name, ns string, tags, services []string, hostMode,
+	propagate bool) (*gaia.HostService, error) {
+
+	hs := gaia.NewHostService()
+	hs.Name = name
+	hs.AssociatedTags = tags
+	hs.Services = services
+	hs.HostModeEnabled = hostMode
+	hs.Propagate = propagate
+
+	if err := c.ac.CreateInNS(ns, hs); err != nil {
+		return nil, fmt.Errorf("create host service %s: %v", name, err)
+	}
+
+	return hs, nil
+}
+
+// DeleteHostService removes the host service hs from namespace ns.
+func (c *Client) DeleteHostService(hs *gaia.HostService, ns string) error {
+
+	if err := c.ac.DeleteInNS(ns, hs); err != nil {
+		return fmt.Errorf("delete host service %s from ns %s: %v", hs.Name, ns, err)
+	}
+
+	return nil
+}
+
+// CreateHostServices creates the host services hss in namespace ns, and a host service mapping
+// policy named name mapping them to the enforcers matching enforcers. The host services and the
+// mapping policy are propagated to the children namespaces, where the enforcers usually are.
+func (c *Client) CreateHostServices(name, ns string, hss []*gaia.HostService,
+	enforcers [][]string) (*gaia.HostServiceMappingPolicy, error) {
+
+	var object [][]string
+	for _, hs := range hss {
+		if _, err := c.CreateHostService(hs.Name, ns, hs.AssociatedTags, hs.Services,
+			hs.HostModeEnabled, true); err != nil {
+			return nil, err
+		}
+		object = append(object, hs.AssociatedTags)
+	}
+
+	hsmp := gaia.NewHostServiceMappingPolicy()
+	hsmp.Name = name
+	hsmp.Subject = enforcers
+	hsmp.Object = object
+	hsmp.Propagate = true
+
+	if err := c.ac.CreateInNS(ns, hsmp); err != nil {
+		return nil, fmt.Errorf("create host service mapping policy %s: %v", name, err)
+	}
+
+	return hsmp, nil
+}
@@ -15,6 +15,10 @@ import (
 // MaxAporetoDepth is the maximum namespace depth in an Aporeto control plane.
 const MaxAporetoDepth = 16
 
+// NSTreeRoot is the placeholder of the root namespace of an NSTree in the $namespace tags of its
+// setups.
+const NSTreeRoot = "{{root}}"
+
 // An NSTree is a namespace hierarchy (a tree of namespaces). It can be readily parsed and
 // serialized from / to yaml and json. Example yaml input:
 //   name: root
@@ -29,11 +33,18 @@ const MaxAporetoDepth = 16
 //     tags:
 //     - "level=1"
 //  	 children: []
+//
+// A namespace may also have a setup: its tag prefixes and objects, written like a template (see
+// NamespaceSetup), as exported by ExportNSTree. The $namespace tags of the policies selecting the
+// root of the tree or its children are written relative to the placeholder NSTreeRoot (e.g.
+// $namespace={{root}}/c1), replaced by the actual root when created.
 type NSTree struct {
 	// Name is the name of the namespace.
 	Name string `json:"name" yaml:"name"`
 	// Tags are the (user) tags attached to this namespace.
 	Tags []string `json:"tags" yaml:"tags"`
+	// Setup is the tag prefixes and objects of this namespace, if any.
+	Setup *NamespaceSetup `json:"setup,omitempty" yaml:"setup,omitempty"`
 	// Children are the children namespaces of this namespace.
 	Children []NSTree `json:"children" yaml:"children"`
 }
@@ -52,16 +63,24 @@ func NSDepth(ns string) (int, error) {
 }
 
 // CreateNSTree creates the namespace hierarchy defined in nst, in namespace ns. If nst is nil, this
-// is a no-op.
+// is a no-op. The namespaces with a setup are created with its tag prefixes, if any, instead of
+// tagPrexifixes, and its objects, NSTreeRoot being replaced by the root of the created tree.
 func (c *Client) CreateNSTree(ns string, nst *NSTree, tagPrexifixes []string) error {
 
-	// TODO OPT: Optimize by creating all children of a single ns with a single mctx
-	// TODO OPT: Parallelize? (if we can make concurrent requests to the backend using m)
-
 	if nst == nil {
 		// Nothing to do
 		return nil
 	}
+
+	return c.createNSTree(ns, nst, tagPrexifixes, path.Join(ns, nst.Name))
+}
+
+// createNSTree creates the namespace hierarchy nst in namespace ns, within the tree rooted at root.
+func (c *Client) createNSTree(ns string, nst *NSTree, tagPrexifixes []string, root string) error {
+
+	// TODO OPT: Optimize by creating all children of a single ns with a single mctx
+	// TODO OPT: Parallelize? (if we can make concurrent requests to the backend using m)
+
 	// NOTE: path.Join does exactly what we want (always use '/', only root path ending in '/').
 	newRoot := path.Join(ns, nst.Name)
 
@@ -79,14 +98,22 @@ func (c *Client) CreateNSTree(ns string, nst *NSTree, tagPrexifixes []string) er
 	n.Name = nst.Name
 	n.AssociatedTags = nst.Tags
 	n.TagPrefixes = tagPrexifixes
+	if nst.Setup != nil && len(nst.Setup.TagPrefixes) > 0 {
+		n.TagPrefixes = nst.Setup.TagPrefixes
+	}
 
 	if err := c.ac.CreateInNS(ns, n); err != nil {
 		return fmt.Errorf("create namespace %s: %v", n.Name, err)
 	}
+	if nst.Setup != nil {
+		if err := c.applySetup(newRoot, nst.Setup.reroot(NSTreeRoot, root)); err != nil {
+			return fmt.Errorf("setup namespace %s: %v", n.Name, err)
+		}
+	}
 
 	// Create child namespaces recursively
 	for _, cn := range nst.Children {
-		if err := c.CreateNSTree(newRoot, &cn, tagPrexifixes); err != nil {
+		if err := c.createNSTree(newRoot, &cn, tagPrexifixes, root); err != nil {
 			return fmt.Errorf("create %s and children: %v", cn.Name, err)
 		}
 	}
@@ -94,6 +121,165 @@ func (c *Client) CreateNSTree(ns string, nst *NSTree, tagPrexifixes []string) er
 	return nil
 }
 
+// An ExportOption configures the export of a namespace tree.
+type ExportOption func(*exportOptions)
+
+type exportOptions struct {
+	setup bool
+}
+
+// OptionExportSetup exports the setup of every namespace: its tag prefixes, external networks,
+// network policies, host services and host service mapping policies.
+func OptionExportSetup() ExportOption {
+
+	return func(o *exportOptions) {
+		o.setup = true
+	}
+}
+
+// ExportNSTree returns the live namespace hierarchy rooted at ns, with the tags of the namespaces,
+// which CreateNSTree reproduces in another namespace or backend. The $namespace tags of the
+// exported setups selecting ns or its children are made relative to NSTreeRoot.
+func (c *Client) ExportNSTree(ns string, opts ...ExportOption) (*NSTree, error) {
+
+	o := &exportOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	root := gaia.NamespacesList{}
+	if err := c.retrieveMany(path.Dir(ns), &root, manipulate.ContextOptionFilter(
+		elemental.NewFilterComposer().WithKey("name").Equals(ns).Done())); err != nil {
+		return nil, fmt.Errorf("retrieve %s: %v", ns, err)
+	}
+	if len(root) != 1 {
+		return nil, fmt.Errorf("found %d namespaces named %s", len(root), ns)
+	}
+
+	return c.exportNS(root[0], ns, o)
+}
+
+// exportNS returns the hierarchy rooted at the namespace n, within the tree exported from root.
+func (c *Client) exportNS(n *gaia.Namespace, root string, o *exportOptions) (*NSTree, error) {
+
+	nst := &NSTree{Name: path.Base(n.Name), Tags: n.AssociatedTags}
+	if o.setup {
+		s, err := c.exportSetup(n)
+		if err != nil {
+			return nil, err
+		}
+		nst.Setup = s.reroot(root, NSTreeRoot)
+	}
+
+	children := gaia.NamespacesList{}
+	if err := c.retrieveMany(n.Name, &children); err != nil {
+		return nil, fmt.Errorf("retrieve the children of %s: %v", n.Name, err)
+	}
+	for _, child := range children {
+		cnst, err := c.exportNS(child, root, o)
+		if err != nil {
+			return nil, err
+		}
+		nst.Children = append(nst.Children, *cnst)
+	}
+
+	return nst, nil
+}
+
+// exportSetup returns the setup of the namespace n, the objects stripped of their identifiers.
+func (c *Client) exportSetup(n *gaia.Namespace) (*NamespaceSetup, error) {
+
+	ens := gaia.ExternalNetworksList{}
+	nps := gaia.NetworkRuleSetPolicysList{}
+	hss := gaia.HostServicesList{}
+	hsps := gaia.HostServiceMappingPolicysList{}
+	for _, list := range []elemental.Identifiables{&ens, &nps, &hss, &hsps} {
+		if err := c.retrieveMany(n.Name, list); err != nil {
+			return nil, fmt.Errorf("retrieve the %s of %s: %v", list.Identity().Category,
+				n.Name, err)
+		}
+	}
+
+	s := &NamespaceSetup{TagPrefixes: n.TagPrefixes}
+	for _, en := range ens {
+		en.ID, en.Namespace, en.NormalizedTags = "", "", nil
+		s.ExternalNetworks = append(s.ExternalNetworks, en)
+	}
+	for _, np := range nps {
+		np.ID, np.Namespace, np.NormalizedTags = "", "", nil
+		s.NetworkPolicies = append(s.NetworkPolicies, np)
+	}
+	for _, hs := range hss {
+		hs.ID, hs.Namespace = "", ""
+		s.HostServices = append(s.HostServices, hs)
+	}
+	for _, hsp := range hsps {
+		hsp.ID, hsp.Namespace = "", ""
+		s.HostServiceMappingPolicies = append(s.HostServiceMappingPolicies, hsp)
+	}
+
+	return s, nil
+}
+
+// reroot returns a copy of s, in which the $namespace tags of the policies selecting from or its
+// children are rewritten relative to to.
+func (s *NamespaceSetup) reroot(from, to string) *NamespaceSetup {
+
+	r := *s
+	r.NetworkPolicies = make([]*gaia.NetworkRuleSetPolicy, len(s.NetworkPolicies))
+	for i, np := range s.NetworkPolicies {
+		np = np.DeepCopy()
+		np.Subject = rerootClauses(np.Subject, from, to)
+		for _, rule := range append(np.IncomingRules, np.OutgoingRules...) {
+			rule.Object = rerootClauses(rule.Object, from, to)
+		}
+		r.NetworkPolicies[i] = np
+	}
+	r.HostServiceMappingPolicies = make([]*gaia.HostServiceMappingPolicy,
+		len(s.HostServiceMappingPolicies))
+	for i, hsp := range s.HostServiceMappingPolicies {
+		hsp = hsp.DeepCopy()
+		hsp.Subject = rerootClauses(hsp.Subject, from, to)
+		hsp.Object = rerootClauses(hsp.Object, from, to)
+		r.HostServiceMappingPolicies[i] = hsp
+	}
+
+	return &r
+}
+
+// rerootClauses rewrites the $namespace tags of clauses selecting from or its children relative to
+// to.
+func rerootClauses(clauses [][]string, from, to string) [][]string {
+
+	const key = "$namespace="
+	for _, clause := range clauses {
+		for i, tag := range clause {
+			ns := strings.TrimPrefix(tag, key)
+			if ns != tag && (ns == from || strings.HasPrefix(ns, from+"/")) {
+				clause[i] = key + to + strings.TrimPrefix(ns, from)
+			}
+		}
+	}
+
+	return clauses
+}
+
+// retrieveMany retrieves the objects of namespace ns (not of its children) into dest, prepending
+// mctxopts to the options used to create the manipulator's context.
+func (c *Client) retrieveMany(ns string, dest elemental.Identifiables,
+	mctxopts ...manipulate.ContextOption) error {
+
+	ctx := context.Background()
+	if c.ac.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.ac.Timeout)
+		defer cancel()
+	}
+	mctxopts = append(mctxopts, manipulate.ContextOptionNamespace(ns))
+
+	return c.ac.Manipulator.RetrieveMany(manipulate.NewContext(ctx, mctxopts...), dest)
+}
+
 // DeleteNS deletes ns.
 func (c *Client) DeleteNS(ns string) error {
 
@@ -141,7 +327,7 @@ func (c *Client) DeleteNS(ns string) error {
 }
 
 // BasicNamespaceSetup creates a namespace, and basic external networks, and
-// network policies:
+// network policies (see the basic template):
 // Allow ssh traffic towards the namespace
 // Allow traffic from PU to all TCP/UDP
 // Allow RDP towards the namespace
@@ -150,80 +336,10 @@ func (c *Client) DeleteNS(ns string) error {
 // policy.
 func (c *Client) BasicNamespaceSetup(ns string, propagate bool) error {
 
-	var err error
-
-	// Create the test namespace.
-	if err = c.CreateNSTree(path.Dir(ns), &NSTree{
-		Name: path.Base(ns),
-	},
-		[]string{
-			"externalnetwork:name=",
-			"role=",
-			"type=",
-		},
-	); err != nil {
-		return fmt.Errorf("creating namespace %s: %v", ns, err)
-	}
-
-	_, err = c.CreateExternalNetwork("TCP/UDP all ports",
-		ns,
-		[]string{"externalnetwork:name=all"},
-		[]string{QuadZeroRoute},
-		false)
+	t, err := LoadTemplate("basic")
 	if err != nil {
-		return fmt.Errorf("create external network TCP/UDP all ports: %v", err)
+		return err
 	}
 
-	_, err = c.CreateExternalNetwork("ssh",
-		ns,
-		[]string{"externalnetwork:name=ssh"},
-		[]string{QuadZeroRoute},
-		false)
-	if err != nil {
-		return fmt.Errorf("create external network UDP all ports: %v", err)
-	}
-
-	_, err = c.CreateExternalNetwork("rdp",
-		ns,
-		[]string{"externalnetwork:name=rdp"},
-		[]string{QuadZeroRoute},
-		false)
-	if err != nil {
-		return fmt.Errorf("create external network UDP all ports: %v", err)
-	}
-
-	incomings := []*gaia.NetworkRule{
-		CreateNewNetworkRule("allow-ssh", gaia.NetworkRuleActionAllow,
-			[][]string{{"externalnetwork:name=ssh"}},
-			[]string{"tcp/22"}),
-		CreateNewNetworkRule("allow-rdp", gaia.NetworkRuleActionAllow,
-			[][]string{{"externalnetwork:name=rdp"}},
-			[]string{"tcp/3389"}),
-		CreateNewNetworkRule("allow-ptp", gaia.NetworkRuleActionAllow,
-			[][]string{{"$identity=processingunit"}},
-			[]string{"any"}),
-	}
-
-	outgoings := []*gaia.NetworkRule{
-		CreateNewNetworkRule("allow-all", gaia.NetworkRuleActionAllow,
-			[][]string{{"externalnetwork:name=all"}},
-			[]string{"any"}),
-		CreateNewNetworkRule("allow-ptp", gaia.NetworkRuleActionAllow,
-			[][]string{{"$identity=processingunit"}},
-			[]string{"any"}),
-	}
-
-	// Create the relevant network policies.
-	_, err = c.CreateNetworkPolicy(
-		"basic-setup",
-		ns,
-		[]string{"setup=basic"},
-		[][]string{{"$namespace=" + ns}},
-		propagate,
-		incomings, outgoings)
-	if err != nil {
-		return fmt.Errorf("create network policy Allow ssh traffic: %v", err)
-	}
-
-	return nil
+	return c.SetupNamespace(ns, t, TemplateVars{Propagate: propagate})
 }
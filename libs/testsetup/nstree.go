@@ -0,0 +1,120 @@
+package testsetup
+
+import (
+	"fmt"
+	"path"
+)
+
+// An NSShape is the shape of a generated namespace tree.
+type NSShape string
+
+// Shapes of the generated namespace trees, for a branching factor b and a depth d below the root.
+const (
+	// NSShapeFlat is the root and its b children, whatever d.
+	NSShapeFlat NSShape = "flat"
+	// NSShapeChain is a chain of d namespaces below the root, one per level.
+	NSShapeChain NSShape = "chain"
+	// NSShapeWide is a complete tree: every namespace above d has b children, b^d leaves.
+	NSShapeWide NSShape = "wide"
+	// NSShapeSkewed is a tree whose namespaces above d have b children, only the first of which
+	// has children: the leaves are spread over all the levels.
+	NSShapeSkewed NSShape = "skewed"
+)
+
+// NSShapes are the shapes of the generated namespace trees.
+var NSShapes = []NSShape{NSShapeFlat, NSShapeChain, NSShapeWide, NSShapeSkewed}
+
+// GenerateNSTree returns a namespace tree named name of the given shape, with branching factor
+// branching and depth levels below the root. Every namespace is named after its parent and its
+// index (e.g. name-0-1), and tagged with its level (the root being 0). The absolute depth of the
+// namespaces is only checked once created, against MaxAporetoDepth.
+func GenerateNSTree(name string, shape NSShape, branching, depth int) (*NSTree, error) {
+
+	if depth < 1 || depth > MaxAporetoDepth {
+		return nil, fmt.Errorf("depth %d not in [1, %d]", depth, MaxAporetoDepth)
+	}
+	if branching < 1 {
+		return nil, fmt.Errorf("invalid branching factor %d", branching)
+	}
+
+	var children func(level, i int) int
+	switch shape {
+	case NSShapeFlat:
+		depth = 1
+		children = func(int, int) int { return branching }
+	case NSShapeChain:
+		children = func(int, int) int { return 1 }
+	case NSShapeWide:
+		children = func(int, int) int { return branching }
+	case NSShapeSkewed:
+		children = func(level, i int) int {
+			if level > 0 && i > 0 {
+				return 0
+			}
+			return branching
+		}
+	default:
+		return nil, fmt.Errorf("unknown namespace tree shape %q, expected one of %v", shape,
+			NSShapes)
+	}
+
+	var grow func(name string, level, i int) NSTree
+	grow = func(name string, level, i int) NSTree {
+		nst := NSTree{
+			Name: name,
+			Tags: []string{fmt.Sprintf("level=%d", level), "creator=simulator-test-harness"},
+		}
+		if level == depth {
+			return nst
+		}
+		for c := 0; c < children(level, i); c++ {
+			nst.Children = append(nst.Children, grow(fmt.Sprintf("%s-%d", name, c), level+1, c))
+		}
+		return nst
+	}
+
+	nst := grow(name, 0, 0)
+	return &nst, nil
+}
+
+// Size returns the number of namespaces of nst.
+func (nst *NSTree) Size() int {
+
+	size := 1
+	for i := range nst.Children {
+		size += nst.Children[i].Size()
+	}
+
+	return size
+}
+
+// Depth returns the number of levels of nst below its root.
+func (nst *NSTree) Depth() int {
+
+	depth := 0
+	for i := range nst.Children {
+		if d := nst.Children[i].Depth() + 1; d > depth {
+			depth = d
+		}
+	}
+
+	return depth
+}
+
+// Namespaces returns the namespaces of nst created in namespace ns, grouped by level from the
+// root.
+func (nst *NSTree) Namespaces(ns string) [][]string {
+
+	namespace := path.Join(ns, nst.Name)
+	levels := [][]string{{namespace}}
+	for i := range nst.Children {
+		for l, nss := range nst.Children[i].Namespaces(namespace) {
+			if l+1 == len(levels) {
+				levels = append(levels, nil)
+			}
+			levels[l+1] = append(levels[l+1], nss...)
+		}
+	}
+
+	return levels
+}
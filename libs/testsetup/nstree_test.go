@@ -0,0 +1,293 @@
+package testsetup
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"testing"
+
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+	"go.aporeto.io/simulator-test-harness/libs/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+func TestGenerateNSTree(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		shape     NSShape
+		branching int
+		depth     int
+		// levels are the numbers of namespaces per level.
+		levels  []int
+		wantErr bool
+	}{
+		{
+			name:      "flat",
+			shape:     NSShapeFlat,
+			branching: 3,
+			depth:     5,
+			levels:    []int{1, 3},
+		},
+		{
+			name:      "chain to the max depth",
+			shape:     NSShapeChain,
+			branching: 3,
+			depth:     MaxAporetoDepth,
+			levels:    []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+		},
+		{
+			name:      "wide and deep",
+			shape:     NSShapeWide,
+			branching: 2,
+			depth:     3,
+			levels:    []int{1, 2, 4, 8},
+		},
+		{
+			name:      "skewed",
+			shape:     NSShapeSkewed,
+			branching: 3,
+			depth:     3,
+			levels:    []int{1, 3, 3, 3},
+		},
+		{
+			name:      "too deep",
+			shape:     NSShapeChain,
+			branching: 1,
+			depth:     MaxAporetoDepth + 1,
+			wantErr:   true,
+		},
+		{
+			name:      "no branching",
+			shape:     NSShapeWide,
+			branching: 0,
+			depth:     2,
+			wantErr:   true,
+		},
+		{
+			name:      "unknown shape",
+			shape:     "round",
+			branching: 2,
+			depth:     2,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nst, err := GenerateNSTree("tenant", tt.shape, tt.branching, tt.depth)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateNSTree() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var levels []int
+			size := 0
+			for _, nss := range nst.Namespaces("/base") {
+				levels = append(levels, len(nss))
+				size += len(nss)
+			}
+			if !reflect.DeepEqual(levels, tt.levels) {
+				t.Errorf("Namespaces() per level = %v, want %v", levels, tt.levels)
+			}
+			if nst.Size() != size || nst.Depth() != len(tt.levels)-1 {
+				t.Errorf("Size() = %d, Depth() = %d, want %d and %d", nst.Size(), nst.Depth(),
+					size, len(tt.levels)-1)
+			}
+
+			// The generated trees are written in the documented yaml format.
+			data, err := yaml.Marshal(nst)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			var got NSTree
+			if err := yaml.Unmarshal(data, &got); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if got.Size() != size {
+				t.Errorf("unmarshalled %d namespaces, want %d", got.Size(), size)
+			}
+		})
+	}
+}
+
+// A fakeBackend is a manipulator storing the objects of every namespace, the namespaces named
+// after their full path as in a backend.
+type fakeBackend struct {
+	manipulate.Manipulator
+	objects map[string][]elemental.Identifiable
+	ids     int
+}
+
+func (f *fakeBackend) Create(mctx manipulate.Context, object elemental.Identifiable) error {
+
+	f.ids++
+	object.SetIdentifier(fmt.Sprintf("id-%d", f.ids))
+	if n, ok := object.(*gaia.Namespace); ok {
+		n.Name = path.Join(mctx.Namespace(), n.Name)
+	}
+	f.objects[mctx.Namespace()] = append(f.objects[mctx.Namespace()], object)
+	return nil
+}
+
+func (f *fakeBackend) RetrieveMany(mctx manipulate.Context, dest elemental.Identifiables) error {
+
+	for _, object := range f.objects[mctx.Namespace()] {
+		switch o := object.(type) {
+		case *gaia.Namespace:
+			if l, ok := dest.(*gaia.NamespacesList); ok {
+				*l = append(*l, o.DeepCopy())
+			}
+		case *gaia.ExternalNetwork:
+			if l, ok := dest.(*gaia.ExternalNetworksList); ok {
+				*l = append(*l, o.DeepCopy())
+			}
+		case *gaia.NetworkRuleSetPolicy:
+			if l, ok := dest.(*gaia.NetworkRuleSetPolicysList); ok {
+				*l = append(*l, o.DeepCopy())
+			}
+		case *gaia.HostServiceMappingPolicy:
+			if l, ok := dest.(*gaia.HostServiceMappingPolicysList); ok {
+				*l = append(*l, o.DeepCopy())
+			}
+		}
+	}
+	return nil
+}
+
+func TestExportNSTree(t *testing.T) {
+
+	src := &Client{ac: utils.APIClient{
+		Manipulator: &fakeBackend{objects: map[string][]elemental.Identifiable{}}}}
+	nst, err := GenerateNSTree("tenant", NSShapeSkewed, 2, 3)
+	if err != nil {
+		t.Fatalf("GenerateNSTree() error = %v", err)
+	}
+	nst.Children[0].Setup = &NamespaceSetup{
+		TagPrefixes: []string{"app"},
+		ExternalNetworks: []*gaia.ExternalNetwork{
+			{Name: "internet", AssociatedTags: []string{"ext=internet"},
+				Entries: []string{"0.0.0.0/0"}},
+		},
+		NetworkPolicies: []*gaia.NetworkRuleSetPolicy{
+			{Name: "pu-to-pu", Subject: [][]string{{"$namespace=/base/tenant/tenant-0"}},
+				Propagate: true, IncomingRules: []*gaia.NetworkRule{{Name: "from-tenant",
+					Object: [][]string{{"$namespace=/base/tenant"}, {"$namespace=/base"}}}}},
+		},
+		HostServiceMappingPolicies: []*gaia.HostServiceMappingPolicy{
+			{Name: "ssh", Subject: [][]string{{"$identity=enforcer",
+				"$namespace=/base/tenant/tenant-0/tenant-0-0"}}},
+		},
+	}
+	if err := src.CreateNSTree("/base", nst, nil); err != nil {
+		t.Fatalf("CreateNSTree() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts []ExportOption
+		// setup is the setup of the first child, if exported.
+		setup *NamespaceSetup
+	}{
+		{
+			name: "namespaces",
+		},
+		{
+			name:  "namespaces and setup",
+			opts:  []ExportOption{OptionExportSetup()},
+			setup: nst.Children[0].Setup,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exported, err := src.ExportNSTree("/base/tenant", tt.opts...)
+			if err != nil {
+				t.Fatalf("ExportNSTree() error = %v", err)
+			}
+			got, want := exported.Namespaces("/clone"), nst.Namespaces("/clone")
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("ExportNSTree() namespaces = %v, want %v", got, want)
+			}
+			if !reflect.DeepEqual(exported.Children[1].Tags, nst.Children[1].Tags) {
+				t.Errorf("ExportNSTree() tags = %v, want %v", exported.Children[1].Tags,
+					nst.Children[1].Tags)
+			}
+
+			// The exported tree is reproduced by CreateNSTree once written in yaml.
+			data, err := yaml.Marshal(exported)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			var imported NSTree
+			if err := yaml.Unmarshal(data, &imported); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			dst := &Client{ac: utils.APIClient{
+				Manipulator: &fakeBackend{objects: map[string][]elemental.Identifiable{}}}}
+			if err := dst.CreateNSTree("/clone/copy", &imported, nil); err != nil {
+				t.Fatalf("CreateNSTree() error = %v", err)
+			}
+			cloned, err := dst.ExportNSTree("/clone/copy/tenant", OptionExportSetup())
+			if err != nil {
+				t.Fatalf("ExportNSTree() error = %v", err)
+			}
+			if cloned.Size() != nst.Size() {
+				t.Errorf("cloned %d namespaces, want %d", cloned.Size(), nst.Size())
+			}
+
+			setup := cloned.Children[0].Setup
+			if tt.setup == nil {
+				if len(setup.TagPrefixes) > 0 || len(setup.NetworkPolicies) > 0 {
+					t.Errorf("cloned setup %+v without exporting it", setup)
+				}
+				return
+			}
+			if !reflect.DeepEqual(setup.TagPrefixes, tt.setup.TagPrefixes) ||
+				len(setup.ExternalNetworks) != 1 || len(setup.NetworkPolicies) != 1 {
+				t.Fatalf("cloned setup %+v, want %+v", setup, tt.setup)
+			}
+			if en := setup.ExternalNetworks[0]; en.Name != "internet" ||
+				!reflect.DeepEqual(en.Entries, []string{"0.0.0.0/0"}) {
+				t.Errorf("cloned external network %+v", en)
+			}
+			if np := setup.NetworkPolicies[0]; np.Name != "pu-to-pu" || !np.Propagate {
+				t.Errorf("cloned network policy %+v", np)
+			}
+
+			// The $namespace tags within the tree are re-rooted, the others are kept.
+			subject := exported.Children[0].Setup.NetworkPolicies[0].Subject
+			if subject[0][0] != "$namespace={{root}}/tenant-0" {
+				t.Errorf("exported subject %v, want it relative to the root", subject)
+			}
+			var np *gaia.NetworkRuleSetPolicy
+			var hsp *gaia.HostServiceMappingPolicy
+			objects := dst.ac.Manipulator.(*fakeBackend).objects
+			for _, object := range objects["/clone/copy/tenant/tenant-0"] {
+				switch o := object.(type) {
+				case *gaia.NetworkRuleSetPolicy:
+					np = o
+				case *gaia.HostServiceMappingPolicy:
+					hsp = o
+				}
+			}
+			if np == nil || hsp == nil {
+				t.Fatalf("policies not created in the clone")
+			}
+			object := [][]string{{"$namespace=/clone/copy/tenant"}, {"$namespace=/base"}}
+			if np.Subject[0][0] != "$namespace=/clone/copy/tenant/tenant-0" ||
+				!reflect.DeepEqual(np.IncomingRules[0].Object, object) {
+				t.Errorf("created policy subject %v and object %v, want them in /clone/copy",
+					np.Subject, np.IncomingRules[0].Object)
+			}
+			if hsp.Subject[0][1] != "$namespace=/clone/copy/tenant/tenant-0/tenant-0-0" {
+				t.Errorf("created host service mapping subject %v, want it in /clone/copy",
+					hsp.Subject)
+			}
+		})
+	}
+}
@@ -2,8 +2,11 @@ package testsetup
 
 import (
 	"fmt"
+	"reflect"
 
+	"go.aporeto.io/elemental"
 	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
 	"go.aporeto.io/simulator-test-harness/common"
 )
 
@@ -98,6 +101,26 @@ func (c *Client) CreateNetworkPolicy(name, ns string, tags []string, subject [][
 	return np, nil
 }
 
+// UpdateNetworkPolicy updates the network access policy np in namespace ns.
+func (c *Client) UpdateNetworkPolicy(np *gaia.NetworkRuleSetPolicy, ns string) error {
+
+	if err := c.ac.UpdateInNS(ns, np); err != nil {
+		return fmt.Errorf("update network policy %s in ns %s: %v", np.Name, ns, err)
+	}
+
+	return nil
+}
+
+// DeleteNetworkPolicy removes the network access policy np from namespace ns.
+func (c *Client) DeleteNetworkPolicy(np *gaia.NetworkRuleSetPolicy, ns string) error {
+
+	if err := c.ac.DeleteInNS(ns, np); err != nil {
+		return fmt.Errorf("delete network policy %s from ns %s: %v", np.Name, ns, err)
+	}
+
+	return nil
+}
+
 // CreateRandNetworkPolicy creates a random (intended to match nothing) network access policy in
 // namespace ns.
 func (c *Client) CreateRandNetworkPolicy(ns string,
@@ -158,6 +181,31 @@ func (c *Client) CreateNSMappingPolicy(name, ns string, tags []string, subject [
 	return mp, nil
 }
 
+// DeleteNSMappingPolicies removes the namespace mapping policies identified by name and mapping
+// subject from namespace ns (not from its children), and returns the number of policies removed.
+func (c *Client) DeleteNSMappingPolicies(name, ns string, subject [][]string) (int, error) {
+
+	mps := gaia.NamespaceMappingPolicysList{}
+	if err := c.retrieveMany(ns, &mps, manipulate.ContextOptionFilter(
+		elemental.NewFilterComposer().WithKey("name").Equals(name).Done())); err != nil {
+		return 0, fmt.Errorf("retrieve ns mapping policies %s of ns %s: %v", name, ns, err)
+	}
+
+	deleted := 0
+	for _, mp := range mps {
+		if !reflect.DeepEqual(mp.Subject, subject) {
+			continue
+		}
+		if err := c.ac.DeleteInNS(ns, mp); err != nil {
+			return deleted, fmt.Errorf("delete ns mapping policy %s from ns %s: %v", name, ns,
+				err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
 // CreateRandNSMappingPolicy creates a random (intended to match nothing) namespace mapping policy
 // in namespace ns.
 func (c *Client) CreateRandNSMappingPolicy(ns string) (*gaia.NamespaceMappingPolicy, error) {
@@ -202,6 +250,16 @@ func (c *Client) CreateAPIAuthPolicy(name, ns string, tags, roles []string,
 	return aap, nil
 }
 
+// DeleteAPIAuthPolicy removes the API authorization policy aap from namespace ns.
+func (c *Client) DeleteAPIAuthPolicy(aap *gaia.APIAuthorizationPolicy, ns string) error {
+
+	if err := c.ac.DeleteInNS(ns, aap); err != nil {
+		return fmt.Errorf("delete API authorization policy %s from ns %s: %v", aap.Name, ns, err)
+	}
+
+	return nil
+}
+
 // CreateRandAPIAuthPolicy creates a random (intended to match nothing) API authorization policy in
 // namespace ns (applying to ns and its children).
 func (c *Client) CreateRandAPIAuthPolicy(ns string) (*gaia.APIAuthorizationPolicy, error) {
@@ -223,14 +281,14 @@ func (c *Client) CreateRandAPIAuthPolicy(ns string) (*gaia.APIAuthorizationPolic
 // TODO OPT: Move this to a separate package under simulator-test-harness/libs? (as it's not really setup)
 
 // RenderPolicies returns the policies that would apply to a PU in namespace ns, identified by name
-// and tagged with tags (user tags).
+// and tagged with tags (user tags), of type puType.
 func (c *Client) RenderPolicies(name, ns string, tags []string,
 	puType gaia.ProcessingUnitTypeValue) (*gaia.RenderedPolicy, error) {
 
 	pu := gaia.NewProcessingUnit()
 	pu.Name = name
 	pu.AssociatedTags = tags
-	pu.Type = gaia.ProcessingUnitTypeHost
+	pu.Type = puType
 
 	rp := gaia.NewRenderedPolicy()
 	rp.ProcessingUnit = pu
@@ -0,0 +1,163 @@
+package testsetup
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.aporeto.io/gaia"
+	"gopkg.in/yaml.v3"
+)
+
+// templates are the built-in namespace setup templates, named after their file.
+//
+//go:embed templates/*.yaml
+var templates embed.FS
+
+// A Template is a namespace setup template: the yaml of a NamespaceSetup, in which the
+// placeholders {{ns}}, {{prefix}} and {{propagate}} are replaced when applied to a namespace.
+type Template struct {
+	// Name is the name of a built-in template, or the path to the template file.
+	Name string
+	data []byte
+}
+
+// A NamespaceSetup is the objects created in a namespace by a template. The gaia objects are
+// written with their lowercase field names, as in the plans.
+type NamespaceSetup struct {
+	// TagPrefixes are the tag prefixes of the namespace, if created.
+	TagPrefixes                []string                         `yaml:"tagPrefixes"`
+	ExternalNetworks           []*gaia.ExternalNetwork          `yaml:"externalNetworks"`
+	NetworkPolicies            []*gaia.NetworkRuleSetPolicy     `yaml:"networkPolicies"`
+	HostServices               []*gaia.HostService              `yaml:"hostServices"`
+	HostServiceMappingPolicies []*gaia.HostServiceMappingPolicy `yaml:"hostServiceMappingPolicies"`
+}
+
+// TemplateVars are the values of the placeholders of a template, besides the namespace.
+type TemplateVars struct {
+	// Prefix is prepended to the names and tag values of the objects, to tell apart the objects of
+	// several setups.
+	Prefix string
+	// Propagate is the value of {{propagate}}, with which the templates propagate their objects to
+	// the children namespaces.
+	Propagate bool
+}
+
+// Templates returns the names of the built-in templates, sorted.
+func Templates() []string {
+
+	entries, _ := templates.ReadDir("templates")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".yaml"))
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// LoadTemplate returns the built-in template name, or the template stored in the file name, which
+// is checked by rendering it.
+func LoadTemplate(name string) (*Template, error) {
+
+	data, err := templates.ReadFile(path.Join("templates", name+".yaml"))
+	if err != nil {
+		if data, err = os.ReadFile(name); err != nil {
+			return nil, fmt.Errorf("template %s is neither a built-in template %v nor a file: %v",
+				name, Templates(), err)
+		}
+	}
+
+	t := &Template{Name: name, data: data}
+	if _, err := t.Render("/check", TemplateVars{}); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Render returns the setup of t for the namespace ns.
+func (t *Template) Render(ns string, vars TemplateVars) (*NamespaceSetup, error) {
+
+	rendered := strings.NewReplacer(
+		"{{ns}}", ns,
+		"{{prefix}}", vars.Prefix,
+		"{{propagate}}", strconv.FormatBool(vars.Propagate),
+	).Replace(string(t.data))
+	if i := strings.Index(rendered, "{{"); i >= 0 {
+		end := strings.Index(rendered[i:], "}}")
+		if end < 0 {
+			end = 0
+		}
+		return nil, fmt.Errorf("template %s: unknown placeholder %q", t.Name,
+			rendered[i:i+end+2])
+	}
+
+	var s NamespaceSetup
+	if err := yaml.Unmarshal([]byte(rendered), &s); err != nil {
+		return nil, fmt.Errorf("template %s: %v", t.Name, err)
+	}
+
+	return &s, nil
+}
+
+// ApplyTemplate creates the objects of the setup of t in the existing namespace ns.
+func (c *Client) ApplyTemplate(ns string, t *Template, vars TemplateVars) error {
+
+	s, err := t.Render(ns, vars)
+	if err != nil {
+		return err
+	}
+
+	return c.applySetup(ns, s)
+}
+
+// applySetup creates the objects of s in the existing namespace ns.
+func (c *Client) applySetup(ns string, s *NamespaceSetup) error {
+
+	for _, en := range s.ExternalNetworks {
+		if _, err := c.CreateExternalNetwork(en.Name, ns, en.AssociatedTags, en.Entries,
+			en.Propagate); err != nil {
+			return err
+		}
+	}
+	for _, np := range s.NetworkPolicies {
+		if _, err := c.CreateNetworkPolicy(np.Name, ns, np.AssociatedTags, np.Subject,
+			np.Propagate, np.IncomingRules, np.OutgoingRules); err != nil {
+			return err
+		}
+	}
+	for _, hs := range s.HostServices {
+		if _, err := c.CreateHostService(hs.Name, ns, hs.AssociatedTags, hs.Services,
+			hs.HostModeEnabled, hs.Propagate); err != nil {
+			return err
+		}
+	}
+	for _, hsp := range s.HostServiceMappingPolicies {
+		if _, err := c.CreateHSMappingPolicy(hsp.Name, ns, hsp.Subject, hsp.Object); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetupNamespace creates the namespace ns, with the tag prefixes of t, and the objects of t.
+func (c *Client) SetupNamespace(ns string, t *Template, vars TemplateVars) error {
+
+	s, err := t.Render(ns, vars)
+	if err != nil {
+		return err
+	}
+
+	if err := c.CreateNSTree(path.Dir(ns), &NSTree{Name: path.Base(ns)},
+		s.TagPrefixes); err != nil {
+		return fmt.Errorf("creating namespace %s: %v", ns, err)
+	}
+
+	return c.ApplyTemplate(ns, t, vars)
+}
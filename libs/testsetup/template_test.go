@@ -0,0 +1,128 @@
+package testsetup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/simulator-test-harness/libs/policyassert"
+)
+
+func TestTemplates(t *testing.T) {
+
+	for _, name := range Templates() {
+		t.Run(name, func(t *testing.T) {
+			tmpl, err := LoadTemplate(name)
+			if err != nil {
+				t.Fatalf("LoadTemplate() error = %v", err)
+			}
+			s, err := tmpl.Render("/base/ns", TemplateVars{Prefix: "p-", Propagate: true})
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if len(s.NetworkPolicies) == 0 {
+				t.Fatalf("no network policies")
+			}
+			for _, np := range s.NetworkPolicies {
+				if !np.Propagate {
+					t.Errorf("policy %s not propagated", np.Name)
+				}
+				for _, clause := range np.Subject {
+					if !policyassert.Match([][]string{{"$namespace=/base/ns"}},
+						clause) {
+						t.Errorf("policy %s selects PUs outside the namespace", np.Name)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTemplateRender(t *testing.T) {
+
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatalf("write %s: %v", file, err)
+		}
+		return file
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		// peer is the tags of the peer of an incoming tcp flow on port 8080 of a PU tagged
+		// tier=p-app, expected to be allowed if want.
+		peer    []string
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:     "three-tier web to app",
+			template: "three-tier",
+			peer:     []string{"$identity=processingunit", "tier=p-web"},
+			want:     true,
+		},
+		{
+			name:     "three-tier db to app",
+			template: "three-tier",
+			peer:     []string{"$identity=processingunit", "tier=p-db"},
+		},
+		{
+			name: "custom template",
+			template: write("custom.yaml", `
+networkPolicies:
+  - name: "{{prefix}}custom"
+    subject: [["$namespace={{ns}}"]]
+    incomingrules:
+      - name: allow
+        action: Allow
+        object: [["app={{prefix}}client"]]
+        protocolports: ["tcp/8000:9000"]
+`),
+			peer: []string{"app=p-client"},
+			want: true,
+		},
+		{
+			name:     "unknown placeholder",
+			template: write("unknown.yaml", `networkPolicies: [{name: "{{name}}"}]`),
+			wantErr:  true,
+		},
+		{
+			name:     "unknown template",
+			template: "missing",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := LoadTemplate(tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			s, err := tmpl.Render("/base/ns", TemplateVars{Prefix: "p-"})
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+
+			tags := []string{"$namespace=/base/ns", "tier=p-app"}
+			var applicable []*gaia.NetworkRuleSetPolicy
+			for _, np := range s.NetworkPolicies {
+				if policyassert.Match(np.Subject, tags) {
+					applicable = append(applicable, np)
+				}
+			}
+			v, _ := policyassert.Evaluate(applicable, &policyassert.Case{
+				Direction: policyassert.Incoming, Protocol: "tcp", Port: 8080, Peer: tt.peer})
+			if got := v == policyassert.Allow; got != tt.want {
+				t.Errorf("got %s, want allowed %v", v, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Command faultproxy is a reverse proxy between the simulated enforcers and the API, injecting the
+// faults of a schedule (see libs/faultproxy). It runs as a sidecar of the simulator pods, or as a
+// shared Service.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.aporeto.io/simulator-test-harness/common"
+	"go.aporeto.io/simulator-test-harness/libs/faultproxy"
+
+	"github.com/sirupsen/logrus"
+)
+
+var log = common.Log
+
+func main() {
+
+	listen := flag.String("listen", ":8443", "Set the address on which the proxy listens")
+	upstream := flag.String("upstream", "", "Set the URL of the API to which requests are forwarded")
+	scheduleFile := flag.String("schedule", "",
+		"Set the path to the fault schedule file, no fault is injected if unset")
+	certFile := flag.String("cert", "",
+		"Set the path to the PEM certificate served, the proxy serves plain HTTP if unset")
+	keyFile := flag.String("key", "", "Set the path to the PEM key of the certificate served")
+	insecure := flag.Bool("insecure-upstream", false,
+		"Skip the verification of the upstream API certificate")
+	seed := flag.Int64("seed", 0,
+		"Set the seed of the selection of the requests affected by the faults, random if 0")
+	logLevel := flag.String("log-level", log.Level.String(),
+		fmt.Sprintf("Set the logger log level, accepts one of: %v", logrus.AllLevels),
+	)
+	flag.Parse()
+
+	lvl, err := logrus.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatalf("parse log level %s: %v", *logLevel, err)
+	}
+	log.SetLevel(lvl)
+
+	if err := run(*listen, *upstream, *scheduleFile, *certFile, *keyFile, *insecure,
+		*seed); err != nil {
+		log.Fatalf("faultproxy: %v", err)
+	}
+}
+
+// run serves the proxy until interrupted.
+func run(listen, upstream, scheduleFile, certFile, keyFile string, insecure bool,
+	seed int64) error {
+
+	schedule := &faultproxy.Schedule{}
+	if scheduleFile != "" {
+		var err error
+		if schedule, err = faultproxy.LoadSchedule(scheduleFile); err != nil {
+			return err
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: insecure, // NOTE: Allows self-signed test backends
+	}
+	opts := []faultproxy.Option{faultproxy.OptionTransport(transport)}
+	if seed != 0 {
+		opts = append(opts, faultproxy.OptionSeed(seed))
+	}
+	p, err := faultproxy.NewProxy(upstream, schedule, opts...)
+	if err != nil {
+		return err
+	}
+
+	var tlsConfig *tls.Config
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("load certificate %s: %v", certFile, err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %v", listen, err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	log.Infof("Proxying %s to %s with %d faults", listen, upstream, len(schedule.Faults))
+	if err := p.Serve(ctx, l, tlsConfig); err != nil && err != context.Canceled {
+		return err
+	}
+
+	return nil
+}
@@ -4,66 +4,279 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"go.aporeto.io/simulator-test-harness/common"
+	"go.aporeto.io/simulator-test-harness/libs/plan"
+	"go.aporeto.io/simulator-test-harness/libs/policyassert"
+	"go.aporeto.io/simulator-test-harness/libs/testsetup"
 
 	"github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v3"
 )
 
 var log = common.Log
 
-// A Config is the configuration for the plan generation parameters.
-type Config struct {
-	Name      string    `yaml:"name"`
-	PUs       int       `yaml:"pus"`
-	PUType    string    `yaml:"pu-type"`
-	PUMeta    []string  `yaml:"pu-meta"`
-	Flows     int       `yaml:"flows"`
-	Lifecycle Lifecycle `yaml:"lifecycle"`
-	Jitter    Jitter    `yaml:"jitter"`
+// commands are the plan-gen subcommands. Running plan-gen without a subcommand generates a plan.
+var commands = map[string]func(fs *flag.FlagSet, args []string) error{
+	"generate": generateCmd,
+	"merge":    mergeCmd,
+	"split":    splitCmd,
+	"scale":    scaleCmd,
+	"rename":   renameCmd,
+	"schema":   schemaCmd,
 }
 
 func main() {
 
-	var configFile string
-	var planFile string
-	flag.StringVar(&configFile, "config", "config.yaml",
-		"Set the path to the test configuration file")
-	flag.StringVar(&planFile, "output", "plan.yaml",
-		"Set the path to the test configuration file")
+	cmd := "generate"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	run, ok := commands[cmd]
+	if !ok {
+		log.Fatalf("unknown command %q, expected one of: generate, merge, split, scale, rename, schema", cmd)
+	}
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	if err := run(fs, args); err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+}
+
+// parseFlags parses args into fs, after adding the flags shared by all commands.
+func parseFlags(fs *flag.FlagSet, args []string) error {
 
 	// Log level parameters
-	logLevel := flag.String("log-level", log.Level.String(),
+	logLevel := fs.String("log-level", log.Level.String(),
 		fmt.Sprintf("Set the logger log level, accepts one of: %v", logrus.AllLevels),
 	)
 
-	flag.Parse()
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
 
 	lvl, err := logrus.ParseLevel(*logLevel)
 	if err != nil {
-		log.Fatalf("parse log level %s: %v", *logLevel, err)
+		return fmt.Errorf("parse log level %s: %v", *logLevel, err)
 	}
 	log.SetLevel(lvl)
 
-	var config Config
-	if err = common.ParseYamlFile(configFile, &config); err != nil {
-		log.Fatalf("unable to parse the %q to config: %v", configFile, err)
+	return nil
+}
+
+// compactFlag adds to fs the flag selecting the compact output format.
+func compactFlag(fs *flag.FlagSet) *bool {
+
+	return fs.Bool("compact", false,
+		"Omit the zero valued fields and model versions of the gaia objects in the output")
+}
+
+// generateCmd generates a new plan from a configuration file.
+func generateCmd(fs *flag.FlagSet, args []string) error {
+
+	configFile := fs.String("config", "config.yaml",
+		"Set the path to the test configuration file")
+	planFile := fs.String("output", "plan.yaml",
+		"Set the path to the generated plan file")
+	policiesFile := fs.String("policies", "",
+		"Set the path to a policy set (e.g. written by policy-gen) computing the flow actions")
+	render := fs.String("render", "",
+		"Set the path to the application credentials of a backend rendering the policies "+
+			"computing the flow actions")
+	namespace := fs.String("namespace", "",
+		"Set the namespace of the PUs, whose policies compute the flow actions")
+	compact := compactFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	config, err := plan.LoadConfig(*configFile)
+	if err != nil {
+		return fmt.Errorf("unable to parse the %q to config: %v", *configFile, err)
+	}
+
+	log.Debugf("The configuration read from %q: %v", *configFile, *config)
+
+	oracle, err := newOracle(*policiesFile, *render, *namespace)
+	if err != nil {
+		return err
+	}
+	var opts []plan.Option
+	if oracle != nil {
+		opts = append(opts, plan.OptionOracle(oracle))
+	}
+
+	pl, err := plan.Generate(config, opts...)
+	if err != nil {
+		return err
 	}
 
-	log.Debugf("The configuration read from %q: %v", configFile, config)
+	return plan.Save(*planFile, pl, plan.OptionCompact(*compact))
+}
+
+// newOracle returns the oracle of the policies of policiesFile, or rendered by the backend of the
+// credentials render, for the PUs of ns, or nil if neither is set.
+func newOracle(policiesFile, render, ns string) (*policyassert.Oracle, error) {
 
-	if config.Name == "" {
-		config.Name = "auto-generate-plan"
+	switch {
+	case policiesFile == "" && render == "":
+		return nil, nil
+	case policiesFile != "" && render != "":
+		return nil, fmt.Errorf("policies and render are mutually exclusive")
+	case ns == "":
+		return nil, fmt.Errorf("no namespace of the PUs")
+	case policiesFile != "":
+		policies, err := policyassert.LoadPolicies(policiesFile)
+		if err != nil {
+			return nil, err
+		}
+		return policyassert.NewOracle(ns, policies), nil
 	}
 
-	plan := generate(&config)
-	planData, err := yaml.Marshal(plan)
+	mconf, err := testsetup.BackendClient(render)
 	if err != nil {
-		log.Fatalf("marshal plan: %v", err)
+		return nil, err
+	}
+
+	return policyassert.NewRenderOracle(mconf, ns), nil
+}
+
+// mergeCmd merges the plans given as arguments into a single plan.
+func mergeCmd(fs *flag.FlagSet, args []string) error {
+
+	planFile := fs.String("output", "plan.yaml", "Set the path to the merged plan file")
+	compact := compactFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
 	}
 
-	if err := os.WriteFile(planFile, planData, 0644); err != nil {
-		log.Fatalf("write plan to %q: %v", planFile, err)
+	if fs.NArg() < 2 {
+		return fmt.Errorf("expected at least 2 plans to merge, got %d", fs.NArg())
 	}
+
+	var pls []*plan.PlanLayout
+	for _, f := range fs.Args() {
+		pl, err := plan.Load(f)
+		if err != nil {
+			return err
+		}
+		pls = append(pls, pl)
+	}
+
+	merged, err := plan.Merge(pls...)
+	if err != nil {
+		return err
+	}
+
+	return plan.Save(*planFile, merged, plan.OptionCompact(*compact))
+}
+
+// splitCmd splits the plan given as argument into per simulator plans.
+func splitCmd(fs *flag.FlagSet, args []string) error {
+
+	shards := fs.Int("shards", 2, "Set the number of plans to split the plan into")
+	outputDir := fs.String("output-dir", "plans",
+		"Set the directory where the plans are written, as plan-$i/plan.yaml")
+	compact := compactFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	pl, err := loadSingleArg(fs)
+	if err != nil {
+		return err
+	}
+
+	split, err := plan.Split(pl, *shards)
+	if err != nil {
+		return err
+	}
+
+	return plan.SaveShards(*outputDir, split, plan.OptionCompact(*compact))
+}
+
+// scaleCmd scales the PUs and flows of the plan given as argument.
+func scaleCmd(fs *flag.FlagSet, args []string) error {
+
+	pus := fs.Float64("pus", 1, "Set the factor to multiply the number of PUs with")
+	flows := fs.Float64("flows", 1, "Set the factor to multiply the number of flows per PU with")
+	planFile := fs.String("output", "plan.yaml", "Set the path to the scaled plan file")
+	compact := compactFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	pl, err := loadSingleArg(fs)
+	if err != nil {
+		return err
+	}
+
+	scaled, err := plan.Scale(pl, *pus, *flows)
+	if err != nil {
+		return err
+	}
+
+	return plan.Save(*planFile, scaled, plan.OptionCompact(*compact))
+}
+
+// renameCmd rewrites the ID and name prefix of the plan given as argument.
+func renameCmd(fs *flag.FlagSet, args []string) error {
+
+	from := fs.String("from", "", "Set the prefix to replace (inferred from the plan if empty)")
+	to := fs.String("to", "", "Set the new prefix")
+	planFile := fs.String("output", "plan.yaml", "Set the path to the renamed plan file")
+	compact := compactFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	pl, err := loadSingleArg(fs)
+	if err != nil {
+		return err
+	}
+
+	renamed, err := plan.Rename(pl, *from, *to)
+	if err != nil {
+		return err
+	}
+
+	return plan.Save(*planFile, renamed, plan.OptionCompact(*compact))
+}
+
+// loadSingleArg loads the plan given as the only argument of fs.
+func loadSingleArg(fs *flag.FlagSet) (*plan.PlanLayout, error) {
+
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("expected a single plan file, got %d arguments", fs.NArg())
+	}
+
+	return plan.Load(fs.Arg(0))
+}
+
+// schemaCmd writes the JSON Schema of the configuration or plan files.
+func schemaCmd(fs *flag.FlagSet, args []string) error {
+
+	target := fs.String("type", "plan", "Set the schema to generate, one of: config, plan")
+	schemaFile := fs.String("output", "", "Set the path to the schema file (stdout if empty)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	data, err := plan.Schema(*target)
+	if err != nil {
+		return err
+	}
+
+	if *schemaFile == "" {
+		_, err = fmt.Println(string(data))
+		return err
+	}
+
+	if err := os.WriteFile(*schemaFile, data, 0644); err != nil {
+		return fmt.Errorf("write schema to %q: %v", *schemaFile, err)
+	}
+
+	return nil
 }
@@ -0,0 +1,198 @@
+// Command policy-gen generates a network rule set policy set matching the PUs of a simulator plan
+// (see libs/policygen), and creates it in the namespaces of a tree.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"go.aporeto.io/simulator-test-harness/common"
+	"go.aporeto.io/simulator-test-harness/libs/backend"
+	"go.aporeto.io/simulator-test-harness/libs/plan"
+	"go.aporeto.io/simulator-test-harness/libs/policyassert"
+	"go.aporeto.io/simulator-test-harness/libs/policygen"
+	"go.aporeto.io/simulator-test-harness/libs/testsetup"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+var log = common.Log
+
+func main() {
+
+	configFile := flag.String("config", "policies.yaml",
+		"Set the path to the policy set configuration file")
+	planFile := flag.String("plan", "plan.yaml",
+		"Set the path to the plan whose PU metadata the matching policies select")
+	treeFile := flag.String("nstree", "nstree.yaml",
+		"Set the path to the namespace tree in which the policies are created")
+	shape := flag.String("shape", "", fmt.Sprintf("Generate the namespace tree with this shape "+
+		"instead of reading --nstree, one of: %v", testsetup.NSShapes))
+	treeName := flag.String("tree-name", "tenant", "Set the name of the generated namespace tree")
+	branching := flag.Int("branching", 2,
+		"Set the number of children of the namespaces of the generated namespace tree")
+	depth := flag.Int("depth", 3,
+		"Set the number of levels below the root of the generated namespace tree")
+	namespace := flag.String("namespace", "",
+		"Set the namespace in which the namespace tree is (required)")
+	createNamespaces := flag.Bool("create-namespaces", false,
+		"Create the namespaces of the tree before the policies")
+	output := flag.String("output", "",
+		"Set the path to a file the generated policies are written to, instead of creating them")
+	appcred := flag.String("appcred", "apoctl.json",
+		"Set the path to the application credentials with namespace administrator privileges")
+	assertions := flag.String("assert", "",
+		"Set the path to a test table the rendered policies are checked against once created")
+	seed := flag.Int64("seed", 0, "Set the seed of the policy generation (random if 0)")
+	logLevel := flag.String("log-level", log.Level.String(),
+		fmt.Sprintf("Set the logger log level, accepts one of: %v", logrus.AllLevels),
+	)
+	flag.Parse()
+
+	lvl, err := logrus.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatalf("parse log level %s: %v", *logLevel, err)
+	}
+	log.SetLevel(lvl)
+
+	tree, err := loadTree(*treeFile, testsetup.NSShape(*shape), *treeName, *branching, *depth)
+	if err != nil {
+		log.Fatalf("policy-gen: %v", err)
+	}
+	if err := run(*configFile, *planFile, tree, *namespace, *createNamespaces, *output,
+		*appcred, *assertions, *seed); err != nil {
+		log.Fatalf("policy-gen: %v", err)
+	}
+}
+
+// loadTree returns the namespace tree of treeFile, or the tree generated with shape if set.
+func loadTree(treeFile string, shape testsetup.NSShape, name string, branching,
+	depth int) (*testsetup.NSTree, error) {
+
+	if shape == "" {
+		var tree testsetup.NSTree
+		if err := loadYAML(treeFile, &tree); err != nil {
+			return nil, err
+		}
+		return &tree, nil
+	}
+
+	tree, err := testsetup.GenerateNSTree(name, shape, branching, depth)
+	if err != nil {
+		return nil, fmt.Errorf("generate namespace tree: %v", err)
+	}
+	log.Infof("Generated a %s namespace tree of %d namespaces over %d levels", shape,
+		tree.Size(), tree.Depth()+1)
+
+	return tree, nil
+}
+
+// run generates the policy set, and writes it to output or creates it.
+func run(configFile, planFile string, tree *testsetup.NSTree, namespace string,
+	createNamespaces bool, output, appcred, assertions string, seed int64) error {
+
+	if namespace == "" {
+		return fmt.Errorf("no namespace")
+	}
+
+	var c policygen.Config
+	if err := loadYAML(configFile, &c); err != nil {
+		return err
+	}
+	pl, err := plan.Load(planFile)
+	if err != nil {
+		return err
+	}
+
+	var opts []policygen.Option
+	if seed != 0 {
+		opts = append(opts, policygen.OptionSeed(seed))
+	}
+	policies, err := policygen.Generate(&c, namespace, tree, policygen.PUTags(pl), opts...)
+	if err != nil {
+		return err
+	}
+
+	matching := 0
+	for _, p := range policies {
+		if p.Matching {
+			matching++
+		}
+	}
+	log.Infof("Generated %d policies, %d matching the PUs of %s", len(policies), matching,
+		planFile)
+	propagated := map[int]int{}
+	for _, p := range policies {
+		if p.Policy.Propagate {
+			propagated[p.Depth]++
+		}
+	}
+	for depth := 0; depth <= tree.Depth(); depth++ {
+		log.Debugf("%d policies propagated from the level %d", propagated[depth], depth)
+	}
+
+	if output != "" {
+		data, err := yaml.Marshal(policies)
+		if err != nil {
+			return fmt.Errorf("marshal policies: %v", err)
+		}
+		if err := os.WriteFile(output, data, 0644); err != nil {
+			return fmt.Errorf("write %s: %v", output, err)
+		}
+		return nil
+	}
+
+	bc, err := backend.FromAppcred(appcred)
+	if err != nil {
+		return fmt.Errorf("read credentials: %v", err)
+	}
+	mconf, err := testsetup.NewClient(bc)
+	if err != nil {
+		return fmt.Errorf("create manipulator: %v", err)
+	}
+
+	if createNamespaces {
+		if err := mconf.CreateNSTree(namespace, tree, []string{}); err != nil {
+			return fmt.Errorf("create namespaces: %v", err)
+		}
+	}
+	if err := policygen.Apply(mconf, policies); err != nil {
+		return fmt.Errorf("create policies: %v", err)
+	}
+
+	log.Infof("Policies created under %s", namespace)
+	if assertions == "" {
+		return nil
+	}
+
+	table, err := policyassert.LoadTable(assertions)
+	if err != nil {
+		return err
+	}
+	results, err := policyassert.Check(mconf, namespace, table)
+	if err != nil {
+		return fmt.Errorf("check policies: %v", err)
+	}
+	fmt.Print(policyassert.Summary(results))
+	if failed := policyassert.Failed(results); failed > 0 {
+		return fmt.Errorf("%d of %d cases failed", failed, len(results))
+	}
+
+	return nil
+}
+
+// loadYAML parses the yaml file into v.
+func loadYAML(file string, v interface{}) error {
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read %s: %v", file, err)
+	}
+	if err := yaml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unmarshal %s: %v", file, err)
+	}
+
+	return nil
+}
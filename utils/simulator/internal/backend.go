@@ -5,16 +5,19 @@ import (
 	"os"
 	"path"
 
+	"go.aporeto.io/gaia"
 	midgardclient "go.aporeto.io/midgard-lib/client"
 	"go.aporeto.io/simulator-test-harness/common"
 	"go.aporeto.io/simulator-test-harness/libs/backend"
+	"go.aporeto.io/simulator-test-harness/libs/tagging"
 	"go.aporeto.io/simulator-test-harness/libs/testsetup"
 )
 
 // SimNSTree creates, given a "namespace", the "namespace" base and a flat
-// hierarch with m namespaces under the base of "namespace".
+// hierarch with m namespaces under the base of "namespace". If t is set, it
+// is applied to every leaf namespace.
 func SimNSTree(mconf *testsetup.Client, namespace string,
-	numNamespace int) error {
+	numNamespace int, t *testsetup.Template, vars testsetup.TemplateVars) error {
 
 	common.Log.Info("Generating namespaces on aporeto for simulator invocation.")
 
@@ -46,6 +49,24 @@ func SimNSTree(mconf *testsetup.Client, namespace string,
 
 	common.Log.Infof("%d namespaces generated under namespace %s", numNamespace,
 		namespace)
+	if t == nil {
+		return nil
+	}
+
+	leaves := []string{namespace}
+	if numNamespace > 0 {
+		leaves = leaves[:0]
+		for _, child := range children {
+			leaves = append(leaves, path.Join(namespace, child.Name))
+		}
+	}
+	for _, ns := range leaves {
+		if err := mconf.ApplyTemplate(ns, t, vars); err != nil {
+			return fmt.Errorf("apply template to %s: %v", ns, err)
+		}
+	}
+
+	common.Log.Infof("Template %s applied to %d namespaces", t.Name, len(leaves))
 	return nil
 }
 
@@ -54,7 +75,15 @@ func SimNSTree(mconf *testsetup.Client, namespace string,
 func SimMappingPolicy(c *testsetup.Client, tag, srcNamespace,
 	trgNamespace string) error {
 
-	common.Log.Debugf("Creating a mapping policy with tag: %s", tag)
+	return simMappingPolicy(c, tagging.Mapping{Tag: tag}.Subject(), srcNamespace, trgNamespace)
+}
+
+// simMappingPolicy creates a mapping policy of subject from srcNamespace to
+// trgNamespace.
+func simMappingPolicy(c *testsetup.Client, subject [][]string, srcNamespace,
+	trgNamespace string) error {
+
+	common.Log.Debugf("Creating a mapping policy with subject: %v", subject)
 
 	nsmp, err := c.CreateNSMappingPolicy(
 		"simulator-mapping",
@@ -63,12 +92,7 @@ func SimMappingPolicy(c *testsetup.Client, tag, srcNamespace,
 			"type=enforcer",
 			"creator=simulator-test-harness",
 		},
-		[][]string{
-			{
-				"$identity=enforcer",
-				tag,
-			},
-		},
+		subject,
 		trgNamespace,
 	)
 
@@ -80,38 +104,70 @@ func SimMappingPolicy(c *testsetup.Client, tag, srcNamespace,
 	return nil
 }
 
-// SimMappingPolicies creates many mapping policies from scrNamespace towards
-// 'numNamespaces' sub-namespaces named srcNamespace-$i. It searches with tag
-// "nsim=prefix$j-$i" where $j ranges through the batches needed and $i through
-// the batchSize.
+// SimMappingPolicies creates the mapping policies from srcNamespace towards
+// the sub-namespaces named srcNamespace-$i of mappings (see tagging.Scheme).
 func SimMappingPolicies(c *testsetup.Client, srcNamespace string,
-	capacity, numNamespaces, batchSize int, prefix string) error {
-
-	common.Log.Info("Applying mapping policies")
-	ns := 0
-	mappings := 0
-	batch := 0
-	for {
-		for i := 1; i <= batchSize; i++ {
-
-			tag := fmt.Sprintf("nsim=%s%d-%d", prefix, batch, i)
-			trgNamespace := path.Join(srcNamespace,
-				fmt.Sprintf("%s-%d", path.Base(srcNamespace), ns))
-
-			if err := SimMappingPolicy(c, tag, srcNamespace, trgNamespace); err != nil {
-				return fmt.Errorf("mapping policies: %v", err)
-			}
-			mappings++
-			if mappings%capacity == 0 {
-				ns++
-				if ns >= numNamespaces {
-					common.Log.Infof("%d mapping policies are applied", mappings)
-					return nil
-				}
-			}
+	mappings []tagging.Mapping) error {
+
+	common.Log.Infof("Applying %d mapping policies", len(mappings))
+	for _, m := range mappings {
+		trgNamespace := tagging.ChildNamespace(srcNamespace, m.Namespace)
+		if err := simMappingPolicy(c, m.Subject(), srcNamespace, trgNamespace); err != nil {
+			return fmt.Errorf("mapping policies: %v", err)
+		}
+	}
+
+	common.Log.Info("Mapping policies are applied")
+	return nil
+}
+
+// SimDeleteMappingPolicies deletes the mapping policies created by SimMappingPolicies in
+// srcNamespace for the enforcers tagged with tags.
+func SimDeleteMappingPolicies(c *testsetup.Client, srcNamespace string, tags []string) error {
+
+	deleted := 0
+	for _, tag := range tags {
+		n, err := c.DeleteNSMappingPolicies("simulator-mapping", srcNamespace,
+			tagging.Mapping{Tag: tag}.Subject())
+		if err != nil {
+			return fmt.Errorf("mapping policies: %v", err)
 		}
-		batch++
+		deleted += n
+	}
+
+	common.Log.Infof("Deleted %d mapping policies", deleted)
+	return nil
+}
+
+// SimHostServices creates the host services hss in namespace, propagated to its children and
+// mapped to all their enforcers.
+func SimHostServices(c *testsetup.Client, namespace string, hss []*gaia.HostService) error {
+
+	if len(hss) == 0 {
+		return nil
 	}
+
+	common.Log.Infof("Creating %d host services", len(hss))
+	_, err := c.CreateHostServices("simulator-host-services", namespace, hss,
+		[][]string{{"$identity=enforcer"}})
+
+	return err
+}
+
+// SimEnforcerProfile creates the enforcer profile name in namespace, with the settings of the
+// built-in profile or profile file profile, mapped to the enforcers matching enforcers.
+func SimEnforcerProfile(c *testsetup.Client, namespace, name, profile string,
+	enforcers [][]string) error {
+
+	settings, err := testsetup.LoadProfile(profile)
+	if err != nil {
+		return err
+	}
+
+	common.Log.Infof("Attaching the %s enforcer profile %s in %s", profile, name, namespace)
+	_, err = c.AttachEnforcerProfile(name, namespace, settings, enforcers)
+
+	return err
 }
 
 // BackendFromAppcred creates a backend.Details instance with only API.URL
@@ -134,24 +190,3 @@ func BackendFromAppcred(appcred string) (*backend.Details, error) {
 
 	return &bc, nil
 }
-
-// BToNPolicies creates 1 mapping policy per batch with tag "simbatch=$prefix-$i"
-// where i ranges through numNamespaces.
-func BToNPolicies(c *testsetup.Client, srcNamespace string,
-	numNamespaces int, prefix string) error {
-
-	common.Log.Infof("Applying %d mapping policies", numNamespaces)
-	for batch := 1; batch <= numNamespaces; batch++ {
-
-		tag := fmt.Sprintf("simbase=%s-%d", prefix, batch)
-		trgNamespace := path.Join(srcNamespace,
-			fmt.Sprintf("%s-%d", path.Base(srcNamespace), batch-1))
-
-		if err := SimMappingPolicy(c, tag, srcNamespace, trgNamespace); err != nil {
-			return fmt.Errorf("mapping policies: %v", err)
-		}
-	}
-
-	common.Log.Info("Mapping policies are applied")
-	return nil
-}
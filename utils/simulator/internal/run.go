@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+	"go.aporeto.io/simulator-test-harness/libs/tagging"
+	"go.aporeto.io/simulator-test-harness/libs/testsetup"
+)
+
+// A RunBackend implements the control plane operations of the scale runs.
+type RunBackend struct {
+	c *testsetup.Client
+}
+
+// NewRunBackend returns a RunBackend using c.
+func NewRunBackend(c *testsetup.Client) *RunBackend {
+
+	return &RunBackend{c: c}
+}
+
+// Prepare creates namespace, with its numNamespace child namespaces, the template if any, and the
+// host services hss, like the policies command does with --create-namespaces --values. The
+// mapping policies towards the child namespaces are created batch by batch with Map.
+func (b *RunBackend) Prepare(
+	namespace string,
+	numNamespace int,
+	template, prefix string,
+	hss []*gaia.HostService,
+) ([]string, error) {
+
+	var t *testsetup.Template
+	if template != "" {
+		var err error
+		if t, err = testsetup.LoadTemplate(template); err != nil {
+			return nil, err
+		}
+	}
+	vars := testsetup.TemplateVars{Prefix: prefix}
+	if err := SimNSTree(b.c, namespace, numNamespace, t, vars); err != nil {
+		return nil, fmt.Errorf("creating namespaces: %v", err)
+	}
+
+	namespaces := []string{namespace}
+	for i := 0; i < numNamespace; i++ {
+		namespaces = append(namespaces, tagging.ChildNamespace(namespace, i))
+	}
+
+	if err := SimHostServices(b.c, namespace, hss); err != nil {
+		return namespaces, fmt.Errorf("creating host services: %v", err)
+	}
+
+	return namespaces, nil
+}
+
+// AttachProfile creates the enforcer profile name in namespace, with the settings of the built-in
+// profile or profile file profile, mapped to the enforcers tagged with tag.
+func (b *RunBackend) AttachProfile(namespace, name, profile, tag string) error {
+
+	return SimEnforcerProfile(b.c, namespace, name, profile, [][]string{{tag}})
+}
+
+// Enforcers returns the enforcers tagged with tag registered in namespace or its children.
+func (b *RunBackend) Enforcers(namespace, tag string) ([]tagging.Registration, error) {
+
+	ctx := context.Background()
+	if b.c.Timeout() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.c.Timeout())
+		defer cancel()
+	}
+
+	var enforcers gaia.EnforcersList
+	mctx := manipulate.NewContext(ctx,
+		manipulate.ContextOptionNamespace(namespace),
+		manipulate.ContextOptionRecursive(true),
+		manipulate.ContextOptionFilter(
+			elemental.NewFilterComposer().WithKey("associatedtags").Contains(tag).Done(),
+		),
+	)
+	if err := b.c.Manipulator().RetrieveMany(mctx, &enforcers); err != nil {
+		return nil, fmt.Errorf("retrieve enforcers %s: %v", tag, err)
+	}
+
+	regs := make([]tagging.Registration, 0, len(enforcers))
+	for _, e := range enforcers {
+		regs = append(regs, tagging.Registration{Namespace: e.Namespace, Tags: e.AssociatedTags})
+	}
+
+	return regs, nil
+}
+
+// Map creates the mapping policies of mappings from namespace towards its child namespaces.
+func (b *RunBackend) Map(namespace string, mappings []tagging.Mapping) error {
+
+	return SimMappingPolicies(b.c, namespace, mappings)
+}
+
+// Unmap deletes the mapping policies of the enforcers tagged with tags from namespace.
+func (b *RunBackend) Unmap(namespace string, tags []string) error {
+
+	return SimDeleteMappingPolicies(b.c, namespace, tags)
+}
+
+// CreateAppCred creates the enforcer application credential name in namespace, and returns the
+// content of its credentials file.
+func (b *RunBackend) CreateAppCred(name, namespace string) ([]byte, error) {
+
+	appcred, err := b.c.CreateEnforcerAppCredential(name, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("create appcred %s: %v", name, err)
+	}
+
+	data, err := json.MarshalIndent(appcred.Credentials, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode appcred: %v", err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+
+	"go.aporeto.io/elemental"
+	"go.aporeto.io/gaia"
+	"go.aporeto.io/manipulate"
+	"go.aporeto.io/simulator-test-harness/libs/tagging"
+	"go.aporeto.io/simulator-test-harness/libs/testsetup"
+)
+
+// A fakeManipulator returns enforcers, failing like a backend once the context is done.
+type fakeManipulator struct {
+	manipulate.Manipulator
+	enforcers gaia.EnforcersList
+}
+
+func (f *fakeManipulator) RetrieveMany(mctx manipulate.Context,
+	dest elemental.Identifiables) error {
+
+	if err := mctx.Context().Err(); err != nil {
+		return err
+	}
+	*dest.(*gaia.EnforcersList) = append(*dest.(*gaia.EnforcersList), f.enforcers...)
+	return nil
+}
+
+func TestEnforcers(t *testing.T) {
+
+	m := &fakeManipulator{enforcers: gaia.EnforcersList{
+		{Namespace: "/base/sim-0", AssociatedTags: []string{"sim=batch-1"}},
+		{Namespace: "/base/sim-1", AssociatedTags: []string{"sim=batch-1", "pod=p-1"}},
+	}}
+	b := NewRunBackend(testsetup.NewClientWithManipulator(m))
+
+	regs, err := b.Enforcers("/base", "sim=batch-1")
+	if err != nil {
+		t.Fatalf("Enforcers() error = %v", err)
+	}
+	want := []tagging.Registration{
+		{Namespace: "/base/sim-0", Tags: []string{"sim=batch-1"}},
+		{Namespace: "/base/sim-1", Tags: []string{"sim=batch-1", "pod=p-1"}},
+	}
+	if !reflect.DeepEqual(regs, want) {
+		t.Errorf("Enforcers() = %+v, want %+v", regs, want)
+	}
+}
@@ -0,0 +1,888 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.aporeto.io/simulator-test-harness/common"
+	"go.aporeto.io/simulator-test-harness/libs/backend"
+	"go.aporeto.io/simulator-test-harness/libs/chart"
+	"go.aporeto.io/simulator-test-harness/libs/churn"
+	"go.aporeto.io/simulator-test-harness/libs/k8s"
+	"go.aporeto.io/simulator-test-harness/libs/policyassert"
+	"go.aporeto.io/simulator-test-harness/libs/rollout"
+	"go.aporeto.io/simulator-test-harness/libs/run"
+	"go.aporeto.io/simulator-test-harness/libs/tagging"
+	"go.aporeto.io/simulator-test-harness/libs/testsetup"
+	"go.aporeto.io/simulator-test-harness/utils/simulator/internal"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+var log = common.Log
+
+// commands are the orchestrator subcommands.
+var commands = map[string]func(ctx context.Context, fs *flag.FlagSet, args []string) error{
+	"run":          runCmd,
+	"search":       searchCmd,
+	"resume":       resumeCmd,
+	"churn":        churnCmd,
+	"policy-churn": policyChurnCmd,
+	"auth-churn":   authChurnCmd,
+	"export":       exportCmd,
+	"import":       importCmd,
+	"assert":       assertCmd,
+	"verify":       verifyCmd,
+	"multi":        multiCmd,
+	"compare":      compareCmd,
+}
+
+func main() {
+
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		log.Fatalf("expected a command, one of: run, search, resume, churn, policy-churn, " +
+			"auth-churn, export, import, verify, multi, compare, assert")
+	}
+	cmd := os.Args[1]
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	if err := commands[cmd](ctx, fs, os.Args[2:]); err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+}
+
+// A clusterFlags is the flags giving access to the Aporeto control plane and the test harness
+// cluster, and probing their health, which are not persisted in the run state (e.g. tokens
+// expire).
+type clusterFlags struct {
+	stateFile  *string
+	creds      *string
+	kubeconfig *string
+	logLevel   *string
+	noHealth   *bool
+	monitoring *string
+	flowLag    *string
+	samples    *int
+}
+
+// addClusterFlags adds the flags shared by all commands to fs.
+func addClusterFlags(fs *flag.FlagSet) *clusterFlags {
+
+	return &clusterFlags{
+		stateFile: fs.String("state", "run-state.yaml", "Set the path to the run state file"),
+		creds: fs.String("creds", "apoctl.json",
+			"Set the path to the application credentials with namespace administrator privileges"),
+		kubeconfig: fs.String("kubeconfig", "",
+			"Set the path to the kubeconfig of the test harness (in-cluster or default if empty)"),
+		logLevel: fs.String("log-level", log.Level.String(),
+			fmt.Sprintf("Set the logger log level, accepts one of: %v", logrus.AllLevels)),
+		noHealth: fs.Bool("no-health", false,
+			"Do not gate the batches on the health of the backend"),
+		monitoring: fs.String("monitoring", "",
+			"Set the path to backend details with a monitoring stack, to query the API error rate "+
+				"and latency from Prometheus instead of measuring them"),
+		flowLag: fs.String("flow-lag-query", "",
+			"Set the PromQL query of the flow ingestion lag in seconds, checked if set along "+
+				"with a monitoring stack"),
+		samples: fs.Int("api-samples", 5,
+			"Set the number of API requests measuring the API error rate and latency"),
+	}
+}
+
+// setLogLevel sets the level of the logger.
+func setLogLevel(level string) error {
+
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("parse log level %s: %v", level, err)
+	}
+	log.SetLevel(lvl)
+
+	return nil
+}
+
+// runner sets the log level and returns the runner configured by f.
+func (f *clusterFlags) runner() (*run.Runner, error) {
+
+	if err := setLogLevel(*f.logLevel); err != nil {
+		return nil, err
+	}
+
+	bc, err := internal.BackendFromAppcred(*f.creds)
+	if err != nil {
+		return nil, fmt.Errorf("read credentials: %v", err)
+	}
+	if *f.monitoring != "" {
+		details, err := backend.FromFile(*f.monitoring)
+		if err != nil {
+			return nil, err
+		}
+		bc.Monitoring = details.Monitoring
+	}
+
+	kc, err := k8s.NewClientFromKubeconfig(*f.kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.newRunner(bc, kc, *f.stateFile)
+}
+
+// newRunner returns the runner of the backend bc persisting its state to stateFile, probing the
+// monitoring stack of bc if any and the health checks are enabled by f.
+func (f *clusterFlags) newRunner(
+	bc *backend.Details,
+	kc *k8s.Client,
+	stateFile string,
+) (*run.Runner, error) {
+
+	mconf, err := testsetup.NewClient(bc)
+	if err != nil {
+		return nil, fmt.Errorf("create manipulator: %v", err)
+	}
+
+	r := &run.Runner{
+		Backend:   internal.NewRunBackend(mconf),
+		K8s:       kc,
+		StateFile: stateFile,
+	}
+	if *f.noHealth {
+		return r, nil
+	}
+
+	api := rollout.NewAPIProbe(mconf.Manipulator(), *f.samples, 30*time.Second)
+	r.Probes = &rollout.Probes{Enforcers: api, Metrics: api, Pods: kc}
+	if bc.Monitoring.URL != "" {
+		prom, err := rollout.NewPrometheus(&bc.Monitoring,
+			rollout.OptionFlowLagQuery(*f.flowLag))
+		if err != nil {
+			return nil, fmt.Errorf("create prometheus client: %v", err)
+		}
+		r.Probes.Metrics = prom
+		if prom.HasFlowLag() {
+			r.Probes.FlowLag = prom
+		}
+	}
+
+	return r, nil
+}
+
+// runCmd starts a new run.
+func runCmd(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	return start(ctx, fs, args, run.NewState)
+}
+
+// searchCmd starts a new breaking-point search, up to the enforcers of the run.
+func searchCmd(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	return start(ctx, fs, args, run.NewSearchState)
+}
+
+// start starts a new run, whose state is returned by newState.
+func start(
+	ctx context.Context,
+	fs *flag.FlagSet,
+	args []string,
+	newState func(run.Config) (*run.State, error),
+) error {
+
+	config := addRunFlags(fs)
+	cf := addClusterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(*cf.stateFile); err == nil {
+		return fmt.Errorf("%s already exists, resume the run or remove it", *cf.stateFile)
+	}
+
+	c, err := config()
+	if err != nil {
+		return err
+	}
+	s, err := newState(c)
+	if err != nil {
+		return err
+	}
+
+	r, err := cf.runner()
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Starting run %s, its state is saved to %s", s.RunID, *cf.stateFile)
+	return r.Run(ctx, s)
+}
+
+// addRunFlags adds the flags of the configuration of a new run to fs, and returns the function
+// returning the configuration once fs is parsed.
+func addRunFlags(fs *flag.FlagSet) func() (run.Config, error) {
+
+	var c run.Config
+	fs.StringVar(&c.Namespace, "namespace", "",
+		"Set the Aporeto base namespace, under which the test will run (required)")
+	fs.StringVar(&c.Prefix, "prefix", "simulator",
+		"Set the prefix of the kubernetes and Aporeto namespaces")
+	fs.IntVar(&c.Enforcers, "enforcers", 250, "Set the number of enforcers to deploy")
+	fs.IntVar(&c.Pods, "pods", 25, "Set the number of pods per batch")
+	fs.IntVar(&c.Simulators, "simulators", 10, "Set the number of simulators per pod")
+	fs.IntVar(&c.Capacity, "capacity", 0,
+		"Set the maximum number of enforcers per namespace (batch size if 0)")
+	noPrepare := fs.Bool("no-prepare", false, "Do not create the namespaces and mapping policies")
+	mapping := fs.String("mapping", "", fmt.Sprintf("Set the strategy mapping the enforcers to "+
+		"the namespaces, one of %v (per-batch if the batch size is the capacity, "+
+		"per-simulator otherwise, if empty)", tagging.Strategies))
+	fs.StringVar(&c.Template, "template", "", fmt.Sprintf("Set the built-in template, among %v, "+
+		"or the path to the template file, applied to the namespaces of the run",
+		testsetup.Templates()))
+	fs.StringVar(&c.TemplatePrefix, "template-prefix", "",
+		"Set the value of the {{prefix}} placeholder of the template")
+	profiles := fs.String("enforcer-profiles", "", fmt.Sprintf("Set the comma separated enforcer "+
+		"profiles, among %v or profile files, cycled through the batches", testsetup.Profiles()))
+	fs.BoolVar(&c.Rebalance, "rebalance", false, "Create extra mapping policies correcting the "+
+		"imbalances of the enforcers distribution verified at the end of the run")
+	fs.StringVar(&c.ImagePullSecret, "secret", "",
+		"Set the path to a docker config authentication file logged in the private registry")
+	valuesFile := fs.String("values", "values.yaml",
+		"Set the path to the chart values (chart defaults if the file does not exist)")
+	c.Rollout = rollout.DefaultConfig()
+	fs.Float64Var(&c.Rollout.MinConnected, "min-connected", c.Rollout.MinConnected,
+		"Set the minimum ratio of connected enforcers of a healthy batch")
+	fs.Float64Var(&c.Rollout.MaxErrorRate, "max-error-rate", c.Rollout.MaxErrorRate,
+		"Set the maximum ratio of failed API requests of a healthy backend")
+	fs.DurationVar(&c.Rollout.MaxLatency, "max-latency", c.Rollout.MaxLatency,
+		"Set the maximum API latency of a healthy backend")
+	fs.DurationVar(&c.Rollout.MaxFlowLag, "max-flow-lag", c.Rollout.MaxFlowLag,
+		"Set the maximum flow ingestion lag of a healthy backend")
+	fs.IntVar(&c.Rollout.MaxFailedPods, "max-failed-pods", c.Rollout.MaxFailedPods,
+		"Set the maximum number of failed pods")
+	fs.IntVar(&c.Rollout.Checks, "health-checks", c.Rollout.Checks,
+		"Set the number of health checks before declaring a batch unhealthy")
+	fs.DurationVar(&c.Rollout.Backoff, "backoff", c.Rollout.Backoff,
+		"Set the delay between the first health checks, doubled after every check")
+	fs.DurationVar(&c.Rollout.MaxBackoff, "max-backoff", c.Rollout.MaxBackoff,
+		"Set the maximum delay between health checks")
+	fs.IntVar(&c.Rollout.MinPods, "min-pods", c.Rollout.MinPods,
+		"Set the minimum number of pods of a batch, the run stops when such a batch is unhealthy")
+
+	return func() (run.Config, error) {
+		c.PrepareBackend = !*noPrepare
+		c.Mapping = tagging.Strategy(*mapping)
+		if *profiles != "" {
+			c.EnforcerProfiles = strings.Split(*profiles, ",")
+			for _, p := range c.EnforcerProfiles {
+				if _, err := testsetup.LoadProfile(p); err != nil {
+					return c, err
+				}
+			}
+		}
+
+		values := chart.DefaultValues()
+		if _, err := os.Stat(*valuesFile); err == nil {
+			if values, err = chart.LoadValues(*valuesFile); err != nil {
+				return c, err
+			}
+		}
+		c.Values = *values
+
+		return c, nil
+	}
+}
+
+// resumeCmd resumes the run of a state file, from its last completed batch.
+func resumeCmd(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	cf := addClusterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := run.LoadState(*cf.stateFile)
+	if err != nil {
+		return err
+	}
+
+	r, err := cf.runner()
+	if err != nil {
+		return err
+	}
+
+	var batches []string
+	for _, b := range s.Batches {
+		batches = append(batches, fmt.Sprintf("%d:%s", b.Index, b.Status))
+	}
+	log.Infof("Resuming run %s (batches %s)", s.RunID, strings.Join(batches, " "))
+
+	return r.Run(ctx, s)
+}
+
+// multiCmd starts, or resumes, a run against every backend of a list at once, and reports them
+// side by side.
+func multiCmd(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	config := addRunFlags(fs)
+	backends := fs.String("backends", "", "Set the comma separated paths to the backend details "+
+		"of the backends (required), each run being named after its file")
+	split := fs.String("split", string(run.SplitEven), fmt.Sprintf("Set how the enforcers are "+
+		"deployed against the backends, one of: %v", run.Splits))
+	resume := fs.Bool("resume", false, "Resume the runs of the existing state files")
+	reportFile := fs.String("report", "comparison.txt",
+		"Set the path to the file the side-by-side report is written to")
+	cf := addClusterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := setLogLevel(*cf.logLevel); err != nil {
+		return err
+	}
+	if *backends == "" {
+		return fmt.Errorf("no backends")
+	}
+
+	c, err := config()
+	if err != nil {
+		return err
+	}
+	files := strings.Split(*backends, ",")
+	configs, err := run.SplitConfigs(c, run.Split(*split), len(files))
+	if err != nil {
+		return err
+	}
+
+	kc, err := k8s.NewClientFromKubeconfig(*cf.kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	var legs []*run.Leg
+	for i, file := range files {
+		name := legName(file)
+		for _, l := range legs {
+			if l.Backend == name {
+				return fmt.Errorf("backends %s have the same name", name)
+			}
+		}
+
+		bc, err := backend.FromFile(file)
+		if err != nil {
+			return err
+		}
+		stateFile := legStateFile(*cf.stateFile, name)
+		r, err := cf.newRunner(bc, kc, stateFile)
+		if err != nil {
+			return fmt.Errorf("backend %s: %v", name, err)
+		}
+
+		var s *run.State
+		_, statErr := os.Stat(stateFile)
+		switch {
+		case *resume:
+			s, err = run.LoadState(stateFile)
+		case statErr == nil:
+			err = fmt.Errorf("%s already exists, resume the runs or remove it", stateFile)
+		default:
+			s, err = run.NewState(configs[i])
+		}
+		if err != nil {
+			return fmt.Errorf("backend %s: %v", name, err)
+		}
+
+		log.Infof("Run %s of backend %s is saved to %s", s.RunID, name, stateFile)
+		legs = append(legs, &run.Leg{Backend: name, Runner: r, State: s})
+	}
+
+	runErr := run.RunAll(ctx, legs)
+
+	report := run.Compare(legs)
+	log.Infof("Runs against %d backends:\n%s", len(legs), report)
+	if err := os.WriteFile(*reportFile, []byte(report), 0644); err != nil {
+		return fmt.Errorf("write %s: %v", *reportFile, err)
+	}
+
+	return runErr
+}
+
+// compareCmd reports the runs of state files side by side.
+func compareCmd(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("expected the state files of the runs to compare")
+	}
+
+	var legs []*run.Leg
+	for _, stateFile := range fs.Args() {
+		s, err := run.LoadState(stateFile)
+		if err != nil {
+			return err
+		}
+		legs = append(legs, &run.Leg{Backend: legName(stateFile), State: s})
+	}
+
+	fmt.Print(run.Compare(legs))
+	return nil
+}
+
+// legName returns the name of the run of a multi-backend run described by file, i.e. its base
+// name without extension.
+func legName(file string) string {
+
+	return strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+}
+
+// legStateFile returns the state file of the run of backend name of a multi-backend run persisted
+// to stateFile, e.g. run-state-<name>.yaml.
+func legStateFile(stateFile, name string) string {
+
+	ext := filepath.Ext(stateFile)
+	return strings.TrimSuffix(stateFile, ext) + "-" + name + ext
+}
+
+// verifyCmd verifies the distribution of the enforcers of a run to its namespaces.
+func verifyCmd(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	rebalance := fs.Bool("rebalance", false,
+		"Create extra mapping policies correcting the imbalances found")
+	cf := addClusterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s, err := run.LoadState(*cf.stateFile)
+	if err != nil {
+		return err
+	}
+	if !s.Config.PrepareBackend {
+		return fmt.Errorf("run %s has no mapping policies to verify", s.RunID)
+	}
+
+	r, err := cf.runner()
+	if err != nil {
+		return err
+	}
+
+	report, err := r.Verify(s, *rebalance)
+	if err != nil {
+		return err
+	}
+	if !report.Balanced() && !report.Corrected {
+		return fmt.Errorf("%d misplaced and %d unmapped enforcers, %d namespaces over capacity",
+			report.Misplaced, report.Unmapped, len(report.Overloaded))
+	}
+
+	return nil
+}
+
+// churnCmd churns the deployments of a completed run.
+func churnCmd(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	var c churn.Config
+	stateFile := fs.String("state", "run-state.yaml", "Set the path to the run state file")
+	kubeconfig := fs.String("kubeconfig", "",
+		"Set the path to the kubeconfig of the test harness (in-cluster or default if empty)")
+	logLevel := fs.String("log-level", log.Level.String(),
+		fmt.Sprintf("Set the logger log level, accepts one of: %v", logrus.AllLevels))
+	pattern := fs.String("pattern", string(churn.PatternSine),
+		fmt.Sprintf("Set the churn pattern, one of: %v", churn.PatternTypes))
+	fs.DurationVar(&c.Interval, "interval", time.Minute, "Set the interval between scalings")
+	fs.DurationVar(&c.Duration, "duration", time.Hour, "Set the duration of the churn")
+	fs.IntVar(&c.Min, "min", 0, "Set the minimum replicas of each deployment")
+	fs.IntVar(&c.Max, "max", 0, "Set the maximum replicas of each deployment (pods per batch if 0)")
+	fs.DurationVar(&c.Period, "period", 30*time.Minute, "Set the period of the sine pattern")
+	fs.IntVar(&c.Step, "step", 1, "Set the maximum replicas change of a random walk step")
+	steps := fs.String("steps", "",
+		"Set the steps of the steps pattern, as comma separated <after>=<replicas> (e.g. 5m=10)")
+	fs.Int64Var(&c.Seed, "seed", 0, "Set the seed of the random walk (random if 0)")
+	eventsFile := fs.String("events", "churn-events.jsonl",
+		"Set the path to the file the scaling events are appended to, as JSON lines")
+	noRestore := fs.Bool("no-restore", false,
+		"Do not scale the deployments back to their initial replicas at the end")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := setLogLevel(*logLevel); err != nil {
+		return err
+	}
+
+	s, err := run.LoadState(*stateFile)
+	if err != nil {
+		return err
+	}
+
+	c.Pattern = churn.PatternType(*pattern)
+	if c.Max == 0 {
+		c.Max = s.Config.Pods
+	}
+	if c.Steps, err = parseSteps(*steps); err != nil {
+		return err
+	}
+
+	kc, err := k8s.NewClientFromKubeconfig(*kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	var deps []*churn.Deployment
+	for _, b := range s.Batches {
+		if b.Status != run.BatchCompleted {
+			continue
+		}
+		replicas, err := kc.DeploymentReplicas(ctx, s.Namespace, b.Deployment)
+		if err != nil {
+			return err
+		}
+		deps = append(deps, &churn.Deployment{
+			Namespace: s.Namespace,
+			Name:      b.Deployment,
+			Replicas:  replicas,
+		})
+	}
+	if len(deps) == 0 {
+		return fmt.Errorf("no completed batch in %s", *stateFile)
+	}
+
+	events, err := os.OpenFile(*eventsFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open %s: %v", *eventsFile, err)
+	}
+	defer events.Close()
+
+	ctrl, err := churn.NewController(kc, c, s.Config.Simulators, events)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Churning %d deployments of run %s for %v, events recorded to %s", len(deps),
+		s.RunID, c.Duration, *eventsFile)
+	_, err = ctrl.Run(ctx, deps, !*noRestore)
+	return err
+}
+
+// policyChurnCmd churns the policy objects of the namespaces of a run.
+func policyChurnCmd(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	var c churn.PolicyConfig
+	stateFile := fs.String("state", "run-state.yaml", "Set the path to the run state file")
+	creds := fs.String("creds", "apoctl.json",
+		"Set the path to the application credentials with namespace administrator privileges")
+	logLevel := fs.String("log-level", log.Level.String(),
+		fmt.Sprintf("Set the logger log level, accepts one of: %v", logrus.AllLevels))
+	fs.DurationVar(&c.Interval, "interval", time.Minute,
+		"Set the interval between rounds of mutations")
+	fs.DurationVar(&c.Duration, "duration", time.Hour, "Set the duration of the churn")
+	fs.IntVar(&c.BlastRadius, "blast-radius", 1,
+		"Set the number of namespaces, picked at random, mutated every round")
+	fs.IntVar(&c.Mutations, "mutations", 1, "Set the number of mutations per namespace and round")
+	fs.IntVar(&c.MaxObjects, "max-objects", 10,
+		"Set the maximum number of objects of the churn per namespace")
+	kinds := fs.String("kinds", fmt.Sprintf("%s,%s", churn.KindNetworkPolicy,
+		churn.KindExternalNetwork), fmt.Sprintf("Set the comma separated kinds of objects "+
+		"mutated, among: %v", churn.ObjectKinds))
+	fs.BoolVar(&c.Match, "match", false,
+		"Make the network policies select all the PUs of their namespace, instead of none")
+	fs.BoolVar(&c.Propagate, "propagate", false,
+		"Propagate the objects to the children namespaces")
+	fs.Int64Var(&c.Seed, "seed", 0, "Set the seed of the mutations (random if 0)")
+	eventsFile := fs.String("events", "policy-churn-events.jsonl",
+		"Set the path to the file the mutations are appended to, as JSON lines")
+	noCleanup := fs.Bool("no-cleanup", false,
+		"Do not delete the objects left by the churn at the end")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := setLogLevel(*logLevel); err != nil {
+		return err
+	}
+	for _, k := range strings.Split(*kinds, ",") {
+		c.Kinds = append(c.Kinds, churn.ObjectKind(k))
+	}
+
+	s, err := run.LoadState(*stateFile)
+	if err != nil {
+		return err
+	}
+	namespaces := s.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{path.Join(s.Config.Namespace, s.Namespace)}
+	}
+
+	mconf, err := testsetup.BackendClient(*creds)
+	if err != nil {
+		return err
+	}
+
+	events, err := os.OpenFile(*eventsFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open %s: %v", *eventsFile, err)
+	}
+	defer events.Close()
+
+	ctrl, err := churn.NewPolicyController(mconf, c, events)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Churning the policies of %d namespaces of run %s for %v, events recorded to %s",
+		len(namespaces), s.RunID, c.Duration, *eventsFile)
+	_, err = ctrl.Run(ctx, namespaces, !*noCleanup)
+	return err
+}
+
+// authChurnCmd churns application credentials in the namespaces of a run, checking their tokens
+// and revocations.
+func authChurnCmd(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	var c churn.AuthConfig
+	stateFile := fs.String("state", "run-state.yaml", "Set the path to the run state file")
+	creds := fs.String("creds", "apoctl.json",
+		"Set the path to the application credentials with namespace administrator privileges")
+	logLevel := fs.String("log-level", log.Level.String(),
+		fmt.Sprintf("Set the logger log level, accepts one of: %v", logrus.AllLevels))
+	fs.DurationVar(&c.Interval, "interval", time.Minute, "Set the interval between rounds")
+	fs.DurationVar(&c.Duration, "duration", time.Hour, "Set the duration of the churn")
+	fs.IntVar(&c.AppCreds, "appcreds", 10,
+		"Set the number of application credentials, refilled every round")
+	roles := fs.String("roles", strings.Join(churn.DefaultAuthRoles, ","),
+		"Set the comma separated roles given at random to the application credentials")
+	fs.IntVar(&c.Tokens, "tokens", 100, "Set the number of tokens issued every round")
+	fs.IntVar(&c.Concurrency, "concurrency", 10, "Set the number of concurrent token issuers")
+	fs.IntVar(&c.Rotations, "rotations", 1,
+		"Set the number of application credentials rotated every round")
+	fs.IntVar(&c.Revocations, "revocations", 1,
+		"Set the number of application credentials revoked every round")
+	fs.DurationVar(&c.RevocationTimeout, "revocation-timeout", 30*time.Second,
+		"Set the time after which a revoked application credential issuing tokens is an error")
+	fs.BoolVar(&c.Policies, "policies", false,
+		"Create an API authorization policy granting its roles to every application credential")
+	fs.Int64Var(&c.Seed, "seed", 0, "Set the seed of the churn (random if 0)")
+	eventsFile := fs.String("events", "auth-churn-events.jsonl",
+		"Set the path to the file the operations are appended to, as JSON lines")
+	noCleanup := fs.Bool("no-cleanup", false,
+		"Do not delete the application credentials left by the churn at the end")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := setLogLevel(*logLevel); err != nil {
+		return err
+	}
+	if *roles != "" {
+		c.Roles = strings.Split(*roles, ",")
+	}
+
+	s, err := run.LoadState(*stateFile)
+	if err != nil {
+		return err
+	}
+	namespaces := s.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{path.Join(s.Config.Namespace, s.Namespace)}
+	}
+
+	mconf, err := testsetup.BackendClient(*creds)
+	if err != nil {
+		return err
+	}
+
+	events, err := os.OpenFile(*eventsFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open %s: %v", *eventsFile, err)
+	}
+	defer events.Close()
+
+	ctrl, err := churn.NewAuthController(mconf, c, events)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Churning the appcreds of %d namespaces of run %s for %v, events recorded to %s",
+		len(namespaces), s.RunID, c.Duration, *eventsFile)
+	evs, err := ctrl.Run(ctx, namespaces, !*noCleanup)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, ev := range evs {
+		if ev.Error != "" && (ev.Op == churn.OpRotate || ev.Op == churn.OpRevoke) {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d revocations failed or did not propagate within %v", failed,
+			c.RevocationTimeout)
+	}
+
+	return nil
+}
+
+// exportCmd writes the namespace tree of a live backend to a yaml file.
+func exportCmd(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	namespace := fs.String("namespace", "", "Set the root namespace of the exported tree")
+	output := fs.String("output", "nstree.yaml",
+		"Set the path to the file the namespace tree is written to")
+	setup := fs.Bool("setup", false, "Export the tag prefixes, external networks, network "+
+		"policies, host services and host service mapping policies of every namespace")
+	creds := fs.String("creds", "apoctl.json",
+		"Set the path to the application credentials with namespace administrator privileges")
+	logLevel := fs.String("log-level", log.Level.String(),
+		fmt.Sprintf("Set the logger log level, accepts one of: %v", logrus.AllLevels))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := setLogLevel(*logLevel); err != nil {
+		return err
+	}
+	if *namespace == "" {
+		return fmt.Errorf("no namespace")
+	}
+
+	mconf, err := testsetup.BackendClient(*creds)
+	if err != nil {
+		return err
+	}
+
+	var opts []testsetup.ExportOption
+	if *setup {
+		opts = append(opts, testsetup.OptionExportSetup())
+	}
+	nst, err := mconf.ExportNSTree(*namespace, opts...)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(nst)
+	if err != nil {
+		return fmt.Errorf("marshal namespace tree: %v", err)
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		return fmt.Errorf("write %s: %v", *output, err)
+	}
+
+	log.Infof("Exported %d namespaces of %s over %d levels to %s", nst.Size(), *namespace,
+		nst.Depth()+1, *output)
+	return nil
+}
+
+// importCmd creates a namespace tree written by exportCmd in a namespace of a backend.
+func importCmd(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	namespace := fs.String("namespace", "",
+		"Set the namespace in which the root of the tree is created")
+	input := fs.String("input", "nstree.yaml", "Set the path to the namespace tree file")
+	creds := fs.String("creds", "apoctl.json",
+		"Set the path to the application credentials with namespace administrator privileges")
+	logLevel := fs.String("log-level", log.Level.String(),
+		fmt.Sprintf("Set the logger log level, accepts one of: %v", logrus.AllLevels))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := setLogLevel(*logLevel); err != nil {
+		return err
+	}
+	if *namespace == "" {
+		return fmt.Errorf("no namespace")
+	}
+
+	data, err := os.ReadFile(*input)
+	if err != nil {
+		return fmt.Errorf("read %s: %v", *input, err)
+	}
+	var nst testsetup.NSTree
+	if err := yaml.Unmarshal(data, &nst); err != nil {
+		return fmt.Errorf("unmarshal %s: %v", *input, err)
+	}
+
+	mconf, err := testsetup.BackendClient(*creds)
+	if err != nil {
+		return err
+	}
+	if err := mconf.CreateNSTree(*namespace, &nst, []string{}); err != nil {
+		return err
+	}
+
+	log.Infof("Imported %d namespaces of %s to %s", nst.Size(), *input,
+		path.Join(*namespace, nst.Name))
+	return nil
+}
+
+// assertCmd checks the policies rendered for PUs against the expected outcomes of a test table.
+func assertCmd(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	tableFile := fs.String("table", "assertions.yaml", "Set the path to the test table")
+	namespace := fs.String("namespace", "",
+		"Set the namespace the relative PU namespaces of the table are in")
+	creds := fs.String("creds", "apoctl.json",
+		"Set the path to the application credentials with namespace administrator privileges")
+	logLevel := fs.String("log-level", log.Level.String(),
+		fmt.Sprintf("Set the logger log level, accepts one of: %v", logrus.AllLevels))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := setLogLevel(*logLevel); err != nil {
+		return err
+	}
+
+	table, err := policyassert.LoadTable(*tableFile)
+	if err != nil {
+		return err
+	}
+	mconf, err := testsetup.BackendClient(*creds)
+	if err != nil {
+		return err
+	}
+
+	results, err := policyassert.Check(mconf, *namespace, table)
+	if err != nil {
+		return err
+	}
+	fmt.Print(policyassert.Summary(results))
+	if failed := policyassert.Failed(results); failed > 0 {
+		return fmt.Errorf("%d of %d cases failed", failed, len(results))
+	}
+
+	return nil
+}
+
+// parseSteps parses comma separated <after>=<replicas> steps.
+func parseSteps(steps string) ([]churn.Step, error) {
+
+	if steps == "" {
+		return nil, nil
+	}
+
+	var parsed []churn.Step
+	for _, step := range strings.Split(steps, ",") {
+		after, replicas, ok := strings.Cut(step, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid step %q, expected <after>=<replicas>", step)
+		}
+		d, err := time.ParseDuration(after)
+		if err != nil {
+			return nil, fmt.Errorf("invalid step %q: %v", step, err)
+		}
+		r, err := strconv.Atoi(replicas)
+		if err != nil {
+			return nil, fmt.Errorf("invalid step %q: %v", step, err)
+		}
+		parsed = append(parsed, churn.Step{After: d, Replicas: r})
+	}
+
+	return parsed, nil
+}
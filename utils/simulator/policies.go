@@ -2,10 +2,14 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"math"
 	"path"
 
+	"go.aporeto.io/gaia"
 	"go.aporeto.io/simulator-test-harness/common"
+	"go.aporeto.io/simulator-test-harness/libs/chart"
+	"go.aporeto.io/simulator-test-harness/libs/tagging"
 	"go.aporeto.io/simulator-test-harness/libs/testsetup"
 	"go.aporeto.io/simulator-test-harness/utils/simulator/internal"
 )
@@ -20,11 +24,28 @@ func main() {
 	prefix := flag.String("prefix", "",
 		"The prefix to attach before the enforcer tag (prefix-$n-$m).")
 	batch := flag.Int("batch", 300, "The batch size.")
+	perPod := flag.Int("simulators-per-pod", 10, "The number of simulators per pod.")
+	mapping := flag.String("mapping", "",
+		fmt.Sprintf("The multi mapping strategy, one of %v (per-batch if the batch size is "+
+			"the capacity, per-simulator otherwise, if empty).", tagging.Strategies))
 	tag := flag.String("tag", "",
 		"The tag to use for the enforcers batch, in case of single mapping option")
 	capacity := flag.Int("capacity", 300, "The capacity of each namespace.")
 	createNamespaces := flag.Bool("create-namespaces", false,
 		"If set will create simulators/capacity namespaces.")
+	template := flag.String("template", "", fmt.Sprintf("The built-in template, among %v, or "+
+		"the path to the template file, applied to the namespaces created.", testsetup.Templates()))
+	templatePrefix := flag.String("template-prefix", "",
+		"The value of the {{prefix}} placeholder of the template.")
+	values := flag.String("values", "",
+		"The chart values file whose host services are created and mapped to the simulators.")
+	enforcerProfiles := flag.String("enforcer-profiles", "", fmt.Sprintf("The enforcer profiles, "+
+		"among %v or profile files, attached to the enforcers of the namespace: <rail>=<profile> "+
+		"per rail with --rail, or profiles cycled through the batches, each mapped to the "+
+		"simbase tag of its batch without.", testsetup.Profiles()))
+	rail := flag.String("rail", "", "The rail of the namespace, selecting its enforcer profile.")
+	batchIndex := flag.Int("batch-index", 1,
+		"The index of the batch of the namespace of a rail, selecting its enforcer profile.")
 	multiMapping := flag.Bool("multi-mapping", false,
 		"If set will create mapping policies from namespace to namespace/namespace-$i")
 	singleMapping := flag.Bool("single-mapping", false,
@@ -50,32 +71,59 @@ func main() {
 	if (*publicCount == 0) && (*pvtCount == 0) && (*protectedCount == 0) {
 
 		numNamespace := int(math.Ceil(float64(*simulators) / float64(*capacity)))
-		if *createNamespaces {
-			err := internal.SimNSTree(mconf, *namespace, numNamespace)
-			if err != nil {
-				common.Log.Fatalf("creating namespaces: %v", err)
-			}
-		}
 
+		var mappings []tagging.Mapping
 		if *multiMapping {
 			if *batch > *simulators {
 				common.Log.Fatalf("batch size cannot be larger than total enforcers")
 			}
+			if *perPod < 1 || *batch%*perPod != 0 {
+				common.Log.Fatalf("batch size must be a multiple of the simulators per pod")
+			}
 
-			if *batch == *capacity {
-				err := internal.BToNPolicies(mconf, *namespace, numNamespace, *prefix)
-				if err != nil {
-					common.Log.Fatalf("creating batch mappings: %v", err)
-				}
-			} else {
-				// Create numNamespace * n * m mapping policies from namespace to
-				// namespace/namespace-$i
-				err := internal.SimMappingPolicies(mconf, *namespace, *capacity,
-					numNamespace, *batch, *prefix)
-				if err != nil {
-					common.Log.Fatalf("creating multi mappings: %v", err)
+			scheme := tagging.Scheme{Strategy: tagging.Strategy(*mapping), Prefix: *prefix}
+			if scheme.Strategy == "" {
+				scheme.Strategy = tagging.DefaultStrategy(*batch, *capacity)
+			}
+			if scheme.Strategy == tagging.StrategyPerPod {
+				common.Log.Warnf("The pods of every batch b must be deployed with podTagPrefix "+
+					"%s-<b> and labelled %s=%s-<b>-<slot> by the orchestrator, or by hand",
+					*prefix, tagging.PodTagKey, *prefix)
+			}
+			plan := tagging.Plan{
+				Enforcers:  *simulators,
+				Pods:       *batch / *perPod,
+				Simulators: *perPod,
+				Capacity:   *capacity,
+			}
+
+			// The mappings may target more namespaces than simulators/capacity when the
+			// mapped enforcers do not fill the namespaces exactly.
+			mappings, numNamespace, err = scheme.Mappings(plan)
+			if err != nil {
+				common.Log.Fatalf("mapping enforcers: %v", err)
+			}
+		}
+
+		if *createNamespaces {
+			var t *testsetup.Template
+			if *template != "" {
+				if t, err = testsetup.LoadTemplate(*template); err != nil {
+					common.Log.Fatalf("loading template: %v", err)
 				}
 			}
+			err := internal.SimNSTree(mconf, *namespace, numNamespace, t,
+				testsetup.TemplateVars{Prefix: *templatePrefix})
+			if err != nil {
+				common.Log.Fatalf("creating namespaces: %v", err)
+			}
+		}
+
+		if *multiMapping {
+			// Create the mapping policies from namespace to namespace/namespace-$i
+			if err := internal.SimMappingPolicies(mconf, *namespace, mappings); err != nil {
+				common.Log.Fatalf("creating multi mappings: %v", err)
+			}
 		}
 
 		if *singleMapping {
@@ -86,9 +134,60 @@ func main() {
 				common.Log.Fatalf("creating one mapping policy: %v", err)
 			}
 		}
+
+		if *values != "" {
+			v, err := chart.LoadValues(*values)
+			if err != nil {
+				common.Log.Fatalf("loading values: %v", err)
+			}
+			var hss []*gaia.HostService
+			for _, hs := range v.HostServices {
+				hss = append(hss, hs.Object())
+			}
+			if err := internal.SimHostServices(mconf, *namespace, hss); err != nil {
+				common.Log.Fatalf("creating host services: %v", err)
+			}
+		}
 	} else {
 
 		common.Log.Infof("Rails namespace model is detected with parameters public=%d, private=%d, protected=%d",
 			*publicCount, *pvtCount, *protectedCount)
 	}
+
+	if *enforcerProfiles != "" {
+		sel, err := testsetup.ParseProfileSelection(*enforcerProfiles)
+		if err != nil {
+			common.Log.Fatalf("parsing enforcer profiles: %v", err)
+		}
+
+		if *rail != "" {
+			// The namespace of a rail holds the enforcers of a single batch.
+			if profile := sel.Select(*rail, *batchIndex); profile != "" {
+				err := internal.SimEnforcerProfile(mconf, *namespace, "simulator-profile-"+*rail,
+					profile, [][]string{{"$identity=enforcer"}})
+				if err != nil {
+					common.Log.Fatalf("attaching enforcer profile: %v", err)
+				}
+			}
+			return
+		}
+
+		// Without rails, every batch gets its profile, like with the orchestrator.
+		if len(sel.Rails) > 0 {
+			common.Log.Fatalf("rail enforcer profiles %v require --rail", sel.Rails)
+		}
+		if *prefix == "" || *batch < 1 {
+			common.Log.Fatalf("batch enforcer profiles require --prefix and --batch")
+		}
+		scheme := tagging.Scheme{Prefix: *prefix}
+		batches := (*simulators + *batch - 1) / *batch
+		for b := 1; b <= batches; b++ {
+			err := internal.SimEnforcerProfile(mconf, *namespace,
+				fmt.Sprintf("simulator-profile-%d", b), sel.Select("", b),
+				[][]string{{scheme.BatchTag(b)}})
+			if err != nil {
+				common.Log.Fatalf("attaching enforcer profile of batch %d: %v", b, err)
+			}
+		}
+	}
 }
@@ -18,6 +18,8 @@ FLOW_COUNT_INTERVAL="5m"
 PODS=25
 # rails support for namespace structure.
 declare -A RAILSPODS=([public]=0 [private]=0 [protected]=0)
+# enforcer profiles of the rails (<rail>=<profile>) or cycled through the tenants.
+ENFORCER_PROFILES=""
 
 SIMULATORS=10
 INIT_DELAY=5
@@ -67,6 +69,7 @@ $APOCTL_CREDENTIALS.
     --public                 Number of simulators in public rail.
     --private                Number of simulators in private rail.
     --protected              Number of simulators in protected rail.
+    --enforcer-profiles ARG  The enforcer profiles (minimal, standard, large or profile files), as <rail>=<profile> per rail or cycled through the tenants. Default: empty profiles.
     To activate rails model at least one or more counts on public, private & protected must be defined. Capacity and rails are mutually exclusive. If both are specified, only rails configuration is used for tests.
     -h, --help               Prints the usage.
 
@@ -224,6 +227,15 @@ while (($#)); do
       usage 1
     fi
     ;;
+  --enforcer-profiles)
+    shift
+    if [ ! -z "$1" ]; then
+      ENFORCER_PROFILES="$1"
+      shift
+    else
+      usage 1
+    fi
+    ;;
   -h | --help)
     shift
     usage 0
@@ -252,6 +264,7 @@ is_installed "$POLICIES"
 HELM_TEMPLATE="helm template $CHARTS"
 if test -f values.yaml; then
   HELM_TEMPLATE="$HELM_TEMPLATE -f values.yaml"
+  POLICIES_VALUES="--values values.yaml"
 fi
 HELM_TEMPLATE="$HELM_TEMPLATE \
   --set pods=$PODS,simulatorsPerPod=$SIMULATORS,initDelay=$INIT_DELAY"
@@ -440,10 +453,10 @@ wait_to_stabilize() {
     fi
     # actual enforcers are the enforcers connected according to
     actual_enforcers=$(count_enforcers "$1")
-    # the expected enforcers are the containers on all running pods
+    # the expected enforcers are the containers on all running pods, but the fault proxy sidecars
     expected_enforcers=$($KUBECTL get pods -n $NS \
       --field-selector 'status.phase==Running' \
-      -o jsonpath={.items[*].spec.containers[*].name} | wc -w)
+      -o jsonpath={.items[*].spec.containers[*].name} | tr ' ' '\n' | grep -cvx fault-proxy)
     echo "enforcers in $NS: $actual_enforcers"
   done
 
@@ -518,7 +531,12 @@ if [[ "$RAILS" = true ]]; then
       --namespace $APORETO_BASE_NAMESPACE/$NAMESPACE/$NS --role @auth:role=enforcer" >aporeto.creds
 
       echo "creating enforcer profile under $APORETO_BASE_NAMESPACE/$NAMESPACE/$NS"
-      insist_run "$APOCTL api create enforcerprofiles -k name $NS --namespace $APORETO_BASE_NAMESPACE/$NAMESPACE/$NS"
+      if [ -z "$ENFORCER_PROFILES" ]; then
+        insist_run "$APOCTL api create enforcerprofiles -k name $NS --namespace $APORETO_BASE_NAMESPACE/$NAMESPACE/$NS"
+      else
+        insist_run "$POLICIES --namespace $APORETO_BASE_NAMESPACE/$NAMESPACE/$NS \
+        --enforcer-profiles $ENFORCER_PROFILES --rail $NS --batch-index $tenants"
+      fi
 
       sleep 10
 
@@ -534,7 +552,7 @@ if [[ "$RAILS" = true ]]; then
 
       echo "applying configmaps and jobs on $NAMESPACE"
       DEPNAME="$NAMESPACE-$NS"
-      $HELM_TEMPLATE --set enforcerTagPrefix="$TAG_PREFIX$(($tenants - 1))" \
+      $HELM_TEMPLATE --set enforcerTagPrefix="$TAG_PREFIX-$tenants" \
         --set k8sNS=\"$k8sNS\" \
         --set depName=\"$DEPNAME\" \
         --set k8sSecret=\"enforcerd-$NAMESPACE-$NS\" \
@@ -552,7 +570,9 @@ else
     set -e
     $POLICIES --simulators $TOTAL_ENFORCERS --capacity $NAMESPACE_CAPACITY \
       --namespace $APORETO_BASE_NAMESPACE/$NAMESPACE --batch $BATCH_SIZE \
-      --multi-mapping --create-namespaces --prefix $TAG_PREFIX
+      --simulators-per-pod $SIMULATORS \
+      --multi-mapping --create-namespaces --prefix $TAG_PREFIX $POLICIES_VALUES \
+      ${ENFORCER_PROFILES:+--enforcer-profiles $ENFORCER_PROFILES}
     set +e
   }
 
@@ -574,7 +594,7 @@ else
 
     echo "applying configmaps and jobs on $NAMESPACE"
     DEPNAME="$NAMESPACE-pods-$(random_string 6)"
-    $HELM_TEMPLATE --set enforcerTagPrefix="$TAG_PREFIX$(($batches - 1))" \
+    $HELM_TEMPLATE --set enforcerTagPrefix="$TAG_PREFIX-$batches" \
       --set k8sNS=\"$NAMESPACE\" \
       --set k8sSecret=\"enforcerd\" \
       --set depName=\"$DEPNAME\" \